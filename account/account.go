@@ -0,0 +1,683 @@
+package account
+
+import (
+	"context"
+	"errors"
+	"math"
+	"my-finance-backend/config"
+	"my-finance-backend/utils"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrNotFound         = errors.New("Account not found")
+	ErrArchived         = errors.New("Account is archived")
+	ErrCurrencyMismatch = errors.New("Currency does not match the account currency")
+)
+
+var accountTypes = map[string]bool{
+	TypeCash:       true,
+	TypeBank:       true,
+	TypeCreditCard: true,
+	TypeEWallet:    true,
+	TypeOther:      true,
+}
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+	}
+}
+
+// Check verifies that money in currencyCode can be recorded in the user's account
+func Check(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string, accountID string, currencyCode string) error {
+	objectId, err := utils.StringToObjectId(accountID)
+	if err != nil {
+		return ErrNotFound
+	}
+
+	var account Account
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionAccountsName)
+	err = collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if account.Archived {
+		return ErrArchived
+	}
+	if account.CurrencyCode != currencyCode {
+		return ErrCurrencyMismatch
+	}
+	return nil
+}
+
+// CheckStatus maps the errors of Check to an HTTP status
+func CheckStatus(err error) int {
+	switch err {
+	case ErrNotFound:
+		return http.StatusNotFound
+	case ErrArchived, ErrCurrencyMismatch:
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// sumBy adds up field over the documents matching filter, grouped by groupField
+func sumBy(ctx context.Context, collection *mongo.Collection, filter bson.M, groupField string, field string) (map[string]float64, error) {
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.M{"_id": "$" + groupField, "total": bson.M{"$sum": "$" + field}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		ID    string  `bson:"_id"`
+		Total float64 `bson:"total"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	sums := make(map[string]float64, len(results))
+	for _, result := range results {
+		sums[result.ID] = result.Total
+	}
+	return sums, nil
+}
+
+// ComputeBalances sets the Balance of each account from its opening balance and the
+// movements dated before the given date, or all movements when before is empty
+func ComputeBalances(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string, accounts []Account, before string) error {
+	if len(accounts) == 0 {
+		return nil
+	}
+	accountIDs := make([]string, 0, len(accounts))
+	for _, account := range accounts {
+		accountIDs = append(accountIDs, account.ID)
+	}
+	database := mongoClient.Database(config.DatabaseName)
+	match := func(field string) bson.M {
+		filter := bson.M{"user_id": userID, field: bson.M{"$in": accountIDs}}
+		if before != "" {
+			filter["date"] = bson.M{"$lt": before}
+		}
+		return filter
+	}
+
+	expenses, err := sumBy(ctx, database.Collection(config.CollectionExpensesName), utils.NotDeleted(match("account_id")), "account_id", "amount")
+	if err != nil {
+		return err
+	}
+	incomes, err := sumBy(ctx, database.Collection(config.CollectionIncomesName), match("account_id"), "account_id", "amount")
+	if err != nil {
+		return err
+	}
+	transfersOut, err := sumBy(ctx, database.Collection(config.CollectionTransfersName), match("from_account_id"), "from_account_id", "amount")
+	if err != nil {
+		return err
+	}
+	transfersIn, err := sumBy(ctx, database.Collection(config.CollectionTransfersName), match("to_account_id"), "to_account_id", "to_amount")
+	if err != nil {
+		return err
+	}
+
+	for i := range accounts {
+		id := accounts[i].ID
+		accounts[i].Balance = utils.RoundAmount(accounts[i].OpeningBalance + incomes[id] + transfersIn[id] - expenses[id] - transfersOut[id])
+	}
+	return nil
+}
+
+// findAccount loads the account of the id route parameter, writing the error response on failure
+func (h *Handler) findAccount(ctx context.Context, c *gin.Context, userID string) (Account, bool) {
+	var account Account
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID " + error.Error()})
+		return account, false
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAccountsName)
+	err := collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&account)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return account, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch account"})
+		return account, false
+	}
+	return account, true
+}
+
+// Create account
+func (h *Handler) HandleCreateAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Type == "" {
+		req.Type = TypeOther
+	}
+	if !accountTypes[req.Type] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be cash, bank, credit_card, e_wallet or other"})
+		return
+	}
+
+	account := Account{
+		UserID:         userID,
+		Name:           strings.TrimSpace(req.Name),
+		Type:           req.Type,
+		CurrencyCode:   req.CurrencyCode,
+		OpeningBalance: req.OpeningBalance,
+		CreatedAt:      utils.Timestamp(),
+	}
+	account.UpdatedAt = account.CreatedAt
+	account.Balance = account.OpeningBalance
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAccountsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	count, err := collection.CountDocuments(ctx, bson.M{"user_id": userID, "name": account.Name})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch accounts"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account with this name already exists"})
+		return
+	}
+
+	result, err := collection.InsertOne(ctx, account)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create account"})
+		return
+	}
+	account.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	c.JSON(http.StatusCreated, account)
+}
+
+// Get the user's accounts with their current balance. Archived accounts are only
+// included with archived=true.
+func (h *Handler) HandleGetAccounts(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAccountsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID}
+	if c.Query("archived") != "true" {
+		filter["archived"] = bson.M{"$ne": true}
+	}
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "name", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch accounts"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var accounts []Account = make([]Account, 0)
+	if err = cursor.All(ctx, &accounts); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode accounts"})
+		return
+	}
+	if err := ComputeBalances(ctx, h.mongoClient, h.config, userID, accounts, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute balances"})
+		return
+	}
+
+	c.JSON(http.StatusOK, accounts)
+}
+
+// Get single account with its current balance
+func (h *Handler) HandleGetAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	account, ok := h.findAccount(ctx, c, userID)
+	if !ok {
+		return
+	}
+	accounts := []Account{account}
+	if err := ComputeBalances(ctx, h.mongoClient, h.config, userID, accounts, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, accounts[0])
+}
+
+// Update account
+func (h *Handler) HandleUpdateAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req UpdateAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	account, ok := h.findAccount(ctx, c, userID)
+	if !ok {
+		return
+	}
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAccountsName)
+
+	update := bson.M{}
+	if name := strings.TrimSpace(req.Name); name != "" && name != account.Name {
+		count, err := collection.CountDocuments(ctx, bson.M{"user_id": userID, "name": name})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch accounts"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Account with this name already exists"})
+			return
+		}
+		account.Name = name
+		update["name"] = name
+	}
+	if req.Type != "" {
+		if !accountTypes[req.Type] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "type must be cash, bank, credit_card, e_wallet or other"})
+			return
+		}
+		account.Type = req.Type
+		update["type"] = req.Type
+	}
+	if req.OpeningBalance != nil {
+		account.OpeningBalance = *req.OpeningBalance
+		update["opening_balance"] = account.OpeningBalance
+	}
+	if req.Archived != nil {
+		account.Archived = *req.Archived
+		update["archived"] = account.Archived
+	}
+	account.UpdatedAt = utils.Timestamp()
+	update["updated_at"] = account.UpdatedAt
+
+	objectId, _ := utils.StringToObjectId(account.ID)
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": objectId, "user_id": userID}, bson.M{"$set": update}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update account"})
+		return
+	}
+
+	accounts := []Account{account}
+	if err := ComputeBalances(ctx, h.mongoClient, h.config, userID, accounts, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute balance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, accounts[0])
+}
+
+// Delete an account without transactions. Accounts in use can be archived instead.
+func (h *Handler) HandleDeleteAccount(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	account, ok := h.findAccount(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	checks := []struct {
+		collection string
+		filter     bson.M
+	}{
+		{h.config.CollectionExpensesName, bson.M{"user_id": userID, "account_id": account.ID}},
+		{h.config.CollectionIncomesName, bson.M{"user_id": userID, "account_id": account.ID}},
+		{h.config.CollectionTransfersName, bson.M{"user_id": userID, "$or": bson.A{
+			bson.M{"from_account_id": account.ID},
+			bson.M{"to_account_id": account.ID},
+		}}},
+	}
+	for _, check := range checks {
+		count, err := database.Collection(check.collection).CountDocuments(ctx, check.filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not check account transactions"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Account has transactions, archive it instead"})
+			return
+		}
+	}
+
+	objectId, _ := utils.StringToObjectId(account.ID)
+	if _, err := database.Collection(h.config.CollectionAccountsName).DeleteOne(ctx, bson.M{"_id": objectId, "user_id": userID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete account"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account deleted successfully"})
+}
+
+// HandleCreateTransfer moves money between two accounts of the user
+func (h *Handler) HandleCreateTransfer(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateTransferRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Amount <= 0 || req.ToAmount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Transfer amounts must be positive"})
+		return
+	}
+	if req.FromAccountID == req.ToAccountID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot transfer to the same account"})
+		return
+	}
+	if req.Date == "" {
+		req.Date = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAccountsName)
+	var accounts [2]Account
+	for i, accountID := range []string{req.FromAccountID, req.ToAccountID} {
+		objectId, err := utils.StringToObjectId(accountID)
+		if err == nil {
+			err = collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&accounts[i])
+		}
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Account not found: " + accountID})
+			return
+		}
+		if accounts[i].Archived {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Account is archived: " + accounts[i].Name})
+			return
+		}
+	}
+
+	if req.ToAmount == 0 {
+		if accounts[0].CurrencyCode != accounts[1].CurrencyCode {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to_amount is required between accounts in different currencies"})
+			return
+		}
+		req.ToAmount = req.Amount
+	} else if accounts[0].CurrencyCode == accounts[1].CurrencyCode && math.Abs(req.ToAmount-req.Amount) > 0.005 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to_amount must equal amount between accounts in the same currency"})
+		return
+	}
+
+	transfer := Transfer{
+		UserID:        userID,
+		FromAccountID: req.FromAccountID,
+		ToAccountID:   req.ToAccountID,
+		Amount:        req.Amount,
+		ToAmount:      req.ToAmount,
+		Date:          req.Date,
+		Note:          req.Note,
+		CreatedAt:     utils.Timestamp(),
+	}
+	result, err := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionTransfersName).InsertOne(ctx, transfer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create transfer"})
+		return
+	}
+	transfer.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	c.JSON(http.StatusCreated, transfer)
+}
+
+// Delete transfer
+func (h *Handler) HandleDeleteTransfer(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transfer ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionTransfersName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{
+		"_id":                    objectId,
+		"user_id":                userID,
+		"reconciled_account_ids": bson.M{"$in": bson.A{nil, bson.A{}}},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete transfer"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		if count, err := collection.CountDocuments(ctx, bson.M{"_id": objectId, "user_id": userID}); err == nil && count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Transfer is reconciled and cannot be deleted"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transfer not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Transfer deleted successfully"})
+}
+
+// ledgerEntry decodes the fields of expenses, incomes and transfers needed for an account listing
+type ledgerEntry struct {
+	ID                   string   `bson:"_id"`
+	Date                 string   `bson:"date"`
+	Name                 string   `bson:"name"`
+	CategoryID           string   `bson:"category_id"`
+	Amount               float64  `bson:"amount"`
+	ToAmount             float64  `bson:"to_amount"`
+	FromAccountID        string   `bson:"from_account_id"`
+	ToAccountID          string   `bson:"to_account_id"`
+	ReconciliationID     string   `bson:"reconciliation_id"`
+	ReconciledAccountIDs []string `bson:"reconciled_account_ids"`
+}
+
+// Transactions loads the movements of an account in date order, optionally limited by a
+// date condition. The Balance of the returned transactions is left for the caller to fill.
+func Transactions(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string, accountID string, dateFilter bson.M) ([]Transaction, error) {
+	database := mongoClient.Database(config.DatabaseName)
+	sources := []struct {
+		collection string
+		filter     bson.M
+	}{
+		{config.CollectionExpensesName, utils.NotDeleted(bson.M{"user_id": userID, "account_id": accountID})},
+		{config.CollectionIncomesName, bson.M{"user_id": userID, "account_id": accountID}},
+		{config.CollectionTransfersName, bson.M{"user_id": userID, "$or": bson.A{
+			bson.M{"from_account_id": accountID},
+			bson.M{"to_account_id": accountID},
+		}}},
+	}
+
+	transactions := make([]Transaction, 0)
+	for _, source := range sources {
+		if len(dateFilter) > 0 {
+			source.filter["date"] = dateFilter
+		}
+		cursor, err := database.Collection(source.collection).Find(ctx, source.filter)
+		if err != nil {
+			return nil, err
+		}
+		var entries []ledgerEntry
+		if err = cursor.All(ctx, &entries); err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			transaction := Transaction{
+				ID:         entry.ID,
+				Date:       entry.Date,
+				Name:       entry.Name,
+				CategoryID: entry.CategoryID,
+				Reconciled: entry.ReconciliationID != "",
+			}
+			switch source.collection {
+			case config.CollectionExpensesName:
+				transaction.Type = TransactionExpense
+				transaction.Amount = -entry.Amount
+			case config.CollectionIncomesName:
+				transaction.Type = TransactionIncome
+				transaction.Amount = entry.Amount
+			default:
+				if entry.FromAccountID == accountID {
+					transaction.Type = TransactionTransferOut
+					transaction.Amount = -entry.Amount
+					transaction.CounterpartAccountID = entry.ToAccountID
+				} else {
+					transaction.Type = TransactionTransferIn
+					transaction.Amount = entry.ToAmount
+					transaction.CounterpartAccountID = entry.FromAccountID
+				}
+				for _, reconciledID := range entry.ReconciledAccountIDs {
+					if reconciledID == accountID {
+						transaction.Reconciled = true
+					}
+				}
+			}
+			transactions = append(transactions, transaction)
+		}
+	}
+
+	// Object IDs start with their creation time, so they order same-day transactions
+	sort.SliceStable(transactions, func(i, j int) bool {
+		if transactions[i].Date != transactions[j].Date {
+			return transactions[i].Date < transactions[j].Date
+		}
+		return transactions[i].ID < transactions[j].ID
+	})
+	return transactions, nil
+}
+
+// HandleGetTransactions lists the movements of an account in date order with the running
+// balance after each. Optional from and to (YYYY-MM-DD, inclusive) limit the period.
+func (h *Handler) HandleGetTransactions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	dateFilter := bson.M{}
+	from, to := c.Query("from"), c.Query("to")
+	if from != "" {
+		if _, err := time.Parse("2006-01-02", from); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from parameter, expected YYYY-MM-DD"})
+			return
+		}
+		dateFilter["$gte"] = from
+	}
+	if to != "" {
+		if _, err := time.Parse("2006-01-02", to); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to parameter, expected YYYY-MM-DD"})
+			return
+		}
+		dateFilter["$lte"] = to
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	account, ok := h.findAccount(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	accounts := []Account{account}
+	if err := ComputeBalances(ctx, h.mongoClient, h.config, userID, accounts, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute balance"})
+		return
+	}
+	response := GetTransactionsResponse{
+		Account:        accounts[0],
+		OpeningBalance: account.OpeningBalance,
+	}
+
+	// The running balance starts from the balance before the period
+	if from != "" {
+		if err := ComputeBalances(ctx, h.mongoClient, h.config, userID, accounts, from); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute balance"})
+			return
+		}
+		response.OpeningBalance = accounts[0].Balance
+	}
+
+	transactions, err := Transactions(ctx, h.mongoClient, h.config, userID, account.ID, dateFilter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch transactions"})
+		return
+	}
+	balance := response.OpeningBalance
+	for i := range transactions {
+		balance = utils.RoundAmount(balance + transactions[i].Amount)
+		transactions[i].Balance = balance
+	}
+	response.Transactions = transactions
+	response.ClosingBalance = balance
+
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,92 @@
+package account
+
+const (
+	TypeCash       = "cash"
+	TypeBank       = "bank"
+	TypeCreditCard = "credit_card"
+	TypeEWallet    = "e_wallet"
+	TypeOther      = "other"
+
+	TransactionExpense     = "expense"
+	TransactionIncome      = "income"
+	TransactionTransferIn  = "transfer_in"
+	TransactionTransferOut = "transfer_out"
+)
+
+// Account is where money is kept and spent from: cash, a bank account, a credit card
+// or an e-wallet. Its balance is the opening balance plus incomes and incoming
+// transfers, minus expenses and outgoing transfers.
+type Account struct {
+	ID             string  `bson:"_id,omitempty" json:"id"`
+	UserID         string  `bson:"user_id" json:"user_id"`
+	Name           string  `bson:"name" json:"name"`
+	Type           string  `bson:"type" json:"type"`
+	CurrencyCode   string  `bson:"currency_code" json:"currency_code"`
+	OpeningBalance float64 `bson:"opening_balance" json:"opening_balance"`
+	Archived       bool    `bson:"archived" json:"archived"`
+	CreatedAt      string  `bson:"created_at" json:"created_at"`
+	UpdatedAt      string  `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	Balance        float64 `bson:"-" json:"balance"` // computed on read
+}
+
+type CreateAccountRequest struct {
+	Name           string  `json:"name" binding:"required"`
+	Type           string  `json:"type"`
+	CurrencyCode   string  `json:"currency_code" binding:"required"`
+	OpeningBalance float64 `json:"opening_balance"`
+}
+
+// UpdateAccountRequest cannot change the currency, which would change the meaning of
+// every amount already recorded in the account
+type UpdateAccountRequest struct {
+	Name           string   `json:"name"`
+	Type           string   `json:"type"`
+	OpeningBalance *float64 `json:"opening_balance"`
+	Archived       *bool    `json:"archived"`
+}
+
+// Transfer moves money between two accounts of the user. ToAmount is what reaches the
+// destination account and differs from Amount when the currencies differ.
+type Transfer struct {
+	ID            string  `bson:"_id,omitempty" json:"id"`
+	UserID        string  `bson:"user_id" json:"user_id"`
+	FromAccountID string  `bson:"from_account_id" json:"from_account_id"`
+	ToAccountID   string  `bson:"to_account_id" json:"to_account_id"`
+	Amount        float64 `bson:"amount" json:"amount"`
+	ToAmount      float64 `bson:"to_amount" json:"to_amount"`
+	Date          string  `bson:"date" json:"date"`
+	Note          string  `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt     string  `bson:"created_at" json:"created_at"`
+	// ReconciledAccountIDs are the accounts whose statement reconciliation covers the transfer
+	ReconciledAccountIDs []string `bson:"reconciled_account_ids,omitempty" json:"reconciled_account_ids,omitempty"`
+}
+
+type CreateTransferRequest struct {
+	FromAccountID string  `json:"from_account_id" binding:"required"`
+	ToAccountID   string  `json:"to_account_id" binding:"required"`
+	Amount        float64 `json:"amount" binding:"required"`
+	ToAmount      float64 `json:"to_amount"` // required when the account currencies differ
+	Date          string  `json:"date"`
+	Note          string  `json:"note"`
+}
+
+// Transaction is a movement of an account with the balance right after it
+type Transaction struct {
+	Type       string `json:"type"` // expense, income, transfer_in or transfer_out
+	ID         string `json:"id"`
+	Date       string `json:"date"`
+	Name       string `json:"name,omitempty"`
+	CategoryID string `json:"category_id,omitempty"`
+	// CounterpartAccountID is the other account of a transfer
+	CounterpartAccountID string  `json:"counterpart_account_id,omitempty"`
+	Amount               float64 `json:"amount"` // negative when money leaves the account
+	Balance              float64 `json:"balance"`
+	Reconciled           bool    `json:"reconciled"` // matched against a completed statement reconciliation
+}
+
+type GetTransactionsResponse struct {
+	Account        Account       `json:"account"`
+	OpeningBalance float64       `json:"opening_balance"` // balance before the first listed transaction
+	ClosingBalance float64       `json:"closing_balance"`
+	Transactions   []Transaction `json:"transactions"`
+}
@@ -0,0 +1,341 @@
+package attachment
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"my-finance-backend/config"
+	"my-finance-backend/utils"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// multipartOverhead is how much larger than the file an upload body may be, for the
+// multipart boundaries and headers
+const multipartOverhead = 64 << 10
+
+// allowedContentTypes are the accepted attachment types, detected from the file contents
+var allowedContentTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+	store       BlobStore
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config, store BlobStore) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+		store:       store,
+	}
+}
+
+// checkExpense verifies that the expense of the id route parameter exists and belongs to
+// the user, writing the error response otherwise
+func (h *Handler) checkExpense(ctx context.Context, c *gin.Context, userID string) bool {
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expense ID " + error.Error()})
+		return false
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+	count, err := collection.CountDocuments(ctx, utils.NotDeleted(bson.M{"_id": objectId, "user_id": userID}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expense"})
+		return false
+	}
+	if count == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		return false
+	}
+	return true
+}
+
+// findAttachment loads the attachment of the attachment_id route parameter, writing the
+// error response on failure
+func (h *Handler) findAttachment(ctx context.Context, c *gin.Context, userID string) (Attachment, bool) {
+	var attachment Attachment
+
+	objectId, error := utils.StringToObjectId(c.Param("attachment_id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid attachment ID " + error.Error()})
+		return attachment, false
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAttachmentsName)
+	err := collection.FindOne(ctx, bson.M{"_id": objectId, "expense_id": c.Param("id"), "user_id": userID}).Decode(&attachment)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment not found"})
+		return attachment, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch attachment"})
+		return attachment, false
+	}
+	return attachment, true
+}
+
+// HandleUploadAttachment stores the multipart "file" field as an attachment of the expense.
+// Images get a thumbnail, except WebP which the standard library cannot decode.
+func (h *Handler) HandleUploadAttachment(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if !h.checkExpense(ctx, c, userID) {
+		return
+	}
+
+	maxSize := int64(h.config.AttachmentMaxSizeMB) << 20
+	// Stop reading oversized bodies before they are buffered, leaving room for the multipart framing
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxSize+multipartOverhead)
+	fileHeader, err := c.FormFile("file")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File is larger than " + strconv.Itoa(h.config.AttachmentMaxSizeMB) + " MB"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return
+	}
+	if fileHeader.Size > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File is larger than " + strconv.Itoa(h.config.AttachmentMaxSizeMB) + " MB"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not open file"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read file"})
+		return
+	}
+	if int64(len(data)) > maxSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "File is larger than " + strconv.Itoa(h.config.AttachmentMaxSizeMB) + " MB"})
+		return
+	}
+	if len(data) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is empty"})
+		return
+	}
+
+	// The declared content type is not trusted, the type is sniffed from the contents
+	contentType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	if !allowedContentTypes[contentType] {
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "Unsupported file type " + contentType + ", expected a JPEG, PNG, GIF or WebP image or a PDF"})
+		return
+	}
+
+	attachment := Attachment{
+		UserID:      userID,
+		ExpenseID:   c.Param("id"),
+		FileName:    filepath.Base(fileHeader.Filename),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		StorageKey:  userID + "/" + primitive.NewObjectID().Hex(),
+		CreatedAt:   utils.Timestamp(),
+	}
+
+	if err := h.store.Put(ctx, attachment.StorageKey, contentType, data); err != nil {
+		log.Printf("Could not store attachment: %v\n", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not store attachment"})
+		return
+	}
+
+	if strings.HasPrefix(contentType, "image/") && contentType != "image/webp" {
+		if thumbnail, err := makeThumbnail(data); err != nil {
+			log.Printf("Could not create thumbnail: %v\n", err)
+		} else if err := h.store.Put(ctx, attachment.StorageKey+"_thumb", "image/jpeg", thumbnail); err != nil {
+			log.Printf("Could not store thumbnail: %v\n", err)
+		} else {
+			attachment.ThumbnailKey = attachment.StorageKey + "_thumb"
+			attachment.ThumbnailSize = int64(len(thumbnail))
+			attachment.HasThumbnail = true
+		}
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAttachmentsName)
+	result, err := collection.InsertOne(ctx, attachment)
+	if err != nil {
+		h.deleteBlobs(ctx, attachment)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save attachment"})
+		return
+	}
+	attachment.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// Get all attachments of an expense
+func (h *Handler) HandleGetAttachments(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if !h.checkExpense(ctx, c, userID) {
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAttachmentsName)
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.M{"expense_id": c.Param("id"), "user_id": userID}, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch attachments"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var attachments []Attachment = make([]Attachment, 0)
+	if err = cursor.All(ctx, &attachments); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode attachments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, attachments)
+}
+
+// HandleDownloadAttachment streams the attachment contents, or its thumbnail with thumbnail=true
+func (h *Handler) HandleDownloadAttachment(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if !h.checkExpense(ctx, c, userID) {
+		return
+	}
+	attachment, ok := h.findAttachment(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	key, size, contentType := attachment.StorageKey, attachment.Size, attachment.ContentType
+	if c.Query("thumbnail") == "true" {
+		if !attachment.HasThumbnail {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Attachment has no thumbnail"})
+			return
+		}
+		key, size, contentType = attachment.ThumbnailKey, attachment.ThumbnailSize, "image/jpeg"
+	}
+
+	reader, err := h.store.Get(ctx, key)
+	if err == ErrBlobNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment contents not found"})
+		return
+	} else if err != nil {
+		log.Printf("Could not read attachment %s: %v\n", attachment.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read attachment"})
+		return
+	}
+	defer reader.Close()
+
+	c.DataFromReader(http.StatusOK, size, contentType, reader, map[string]string{
+		"Content-Disposition": mime.FormatMediaType("inline", map[string]string{"filename": attachment.FileName}),
+	})
+}
+
+// Delete attachment
+func (h *Handler) HandleDeleteAttachment(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	attachment, ok := h.findAttachment(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	objectId, _ := utils.StringToObjectId(attachment.ID)
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAttachmentsName)
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": objectId}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete attachment"})
+		return
+	}
+	h.deleteBlobs(ctx, attachment)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Attachment deleted successfully"})
+}
+
+// deleteBlobs removes the contents and thumbnail of an attachment. Failures are only
+// logged: the attachment record is gone, so a leftover blob is merely wasted space.
+func (h *Handler) deleteBlobs(ctx context.Context, attachment Attachment) {
+	if err := h.store.Delete(ctx, attachment.StorageKey); err != nil {
+		log.Printf("Could not delete attachment blob %s: %v\n", attachment.StorageKey, err)
+	}
+	if attachment.ThumbnailKey != "" {
+		if err := h.store.Delete(ctx, attachment.ThumbnailKey); err != nil {
+			log.Printf("Could not delete attachment blob %s: %v\n", attachment.ThumbnailKey, err)
+		}
+	}
+}
+
+// DeleteForExpenses removes the attachments of expenses that are permanently deleted
+func (h *Handler) DeleteForExpenses(ctx context.Context, expenseIDs []string) error {
+	if len(expenseIDs) == 0 {
+		return nil
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAttachmentsName)
+	filter := bson.M{"expense_id": bson.M{"$in": expenseIDs}}
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var attachments []Attachment
+	if err = cursor.All(ctx, &attachments); err != nil {
+		return err
+	}
+	if len(attachments) == 0 {
+		return nil
+	}
+
+	if _, err := collection.DeleteMany(ctx, filter); err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		h.deleteBlobs(ctx, attachment)
+	}
+	return nil
+}
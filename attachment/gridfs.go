@@ -0,0 +1,68 @@
+package attachment
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// GridFSStore keeps blobs in a GridFS bucket of the application database, using the
+// key as the file ID
+type GridFSStore struct {
+	database   *mongo.Database
+	bucketName string
+}
+
+// bucket returns a new bucket handle: deadlines are per handle, so handles are not shared
+func (s *GridFSStore) bucket(ctx context.Context) (*gridfs.Bucket, error) {
+	bucket, err := gridfs.NewBucket(s.database, options.GridFSBucket().SetName(s.bucketName))
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		bucket.SetReadDeadline(deadline)
+		bucket.SetWriteDeadline(deadline)
+	}
+	return bucket, nil
+}
+
+func (s *GridFSStore) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	bucket, err := s.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	uploadOptions := options.GridFSUpload().SetMetadata(bson.M{"content_type": contentType})
+	return bucket.UploadFromStreamWithID(key, key, bytes.NewReader(data), uploadOptions)
+}
+
+func (s *GridFSStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	bucket, err := s.bucket(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stream, err := bucket.OpenDownloadStream(key)
+	if errors.Is(err, gridfs.ErrFileNotFound) {
+		return nil, ErrBlobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+func (s *GridFSStore) Delete(ctx context.Context, key string) error {
+	bucket, err := s.bucket(ctx)
+	if err != nil {
+		return err
+	}
+	if err := bucket.DeleteContext(ctx, key); err != nil && !errors.Is(err, gridfs.ErrFileNotFound) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package attachment
+
+// Attachment is a file such as a receipt photo or an invoice kept with an expense.
+// The contents live in the blob store under StorageKey.
+type Attachment struct {
+	ID            string `bson:"_id,omitempty" json:"id"`
+	UserID        string `bson:"user_id" json:"user_id"`
+	ExpenseID     string `bson:"expense_id" json:"expense_id"`
+	FileName      string `bson:"file_name" json:"file_name"`
+	ContentType   string `bson:"content_type" json:"content_type"`
+	Size          int64  `bson:"size" json:"size"`
+	StorageKey    string `bson:"storage_key" json:"-"`
+	ThumbnailKey  string `bson:"thumbnail_key,omitempty" json:"-"`
+	ThumbnailSize int64  `bson:"thumbnail_size,omitempty" json:"-"`
+	HasThumbnail  bool   `bson:"has_thumbnail" json:"has_thumbnail"`
+	CreatedAt     string `bson:"created_at" json:"created_at"`
+}
@@ -0,0 +1,100 @@
+package attachment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"my-finance-backend/config"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	StorageLocal  = "local"
+	StorageGridFS = "gridfs"
+	StorageS3     = "s3"
+)
+
+// ErrBlobNotFound is returned by BlobStore.Get when no blob is stored under the key
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore keeps attachment contents. Keys are slash separated and made of
+// letters, digits, underscores and dots only.
+type BlobStore interface {
+	Put(ctx context.Context, key string, contentType string, data []byte) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete removes the blob; deleting a missing blob is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// NewBlobStore returns the store selected by config.AttachmentStorage
+func NewBlobStore(mongoClient *mongo.Client, config *config.Config) (BlobStore, error) {
+	switch config.AttachmentStorage {
+	case "", StorageLocal:
+		return &LocalStore{root: config.AttachmentLocalDir}, nil
+	case StorageGridFS:
+		return &GridFSStore{database: mongoClient.Database(config.DatabaseName), bucketName: config.CollectionAttachmentsName}, nil
+	case StorageS3:
+		if config.S3Endpoint == "" || config.S3Bucket == "" {
+			return nil, errors.New("S3_ENDPOINT and S3_BUCKET are required for s3 attachment storage")
+		}
+		return NewS3Store(config.S3Endpoint, config.S3Region, config.S3Bucket, config.S3AccessKey, config.S3SecretKey), nil
+	default:
+		return nil, fmt.Errorf("unknown attachment storage %q, expected local, gridfs or s3", config.AttachmentStorage)
+	}
+}
+
+// LocalStore keeps blobs as files below a root directory
+type LocalStore struct {
+	root string
+}
+
+func (s *LocalStore) path(key string) (string, error) {
+	if key == "" || strings.Contains(key, "..") || strings.HasPrefix(key, "/") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, contentType string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	// Write to a temporary file first so readers never see a partial blob
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(temp, path)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return file, err
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
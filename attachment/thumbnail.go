@@ -0,0 +1,86 @@
+package attachment
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	// Register the decoders of the image formats thumbnails are made for
+	_ "image/gif"
+	_ "image/png"
+)
+
+const (
+	// thumbnailSize is the largest width or height of a thumbnail
+	thumbnailSize = 256
+	// maxSourcePixels bounds the images thumbnails are made for: a small compressed file
+	// can declare dimensions that take gigabytes once decoded
+	maxSourcePixels = 40_000_000
+)
+
+// makeThumbnail decodes an image and returns a JPEG thumbnail fitting in thumbnailSize
+// pixels. Each thumbnail pixel is the average of the source pixels it covers. Images
+// larger than maxSourcePixels are refused before being decoded.
+func makeThumbnail(data []byte) ([]byte, error) {
+	dimensions, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if dimensions.Width <= 0 || dimensions.Height <= 0 || int64(dimensions.Width)*int64(dimensions.Height) > maxSourcePixels {
+		return nil, errors.New("image dimensions are out of range for a thumbnail")
+	}
+
+	source, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := source.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	targetWidth, targetHeight := width, height
+	if width > thumbnailSize || height > thumbnailSize {
+		if width >= height {
+			targetWidth = thumbnailSize
+			targetHeight = max(1, height*thumbnailSize/width)
+		} else {
+			targetHeight = thumbnailSize
+			targetWidth = max(1, width*thumbnailSize/height)
+		}
+	}
+
+	thumbnail := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+	for y := 0; y < targetHeight; y++ {
+		y0 := bounds.Min.Y + y*height/targetHeight
+		y1 := max(y0+1, bounds.Min.Y+(y+1)*height/targetHeight)
+		for x := 0; x < targetWidth; x++ {
+			x0 := bounds.Min.X + x*width/targetWidth
+			x1 := max(x0+1, bounds.Min.X+(x+1)*width/targetWidth)
+
+			var r, g, b, a, count uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := source.At(sx, sy).RGBA()
+					r += uint64(pr)
+					g += uint64(pg)
+					b += uint64(pb)
+					a += uint64(pa)
+					count++
+				}
+			}
+			thumbnail.SetRGBA64(x, y, color.RGBA64{
+				R: uint16(r / count),
+				G: uint16(g / count),
+				B: uint16(b / count),
+				A: uint16(a / count),
+			})
+		}
+	}
+
+	var buffer bytes.Buffer
+	if err := jpeg.Encode(&buffer, thumbnail, &jpeg.Options{Quality: 80}); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
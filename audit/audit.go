@@ -0,0 +1,185 @@
+package audit
+
+import (
+	"context"
+	"log"
+	"my-finance-backend/config"
+	"my-finance-backend/utils"
+	"net/http"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ignoredFields are not part of snapshots and diffs
+var ignoredFields = map[string]bool{
+	"_id":         true,
+	"search_text": true,
+	"updated_at":  true,
+}
+
+// Record writes an audit entry for a change made through the request c.
+// before and after are the entity states (structs or documents); either may be nil.
+// Failures are logged and do not affect the request. Changes made by background jobs
+// pass a nil c and are recorded without actor details.
+func Record(ctx context.Context, mongoClient *mongo.Client, config *config.Config, c *gin.Context, entityType string, entityID string, action string, before interface{}, after interface{}) {
+	entry := Entry{
+		EntityType: entityType,
+		EntityID:   entityID,
+		Action:     action,
+		Timestamp:  utils.Timestamp(),
+	}
+	if c != nil {
+		entry.ActorID = c.GetString("user_id")
+		entry.ClientIP = c.ClientIP()
+		entry.UserAgent = c.Request.UserAgent()
+	}
+
+	var err error
+	if entry.Before, err = snapshot(before); err != nil {
+		log.Printf("Could not snapshot %s %s for audit: %v\n", entityType, entityID, err)
+		return
+	}
+	if entry.After, err = snapshot(after); err != nil {
+		log.Printf("Could not snapshot %s %s for audit: %v\n", entityType, entityID, err)
+		return
+	}
+	entry.Changes = diff(entry.Before, entry.After)
+
+	// The owner is taken from the snapshots so that history stays scoped to the entity's user
+	for _, state := range []bson.M{entry.After, entry.Before} {
+		if ownerID, ok := state["user_id"].(string); ok && ownerID != "" {
+			entry.OwnerID = ownerID
+			break
+		}
+	}
+	if entry.OwnerID == "" {
+		entry.OwnerID = entry.ActorID
+	}
+
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionAuditName)
+	if _, err := collection.InsertOne(ctx, entry); err != nil {
+		log.Printf("Could not write audit entry for %s %s: %v\n", entityType, entityID, err)
+	}
+}
+
+// FindEntry returns an audit entry of an entity owned by userID
+func FindEntry(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string, entityType string, entityID string, entryID string) (*Entry, error) {
+	objectId, err := utils.StringToObjectId(entryID)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionAuditName)
+	var entry Entry
+	err = collection.FindOne(ctx, bson.M{
+		"_id":         objectId,
+		"owner_id":    userID,
+		"entity_type": entityType,
+		"entity_id":   entityID,
+	}).Decode(&entry)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// snapshot converts an entity into a document without the ignored fields
+func snapshot(state interface{}) (bson.M, error) {
+	if state == nil || (reflect.ValueOf(state).Kind() == reflect.Ptr && reflect.ValueOf(state).IsNil()) {
+		return nil, nil
+	}
+	data, err := bson.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	var document bson.M
+	if err := bson.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	for field := range ignoredFields {
+		delete(document, field)
+	}
+	return document, nil
+}
+
+// diff lists the top-level fields that differ between two snapshots, sorted by name
+func diff(before bson.M, after bson.M) []FieldChange {
+	fields := make(map[string]bool)
+	for field := range before {
+		fields[field] = true
+	}
+	for field := range after {
+		fields[field] = true
+	}
+
+	changes := make([]FieldChange, 0)
+	for field := range fields {
+		oldValue, newValue := before[field], after[field]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: field, Before: oldValue, After: newValue})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Field < changes[j].Field })
+	return changes
+}
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+	}
+}
+
+// HandleGetExpenseHistory lists the audit entries of an expense, newest first
+func (h *Handler) HandleGetExpenseHistory(c *gin.Context) {
+	h.handleGetHistory(c, EntityExpense)
+}
+
+// HandleGetCategoryHistory lists the audit entries of a category, newest first
+func (h *Handler) HandleGetCategoryHistory(c *gin.Context) {
+	h.handleGetHistory(c, EntityCategory)
+}
+
+func (h *Handler) handleGetHistory(c *gin.Context, entityType string) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAuditName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}})
+	cursor, err := collection.Find(ctx, bson.M{
+		"owner_id":    userID,
+		"entity_type": entityType,
+		"entity_id":   c.Param("id"),
+	}, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch history"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var entries []Entry = make([]Entry, 0)
+	if err = cursor.All(ctx, &entries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode history"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
@@ -0,0 +1,37 @@
+package audit
+
+import "go.mongodb.org/mongo-driver/bson"
+
+const (
+	EntityExpense  = "expense"
+	EntityCategory = "category"
+
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionRevert  = "revert"
+)
+
+// Entry records one change to an expense or category
+type Entry struct {
+	ID         string        `bson:"_id,omitempty" json:"id"`
+	EntityType string        `bson:"entity_type" json:"entity_type"`
+	EntityID   string        `bson:"entity_id" json:"entity_id"`
+	Action     string        `bson:"action" json:"action"`
+	OwnerID    string        `bson:"owner_id" json:"owner_id"` // user the entity belongs to
+	ActorID    string        `bson:"actor_id" json:"actor_id"` // user who made the change
+	Timestamp  string        `bson:"timestamp" json:"timestamp"`
+	Before     bson.M        `bson:"before,omitempty" json:"before,omitempty"`
+	After      bson.M        `bson:"after,omitempty" json:"after,omitempty"`
+	Changes    []FieldChange `bson:"changes,omitempty" json:"changes,omitempty"`
+	ClientIP   string        `bson:"client_ip,omitempty" json:"client_ip,omitempty"`
+	UserAgent  string        `bson:"user_agent,omitempty" json:"user_agent,omitempty"`
+}
+
+// FieldChange is a top-level field whose value differs between the before and after snapshots
+type FieldChange struct {
+	Field  string      `bson:"field" json:"field"`
+	Before interface{} `bson:"before,omitempty" json:"before,omitempty"`
+	After  interface{} `bson:"after,omitempty" json:"after,omitempty"`
+}
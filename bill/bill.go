@@ -0,0 +1,610 @@
+package bill
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"my-finance-backend/account"
+	"my-finance-backend/config"
+	"my-finance-backend/event"
+	"my-finance-backend/expense"
+	"my-finance-backend/notification"
+	"my-finance-backend/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// reminderInterval is how often due bills are checked for reminders
+	reminderInterval = time.Hour
+
+	defaultRemindDaysBefore = 3
+	maxRemindDaysBefore     = 60
+)
+
+var frequencies = map[string]bool{
+	FrequencyOnce:      true,
+	FrequencyWeekly:    true,
+	FrequencyMonthly:   true,
+	FrequencyQuarterly: true,
+	FrequencyYearly:    true,
+}
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+	expenses    *expense.Handler
+	bus         *event.Bus
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config, expenses *expense.Handler, bus *event.Bus) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+		expenses:    expenses,
+		bus:         bus,
+	}
+}
+
+// NextDueDate returns the due date following due for a recurring bill
+func NextDueDate(due time.Time, frequency string, dueDay int) time.Time {
+	switch frequency {
+	case FrequencyWeekly:
+		return due.AddDate(0, 0, 7)
+	case FrequencyQuarterly:
+		return utils.AddMonths(due, 3, dueDay)
+	case FrequencyYearly:
+		return utils.AddMonths(due, 12, dueDay)
+	default:
+		return utils.AddMonths(due, 1, dueDay)
+	}
+}
+
+// Load returns the user's active bills, next due first
+func Load(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string) ([]Bill, error) {
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionBillsName)
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID, "active": true}, options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	bills := make([]Bill, 0)
+	if err = cursor.All(ctx, &bills); err != nil {
+		return nil, err
+	}
+	return bills, nil
+}
+
+// checkCategory verifies that a category exists and belongs to the user
+func (h *Handler) checkCategory(ctx context.Context, userID string, categoryID string) error {
+	objectId, err := utils.StringToObjectId(categoryID)
+	if err != nil {
+		return errors.New("Invalid category ID")
+	}
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
+	count, err := collection.CountDocuments(ctx, utils.NotDeleted(bson.M{"_id": objectId, "user_id": userID}))
+	if err != nil {
+		return errors.New("Could not fetch category")
+	}
+	if count == 0 {
+		return errors.New("Category not found")
+	}
+	return nil
+}
+
+// findBill loads the bill of the id route parameter, writing the error response on failure
+func (h *Handler) findBill(ctx context.Context, c *gin.Context, userID string) (Bill, bool) {
+	var bill Bill
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bill ID " + error.Error()})
+		return bill, false
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionBillsName)
+	err := collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&bill)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bill not found"})
+		return bill, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch bill"})
+		return bill, false
+	}
+	return bill, true
+}
+
+// SendReminders adds a notification for every active bill within its reminder window and
+// for every overdue bill. Each due date is reminded about once, and once more when overdue.
+// It returns the number of notifications added.
+func (h *Handler) SendReminders(ctx context.Context) (int, error) {
+	today := time.Now().UTC()
+	todayDate := today.Format("2006-01-02")
+	horizon := today.AddDate(0, 0, maxRemindDaysBefore).Format("2006-01-02")
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionBillsName)
+	cursor, err := collection.Find(ctx, bson.M{"active": true, "due_date": bson.M{"$lte": horizon}})
+	if err != nil {
+		return 0, err
+	}
+	var bills []Bill
+	if err = cursor.All(ctx, &bills); err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, bill := range bills {
+		due, err := time.Parse("2006-01-02", bill.DueDate)
+		if err != nil || todayDate < due.AddDate(0, 0, -bill.RemindDaysBefore).Format("2006-01-02") {
+			continue
+		}
+
+		n := notification.Notification{
+			UserID:     bill.UserID,
+			Type:       NotificationDue,
+			EntityType: EntityBill,
+			EntityID:   bill.ID,
+			Key:        "bill:" + bill.ID + ":" + bill.DueDate,
+		}
+		amount := fmt.Sprintf("%.2f %s", bill.Amount, bill.CurrencyCode)
+		switch {
+		case bill.DueDate < todayDate:
+			n.Type = NotificationOverdue
+			n.Key += ":overdue"
+			n.Title = bill.Name + " is overdue"
+			n.Message = fmt.Sprintf("%s (%s) was due on %s", bill.Name, amount, bill.DueDate)
+		case bill.DueDate == todayDate:
+			n.Title = bill.Name + " is due today"
+			n.Message = fmt.Sprintf("%s (%s) is due today", bill.Name, amount)
+		default:
+			n.Title = bill.Name + " is due soon"
+			n.Message = fmt.Sprintf("%s (%s) is due on %s", bill.Name, amount, bill.DueDate)
+		}
+
+		added, err := notification.Notify(ctx, h.mongoClient, h.config, h.bus, &n)
+		if err != nil {
+			return sent, err
+		}
+		if added {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// StartReminders runs SendReminders periodically until ctx is cancelled
+func (h *Handler) StartReminders(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(reminderInterval)
+		defer ticker.Stop()
+
+		for {
+			reminderCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+			if _, err := h.SendReminders(reminderCtx); err != nil {
+				log.Printf("Could not send bill reminders: %v\n", err)
+			}
+			cancel()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Create bill
+func (h *Handler) HandleCreateBill(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateBillRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if req.Frequency == "" {
+		req.Frequency = FrequencyMonthly
+	}
+	if !frequencies[req.Frequency] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "frequency must be once, weekly, monthly, quarterly or yearly"})
+		return
+	}
+	due, err := time.Parse("2006-01-02", req.DueDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid due_date, expected YYYY-MM-DD"})
+		return
+	}
+	remindDaysBefore := defaultRemindDaysBefore
+	if req.RemindDaysBefore != nil {
+		remindDaysBefore = *req.RemindDaysBefore
+	}
+	if remindDaysBefore < 0 || remindDaysBefore > maxRemindDaysBefore {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("remind_days_before must be between 0 and %d", maxRemindDaysBefore)})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if req.CategoryID != "" {
+		if err := h.checkCategory(ctx, userID, req.CategoryID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.AccountID != "" {
+		if err := account.Check(ctx, h.mongoClient, h.config, userID, req.AccountID, req.CurrencyCode); err != nil {
+			c.JSON(account.CheckStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	bill := Bill{
+		UserID:           userID,
+		Name:             strings.TrimSpace(req.Name),
+		Amount:           req.Amount,
+		CurrencyCode:     req.CurrencyCode,
+		CategoryID:       req.CategoryID,
+		AccountID:        req.AccountID,
+		Frequency:        req.Frequency,
+		DueDate:          req.DueDate,
+		DueDay:           due.Day(),
+		RemindDaysBefore: remindDaysBefore,
+		Active:           true,
+		CreatedAt:        utils.Timestamp(),
+	}
+	bill.UpdatedAt = bill.CreatedAt
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionBillsName)
+	result, err := collection.InsertOne(ctx, bill)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create bill"})
+		return
+	}
+	bill.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	c.JSON(http.StatusCreated, bill)
+}
+
+// Get the user's bills, next due first. Inactive bills are only included with active=false.
+func (h *Handler) HandleGetBills(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	filter := bson.M{"user_id": userID}
+	if c.Query("active") != "false" {
+		filter["active"] = true
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionBillsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch bills"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var bills []Bill = make([]Bill, 0)
+	if err = cursor.All(ctx, &bills); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode bills"})
+		return
+	}
+
+	c.JSON(http.StatusOK, bills)
+}
+
+// Get single bill
+func (h *Handler) HandleGetBill(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bill, ok := h.findBill(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, bill)
+}
+
+// Update bill
+func (h *Handler) HandleUpdateBill(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req UpdateBillRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bill, ok := h.findBill(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	update := bson.M{}
+	unset := bson.M{}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		bill.Name = name
+		update["name"] = name
+	}
+	if req.Amount > 0 {
+		bill.Amount = req.Amount
+		update["amount"] = req.Amount
+	}
+	if req.CategoryID != nil {
+		bill.CategoryID = *req.CategoryID
+		if bill.CategoryID == "" {
+			unset["category_id"] = ""
+		} else if err := h.checkCategory(ctx, userID, bill.CategoryID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		} else {
+			update["category_id"] = bill.CategoryID
+		}
+	}
+	if req.AccountID != nil {
+		bill.AccountID = *req.AccountID
+		if bill.AccountID == "" {
+			unset["account_id"] = ""
+		} else if err := account.Check(ctx, h.mongoClient, h.config, userID, bill.AccountID, bill.CurrencyCode); err != nil {
+			c.JSON(account.CheckStatus(err), gin.H{"error": err.Error()})
+			return
+		} else {
+			update["account_id"] = bill.AccountID
+		}
+	}
+	if req.Frequency != "" {
+		if !frequencies[req.Frequency] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "frequency must be once, weekly, monthly, quarterly or yearly"})
+			return
+		}
+		bill.Frequency = req.Frequency
+		update["frequency"] = req.Frequency
+	}
+	if req.DueDate != "" {
+		due, err := time.Parse("2006-01-02", req.DueDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid due_date, expected YYYY-MM-DD"})
+			return
+		}
+		bill.DueDate = req.DueDate
+		bill.DueDay = due.Day()
+		update["due_date"] = bill.DueDate
+		update["due_day"] = bill.DueDay
+	}
+	if req.RemindDaysBefore != nil {
+		if *req.RemindDaysBefore < 0 || *req.RemindDaysBefore > maxRemindDaysBefore {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("remind_days_before must be between 0 and %d", maxRemindDaysBefore)})
+			return
+		}
+		bill.RemindDaysBefore = *req.RemindDaysBefore
+		update["remind_days_before"] = bill.RemindDaysBefore
+	}
+	if req.Active != nil {
+		bill.Active = *req.Active
+		update["active"] = bill.Active
+	}
+	bill.UpdatedAt = utils.Timestamp()
+	update["updated_at"] = bill.UpdatedAt
+
+	updateDocument := bson.M{"$set": update}
+	if len(unset) > 0 {
+		updateDocument["$unset"] = unset
+	}
+	objectId, _ := utils.StringToObjectId(bill.ID)
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionBillsName)
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": objectId, "user_id": userID}, updateDocument); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update bill"})
+		return
+	}
+
+	c.JSON(http.StatusOK, bill)
+}
+
+// Delete bill. Expenses created by paying it are kept.
+func (h *Handler) HandleDeleteBill(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid bill ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionBillsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectId, "user_id": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete bill"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Bill not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Bill deleted successfully"})
+}
+
+// HandlePayBill marks the current due date of a bill as paid by creating the matching
+// expense. Recurring bills move on to their next due date, one-off bills become inactive.
+func (h *Handler) HandlePayBill(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	// The body is optional
+	var req PayBillRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if req.Date == "" {
+		req.Date = time.Now().UTC().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	bill, ok := h.findBill(ctx, c, userID)
+	if !ok {
+		return
+	}
+	if !bill.Active {
+		c.JSON(http.StatusConflict, gin.H{"error": "Bill is not active"})
+		return
+	}
+
+	paid := expense.Expense{
+		UserID:       userID,
+		CategoryID:   bill.CategoryID,
+		AccountID:    bill.AccountID,
+		Amount:       bill.Amount,
+		CurrencyCode: bill.CurrencyCode,
+		Name:         bill.Name,
+		Description:  "Bill due " + bill.DueDate,
+		Date:         req.Date,
+	}
+	if req.Amount > 0 {
+		paid.Amount = req.Amount
+	}
+	if req.AccountID != "" {
+		paid.AccountID = req.AccountID
+	}
+	if paid.AccountID != "" {
+		if err := account.Check(ctx, h.mongoClient, h.config, userID, paid.AccountID, paid.CurrencyCode); err != nil {
+			c.JSON(account.CheckStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if paid.CategoryID != "" && h.checkCategory(ctx, userID, paid.CategoryID) != nil {
+		// The category was deleted since the bill was set up
+		paid.CategoryID = ""
+	}
+
+	// Claim the due date before paying so that concurrent requests pay it only once
+	update := bson.M{
+		"last_paid_date": req.Date,
+		"updated_at":     utils.Timestamp(),
+	}
+	if bill.Frequency == FrequencyOnce {
+		update["active"] = false
+	} else {
+		due, err := time.Parse("2006-01-02", bill.DueDate)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Bill has an invalid due date"})
+			return
+		}
+		update["due_date"] = NextDueDate(due, bill.Frequency, bill.DueDay).Format("2006-01-02")
+	}
+	objectId, _ := utils.StringToObjectId(bill.ID)
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionBillsName)
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objectId, "user_id": userID, "due_date": bill.DueDate, "active": true},
+		bson.M{"$set": update})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update bill"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Bill was paid or changed in the meantime"})
+		return
+	}
+
+	if err := h.expenses.Insert(ctx, c, &paid); err != nil {
+		// Give the due date back, unless the bill changed again since it was claimed
+		claimed := bson.M{"_id": objectId, "user_id": userID, "last_paid_date": req.Date}
+		for _, field := range []string{"due_date", "active"} {
+			if value, ok := update[field]; ok {
+				claimed[field] = value
+			}
+		}
+		set := bson.M{"due_date": bill.DueDate, "active": true, "updated_at": utils.Timestamp()}
+		restore := bson.M{"$set": set}
+		if bill.LastPaidDate == "" {
+			restore["$unset"] = bson.M{"last_paid_date": ""}
+		} else {
+			set["last_paid_date"] = bill.LastPaidDate
+		}
+		if _, err := collection.UpdateOne(ctx, claimed, restore); err != nil {
+			log.Printf("Could not restore the due date of bill %s: %v\n", bill.ID, err)
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create expense"})
+		return
+	}
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": objectId}, bson.M{"$set": bson.M{"last_expense_id": paid.ID}}); err != nil {
+		log.Printf("Could not link bill %s to expense %s: %v\n", bill.ID, paid.ID, err)
+	}
+	if err := notification.MarkEntityRead(ctx, h.mongoClient, h.config, userID, EntityBill, bill.ID); err != nil {
+		log.Printf("Could not mark bill notifications read: %v\n", err)
+	}
+
+	bill.LastPaidDate = req.Date
+	bill.LastExpenseID = paid.ID
+	bill.UpdatedAt = update["updated_at"].(string)
+	if active, ok := update["active"].(bool); ok {
+		bill.Active = active
+	}
+	if dueDate, ok := update["due_date"].(string); ok {
+		bill.DueDate = dueDate
+	}
+
+	c.JSON(http.StatusOK, PayBillResponse{Bill: bill, Expense: paid})
+}
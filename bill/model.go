@@ -0,0 +1,72 @@
+package bill
+
+import "my-finance-backend/expense"
+
+const (
+	FrequencyOnce      = "once"
+	FrequencyWeekly    = "weekly"
+	FrequencyMonthly   = "monthly"
+	FrequencyQuarterly = "quarterly"
+	FrequencyYearly    = "yearly"
+
+	NotificationDue     = "bill.due"
+	NotificationOverdue = "bill.overdue"
+
+	EntityBill = "bill"
+)
+
+// Bill is a payment due on a date, such as electricity, water or school fees. Recurring
+// bills move to their next due date once paid.
+type Bill struct {
+	ID           string  `bson:"_id,omitempty" json:"id"`
+	UserID       string  `bson:"user_id" json:"user_id"`
+	Name         string  `bson:"name" json:"name"`
+	Amount       float64 `bson:"amount" json:"amount"`
+	CurrencyCode string  `bson:"currency_code" json:"currency_code"`
+	CategoryID   string  `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	AccountID    string  `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	Frequency    string  `bson:"frequency" json:"frequency"`
+	DueDate      string  `bson:"due_date" json:"due_date"` // next unpaid due date
+	// DueDay is the day of month bills are due on, kept when a shorter month moves a due date earlier
+	DueDay           int    `bson:"due_day" json:"-"`
+	RemindDaysBefore int    `bson:"remind_days_before" json:"remind_days_before"`
+	Active           bool   `bson:"active" json:"active"` // false once a one-off bill is paid
+	LastPaidDate     string `bson:"last_paid_date,omitempty" json:"last_paid_date,omitempty"`
+	LastExpenseID    string `bson:"last_expense_id,omitempty" json:"last_expense_id,omitempty"`
+	CreatedAt        string `bson:"created_at" json:"created_at"`
+	UpdatedAt        string `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+type CreateBillRequest struct {
+	Name             string  `json:"name" binding:"required"`
+	Amount           float64 `json:"amount" binding:"required"`
+	CurrencyCode     string  `json:"currency_code" binding:"required"`
+	CategoryID       string  `json:"category_id"`
+	AccountID        string  `json:"account_id"`
+	Frequency        string  `json:"frequency"` // defaults to monthly
+	DueDate          string  `json:"due_date" binding:"required"`
+	RemindDaysBefore *int    `json:"remind_days_before"`
+}
+
+type UpdateBillRequest struct {
+	Name             string  `json:"name"`
+	Amount           float64 `json:"amount"`
+	CategoryID       *string `json:"category_id"` // an empty string removes the category
+	AccountID        *string `json:"account_id"`  // an empty string unlinks the account
+	Frequency        string  `json:"frequency"`
+	DueDate          string  `json:"due_date"`
+	RemindDaysBefore *int    `json:"remind_days_before"`
+	Active           *bool   `json:"active"`
+}
+
+// PayBillRequest overrides the bill amount, today's date (UTC) or the bill account for the payment
+type PayBillRequest struct {
+	Amount    float64 `json:"amount"`
+	Date      string  `json:"date"`
+	AccountID string  `json:"account_id"`
+}
+
+type PayBillResponse struct {
+	Bill    Bill            `json:"bill"`
+	Expense expense.Expense `json:"expense"`
+}
@@ -2,7 +2,9 @@ package category
 
 import (
 	"context"
+	"my-finance-backend/audit"
 	"my-finance-backend/config"
+	"my-finance-backend/event"
 	"my-finance-backend/utils"
 	"net/http"
 	"strings"
@@ -24,17 +26,38 @@ type Handler struct {
 	mongoClient *mongo.Client
 	jwtSecret   []byte
 	config      *config.Config
+	bus         *event.Bus
 }
 
-func NewHandler(mongoClient *mongo.Client, config *config.Config, jwtSecret []byte) *Handler {
+func NewHandler(mongoClient *mongo.Client, config *config.Config, jwtSecret []byte, bus *event.Bus) *Handler {
 	handler := &Handler{
 		mongoClient: mongoClient,
 		config:      config,
 		jwtSecret:   jwtSecret,
+		bus:         bus,
 	}
 	return handler
 }
 
+// recordChange writes an audit entry for a change to a category and notifies the
+// owner's connected clients
+func (h *Handler) recordChange(ctx context.Context, c *gin.Context, action string, categoryID string, before *Category, after *Category) {
+	audit.Record(ctx, h.mongoClient, h.config, c, audit.EntityCategory, categoryID, action, before, after)
+
+	current := after
+	if current == nil {
+		current = before
+	}
+	h.bus.Publish(event.Event{
+		Type:       event.ChangeType(audit.EntityCategory, action),
+		UserID:     current.UserID,
+		EntityType: audit.EntityCategory,
+		EntityID:   categoryID,
+		Version:    current.Version,
+		Data:       current,
+	})
+}
+
 // initializeDefaultCategory creates a default category for a specific user if it doesn't exist
 func (h *Handler) initializeDefaultCategory(userID string) {
 	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
@@ -53,10 +76,12 @@ func (h *Handler) initializeDefaultCategory(userID string) {
 		// Create default category for this user
 		defaultCategory := Category{
 			//ID:       primitive.NewObjectID().Hex(),
-			UserID:   userID,
-			Name:     DefaultCategoryName,
-			Color:    DefaultCategoryColor,
-			IconName: DefaultCategoryIconName,
+			UserID:    userID,
+			Name:      DefaultCategoryName,
+			Color:     DefaultCategoryColor,
+			IconName:  DefaultCategoryIconName,
+			Version:   1,
+			UpdatedAt: utils.Timestamp(),
 		}
 
 		_, err := collection.InsertOne(ctx, defaultCategory)
@@ -94,10 +119,10 @@ func (h *Handler) HandleCreateCategory(c *gin.Context) {
 	h.initializeDefaultCategory(userID)
 
 	// Check if category with same name exists for this user
-	existingFilter := bson.M{
+	existingFilter := utils.NotDeleted(bson.M{
 		"name":    req.Name,
 		"user_id": userID,
-	}
+	})
 	var existingCategory Category
 	err := collection.FindOne(ctx, existingFilter).Decode(&existingCategory)
 	if err == nil {
@@ -106,10 +131,12 @@ func (h *Handler) HandleCreateCategory(c *gin.Context) {
 	}
 
 	category := Category{
-		UserID:   userID,
-		Name:     req.Name,
-		Color:    req.Color,
-		IconName: req.IconName,
+		UserID:    userID,
+		Name:      req.Name,
+		Color:     req.Color,
+		IconName:  req.IconName,
+		Version:   1,
+		UpdatedAt: utils.Timestamp(),
 	}
 
 	result, err := collection.InsertOne(ctx, category)
@@ -118,6 +145,8 @@ func (h *Handler) HandleCreateCategory(c *gin.Context) {
 		return
 	}
 	category.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	h.recordChange(ctx, c, audit.ActionCreate, category.ID, nil, &category)
+	c.Header("ETag", utils.VersionETag(category.ID, category.Version))
 	c.JSON(http.StatusCreated, category)
 }
 
@@ -137,7 +166,7 @@ func (h *Handler) HandleGetCategories(c *gin.Context) {
 	h.initializeDefaultCategory(userID)
 
 	// Find all categories for this user
-	cursor, err := collection.Find(ctx, bson.M{"user_id": userID})
+	cursor, err := collection.Find(ctx, utils.NotDeleted(bson.M{"user_id": userID}))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch categories"})
 		return
@@ -150,7 +179,7 @@ func (h *Handler) HandleGetCategories(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, categories)
+	utils.JSONWithETag(c, http.StatusOK, categories)
 }
 
 // Get single category
@@ -174,9 +203,9 @@ func (h *Handler) HandleGetCategory(c *gin.Context) {
 	}
 
 	var category Category
-	err := collection.FindOne(ctx, bson.M{
+	err := collection.FindOne(ctx, utils.NotDeleted(bson.M{
 		"_id": objectId,
-	}).Decode(&category)
+	})).Decode(&category)
 
 	if err == mongo.ErrNoDocuments {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
@@ -186,10 +215,12 @@ func (h *Handler) HandleGetCategory(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, category)
+	utils.JSONWithVersionETag(c, http.StatusOK, category.ID, category.Version, category)
 }
 
-// Update category
+// Update category.
+// With an If-Match header carrying the ETag of the category, the update is only applied
+// if nobody else changed it in the meantime, otherwise 412 Precondition Failed is returned.
 func (h *Handler) HandleUpdateCategory(c *gin.Context) {
 	userID := c.GetString("user_id")
 	if userID == "" {
@@ -218,10 +249,10 @@ func (h *Handler) HandleUpdateCategory(c *gin.Context) {
 
 	// Check if trying to update default category
 	var existingCategory Category
-	err = collection.FindOne(ctx, bson.M{
+	err = collection.FindOne(ctx, utils.NotDeleted(bson.M{
 		"_id":     objectID,
 		"user_id": userID,
-	}).Decode(&existingCategory)
+	})).Decode(&existingCategory)
 
 	if (err == context.DeadlineExceeded) || (err == context.Canceled) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch category. Too long to respond."})
@@ -233,6 +264,16 @@ func (h *Handler) HandleUpdateCategory(c *gin.Context) {
 		return
 	}
 
+	expectedVersion, checkVersion, err := utils.IfMatchVersion(c, categoryID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if checkVersion && existingCategory.Version != expectedVersion {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Category was modified by someone else"})
+		return
+	}
+
 	// Check if new name conflicts with default or existing category
 	if req.Name != "" && req.Name != existingCategory.Name {
 		if req.Name == DefaultCategoryName {
@@ -242,11 +283,11 @@ func (h *Handler) HandleUpdateCategory(c *gin.Context) {
 
 		// Check for name conflict with other categories for this user
 		var conflictCategory Category
-		err := collection.FindOne(ctx, bson.M{
+		err := collection.FindOne(ctx, utils.NotDeleted(bson.M{
 			"name":    req.Name,
 			"user_id": userID,
 			"_id":     bson.M{"$ne": categoryID},
-		}).Decode(&conflictCategory)
+		})).Decode(&conflictCategory)
 		if err == nil {
 			c.JSON(http.StatusConflict, gin.H{"error": "Category with this name already exists"})
 			return
@@ -270,13 +311,14 @@ func (h *Handler) HandleUpdateCategory(c *gin.Context) {
 		update["icon_name"] = req.IconName
 	}
 
+	// The update only applies to the version read above, so concurrent writes are detected
 	result, err := collection.UpdateOne(
 		ctx,
-		bson.M{
+		utils.MatchVersion(utils.NotDeleted(bson.M{
 			"_id":     objectID,
 			"user_id": userID,
-		},
-		bson.M{"$set": update},
+		}), existingCategory.Version),
+		utils.Touch(bson.M{"$set": update}),
 	)
 
 	if err != nil {
@@ -285,7 +327,7 @@ func (h *Handler) HandleUpdateCategory(c *gin.Context) {
 	}
 
 	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Category was modified by someone else"})
 		return
 	}
 
@@ -300,10 +342,13 @@ func (h *Handler) HandleUpdateCategory(c *gin.Context) {
 		return
 	}
 	updatedCategory.ID = objectID.Hex()
+	h.recordChange(ctx, c, audit.ActionUpdate, updatedCategory.ID, &existingCategory, &updatedCategory)
+	c.Header("ETag", utils.VersionETag(updatedCategory.ID, updatedCategory.Version))
 	c.JSON(http.StatusOK, updatedCategory)
 }
 
-// Delete category
+// Delete category. The category is moved to the trash and can be restored until it is purged.
+// An If-Match header is honoured like in HandleUpdateCategory.
 func (h *Handler) HandleDeleteCategory(c *gin.Context) {
 	userID := c.GetString("user_id")
 	if userID == "" {
@@ -325,10 +370,10 @@ func (h *Handler) HandleDeleteCategory(c *gin.Context) {
 
 	// Check if trying to delete default category
 	var category Category
-	err := collection.FindOne(ctx, bson.M{
+	err := collection.FindOne(ctx, utils.NotDeleted(bson.M{
 		"_id":     objectId,
 		"user_id": userID,
-	}).Decode(&category)
+	})).Decode(&category)
 
 	if err == mongo.ErrNoDocuments {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
@@ -340,20 +385,94 @@ func (h *Handler) HandleDeleteCategory(c *gin.Context) {
 		return
 	}
 
-	result, err := collection.DeleteOne(ctx, bson.M{
+	expectedVersion, checkVersion, err := utils.IfMatchVersion(c, categoryID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if checkVersion && category.Version != expectedVersion {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Category was modified by someone else"})
+		return
+	}
+
+	result, err := collection.UpdateOne(ctx, utils.MatchVersion(utils.NotDeleted(bson.M{
 		"_id":     objectId,
 		"user_id": userID,
-	})
+	}), category.Version), utils.Touch(utils.SoftDeleteUpdate()))
 
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete category"})
 		return
 	}
 
-	if result.DeletedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found"})
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Category was modified by someone else"})
 		return
 	}
 
+	deletedCategory := category
+	deletedCategory.DeletedAt = time.Now().UTC().Format(time.RFC3339)
+	deletedCategory.Version++
+	deletedCategory.UpdatedAt = utils.Timestamp()
+	h.recordChange(ctx, c, audit.ActionDelete, category.ID, &category, &deletedCategory)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Category deleted successfully"})
 }
+
+// HandleRestoreCategory takes a category out of the trash
+func (h *Handler) HandleRestoreCategory(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	categoryID := c.Param("id")
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	objectId, error := utils.StringToObjectId(categoryID)
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid category ID " + error.Error()})
+		return
+	}
+
+	var category Category
+	err := collection.FindOne(ctx, utils.OnlyDeleted(bson.M{
+		"_id":     objectId,
+		"user_id": userID,
+	})).Decode(&category)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Category not found in trash"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch category"})
+		return
+	}
+
+	// A category with the same name may have been created in the meantime
+	var conflictCategory Category
+	err = collection.FindOne(ctx, utils.NotDeleted(bson.M{
+		"name":    category.Name,
+		"user_id": userID,
+	})).Decode(&conflictCategory)
+	if err == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Category with this name already exists"})
+		return
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objectId, "user_id": userID}, utils.Touch(utils.RestoreUpdate()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not restore category"})
+		return
+	}
+
+	restoredCategory := category
+	restoredCategory.DeletedAt = ""
+	restoredCategory.Version++
+	restoredCategory.UpdatedAt = utils.Timestamp()
+	h.recordChange(ctx, c, audit.ActionRestore, category.ID, &category, &restoredCategory)
+	c.JSON(http.StatusOK, restoredCategory)
+}
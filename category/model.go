@@ -7,11 +7,14 @@ type CreateCategoryRequest struct {
 }
 
 type Category struct {
-	ID       string `bson:"_id,omitempty" json:"id"`
-	UserID   string `json:"user_id" bson:"user_id"`
-	Name     string `json:"name" bson:"name"`
-	Color    string `json:"color" bson:"color"`
-	IconName string `json:"icon_name" bson:"icon_name"`
+	ID        string `bson:"_id,omitempty" json:"id"`
+	UserID    string `json:"user_id" bson:"user_id"`
+	Name      string `json:"name" bson:"name"`
+	Color     string `json:"color" bson:"color"`
+	IconName  string `json:"icon_name" bson:"icon_name"`
+	DeletedAt string `json:"deleted_at,omitempty" bson:"deleted_at,omitempty"`
+	Version   int64  `json:"version" bson:"version"` // incremented on every write, exposed as ETag
+	UpdatedAt string `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 }
 
 type UpdateCategoryRequest struct {
@@ -9,6 +9,66 @@ type Config struct {
 	CollectionExpensesName   string
 	CollectionCategoriesName string
 	CollectionTagsName       string
+	CollectionRulesName      string
+
+	CollectionDuplicateDismissalsName string
+	CollectionAuditName               string
+	CollectionIdempotencyName         string
+	CollectionWebhooksName            string
+	CollectionWebhookDeliveriesName   string
+	CollectionAttachmentsName         string
+	CollectionSettlementsName         string
+	CollectionAccountsName            string
+	CollectionIncomesName             string
+	CollectionTransfersName           string
+	CollectionReconciliationsName     string
+	CollectionGoalsName               string
+	CollectionGoalContributionsName   string
+	CollectionDebtsName               string
+	CollectionRepaymentsName          string
+	CollectionNetWorthSettingsName    string
+	CollectionNetWorthSnapshotsName   string
+	CollectionBillsName               string
+	CollectionNotificationsName       string
+	CollectionRecurringTemplatesName  string
+
+	// SuggestionMinConfidence is the confidence a learned category suggestion
+	// needs to be applied automatically during CSV import
+	SuggestionMinConfidence float64
+
+	// Duplicate detection defaults: two expenses are suspected duplicates when their dates
+	// are at most DuplicateDateWindowDays apart, their amounts differ by at most
+	// DuplicateAmountTolerance (a fraction of the larger amount) and their names have a
+	// similarity of at least DuplicateNameSimilarity
+	DuplicateDateWindowDays  int
+	DuplicateAmountTolerance float64
+	DuplicateNameSimilarity  float64
+
+	// TrashRetentionDays is how long soft deleted documents are kept before being purged
+	TrashRetentionDays int
+
+	// IdempotencyTTLHours is how long responses of requests with an Idempotency-Key are replayed
+	IdempotencyTTLHours int
+
+	// WebhookMaxAttempts is how many times a webhook delivery is tried before it is marked failed
+	WebhookMaxAttempts int
+	// WebhookAllowPrivateURLs lets webhooks target loopback, private and link-local
+	// addresses, e.g. a receiver on localhost during development and tests
+	WebhookAllowPrivateURLs bool
+
+	// DefaultBaseCurrency is the currency net worth is expressed in for users who did not choose one
+	DefaultBaseCurrency string
+
+	// Attachment storage: AttachmentStorage is local (files below AttachmentLocalDir),
+	// gridfs or s3 (an S3 compatible endpoint such as MinIO)
+	AttachmentStorage   string
+	AttachmentLocalDir  string
+	AttachmentMaxSizeMB int
+	S3Endpoint          string
+	S3Region            string
+	S3Bucket            string
+	S3AccessKey         string
+	S3SecretKey         string
 }
 
 // IsDevelopment checks if the current environment is development
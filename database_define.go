@@ -3,6 +3,7 @@ package main
 import (
 	"my-finance-backend/config"
 	"os"
+	"strconv"
 )
 
 // LoadConfig loads configuration from environment variables
@@ -17,6 +18,45 @@ func LoadConfig() *config.Config {
 		CollectionExpensesName:   "expenses",
 		CollectionCategoriesName: "categories",
 		CollectionTagsName:       "tags",
+		CollectionRulesName:      "rules",
+		SuggestionMinConfidence:  getEnvFloat("SUGGESTION_MIN_CONFIDENCE", 0.8),
+
+		CollectionDuplicateDismissalsName: "duplicate_dismissals",
+		CollectionAuditName:               "audit_log",
+		CollectionIdempotencyName:         "idempotency_keys",
+		CollectionWebhooksName:            "webhooks",
+		CollectionWebhookDeliveriesName:   "webhook_deliveries",
+		CollectionAttachmentsName:         "attachments",
+		CollectionSettlementsName:         "settlements",
+		CollectionAccountsName:            "accounts",
+		CollectionIncomesName:             "incomes",
+		CollectionTransfersName:           "transfers",
+		CollectionReconciliationsName:     "reconciliations",
+		CollectionGoalsName:               "goals",
+		CollectionGoalContributionsName:   "goal_contributions",
+		CollectionDebtsName:               "debts",
+		CollectionRepaymentsName:          "debt_repayments",
+		CollectionNetWorthSettingsName:    "net_worth_settings",
+		CollectionNetWorthSnapshotsName:   "net_worth_snapshots",
+		CollectionBillsName:               "bills",
+		CollectionNotificationsName:       "notifications",
+		CollectionRecurringTemplatesName:  "recurring_templates",
+		DuplicateDateWindowDays:           getEnvInt("DUPLICATE_DATE_WINDOW_DAYS", 1),
+		DuplicateAmountTolerance:          getEnvFloat("DUPLICATE_AMOUNT_TOLERANCE", 0),
+		DuplicateNameSimilarity:           getEnvFloat("DUPLICATE_NAME_SIMILARITY", 0.8),
+		TrashRetentionDays:                getEnvInt("TRASH_RETENTION_DAYS", 30),
+		IdempotencyTTLHours:               getEnvInt("IDEMPOTENCY_TTL_HOURS", 24),
+		WebhookMaxAttempts:                getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookAllowPrivateURLs:           getEnvBool("WEBHOOK_ALLOW_PRIVATE_URLS", false),
+		DefaultBaseCurrency:               getEnv("DEFAULT_BASE_CURRENCY", "USD"),
+		AttachmentStorage:                 getEnv("ATTACHMENT_STORAGE", "local"),
+		AttachmentLocalDir:                getEnv("ATTACHMENT_LOCAL_DIR", "data/attachments"),
+		AttachmentMaxSizeMB:               getEnvInt("ATTACHMENT_MAX_SIZE_MB", 10),
+		S3Endpoint:                        getEnv("S3_ENDPOINT", ""),
+		S3Region:                          getEnv("S3_REGION", "us-east-1"),
+		S3Bucket:                          getEnv("S3_BUCKET", ""),
+		S3AccessKey:                       getEnv("S3_ACCESS_KEY", ""),
+		S3SecretKey:                       getEnv("S3_SECRET_KEY", ""),
 	}
 
 	return config
@@ -30,3 +70,30 @@ func getEnv(key, defaultValue string) string {
 	}
 	return value
 }
+
+// getEnvFloat gets a numeric environment variable with a default value
+func getEnvFloat(key string, defaultValue float64) float64 {
+	value, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvBool gets a boolean environment variable with a default value
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvInt gets an integer environment variable with a default value
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,592 @@
+package debt
+
+import (
+	"context"
+	"math"
+	"my-finance-backend/config"
+	"my-finance-backend/expense"
+	"my-finance-backend/income"
+	"my-finance-backend/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// amountTolerance absorbs rounding when comparing repayments with the outstanding balance
+const amountTolerance = 0.005
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+	}
+}
+
+// applyRepaid fills the computed fields of a debt. Interest is simple interest on the
+// principal from the start date to the due date, or to today for debts without one.
+func applyRepaid(debt *Debt, repaid float64, today time.Time) {
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+	debt.Interest = 0
+	if start, err := time.Parse("2006-01-02", debt.StartDate); err == nil && debt.InterestRate > 0 {
+		end := today
+		if due, err := time.Parse("2006-01-02", debt.DueDate); err == nil {
+			end = due
+		}
+		if days := end.Sub(start).Hours() / 24; days > 0 {
+			debt.Interest = utils.RoundAmount(debt.Principal * debt.InterestRate / 100 * days / 365)
+		}
+	}
+
+	debt.Repaid = utils.RoundAmount(repaid)
+	debt.Outstanding = utils.RoundAmount(math.Max(0, debt.Principal+debt.Interest-debt.Repaid))
+	switch {
+	case debt.Outstanding < amountTolerance:
+		debt.Status = StatusSettled
+	case debt.DueDate != "" && debt.DueDate < today.Format("2006-01-02"):
+		debt.Status = StatusOverdue
+	default:
+		debt.Status = StatusOpen
+	}
+}
+
+// repaidByDebt sums the repayments of the user's debts
+func repaidByDebt(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string) (map[string]float64, error) {
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionRepaymentsName)
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": userID}}},
+		{{Key: "$group", Value: bson.M{"_id": "$debt_id", "repaid": bson.M{"$sum": "$amount"}}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		DebtID string  `bson:"_id"`
+		Repaid float64 `bson:"repaid"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	repaid := make(map[string]float64, len(results))
+	for _, result := range results {
+		repaid[result.DebtID] = result.Repaid
+	}
+	return repaid, nil
+}
+
+// Load returns the user's debts with their outstanding balance, earliest due first
+func Load(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string) ([]Debt, error) {
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionDebtsName)
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID},
+		options.Find().SetSort(bson.D{{Key: "due_date", Value: 1}, {Key: "start_date", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	debts := make([]Debt, 0)
+	if err = cursor.All(ctx, &debts); err != nil {
+		return nil, err
+	}
+	repaid, err := repaidByDebt(ctx, mongoClient, config, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	today := time.Now()
+	for i := range debts {
+		applyRepaid(&debts[i], repaid[debts[i].ID], today)
+	}
+
+	// Debts without a due date sort first in MongoDB, list them last instead
+	dated := make([]Debt, 0, len(debts))
+	undated := make([]Debt, 0)
+	for _, debt := range debts {
+		if debt.DueDate == "" {
+			undated = append(undated, debt)
+		} else {
+			dated = append(dated, debt)
+		}
+	}
+	return append(dated, undated...), nil
+}
+
+// findDebt loads the debt of the id route parameter with its repayments, writing the
+// error response on failure
+func (h *Handler) findDebt(ctx context.Context, c *gin.Context, userID string) (Debt, []Repayment, bool) {
+	var debt Debt
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid debt ID " + error.Error()})
+		return debt, nil, false
+	}
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	err := database.Collection(h.config.CollectionDebtsName).FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&debt)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Debt not found"})
+		return debt, nil, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch debt"})
+		return debt, nil, false
+	}
+
+	cursor, err := database.Collection(h.config.CollectionRepaymentsName).Find(ctx,
+		bson.M{"user_id": userID, "debt_id": debt.ID},
+		options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch repayments"})
+		return debt, nil, false
+	}
+	defer cursor.Close(ctx)
+
+	repayments := make([]Repayment, 0)
+	if err = cursor.All(ctx, &repayments); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode repayments"})
+		return debt, nil, false
+	}
+
+	repaid := 0.0
+	for _, repayment := range repayments {
+		repaid += repayment.Amount
+	}
+	applyRepaid(&debt, repaid, time.Now())
+	return debt, repayments, true
+}
+
+// Create debt
+func (h *Handler) HandleCreateDebt(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateDebtRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Direction != DirectionLent && req.Direction != DirectionBorrowed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "direction must be lent or borrowed"})
+		return
+	}
+	if req.Principal <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "principal must be positive"})
+		return
+	}
+	if req.InterestRate < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "interest_rate must not be negative"})
+		return
+	}
+	if req.StartDate == "" {
+		req.StartDate = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.StartDate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+		return
+	}
+	if req.DueDate != "" {
+		if _, err := time.Parse("2006-01-02", req.DueDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid due_date, expected YYYY-MM-DD"})
+			return
+		}
+		if req.DueDate < req.StartDate {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "due_date must not be before start_date"})
+			return
+		}
+	}
+
+	debt := Debt{
+		UserID:       userID,
+		Direction:    req.Direction,
+		Counterparty: strings.TrimSpace(req.Counterparty),
+		Principal:    req.Principal,
+		CurrencyCode: req.CurrencyCode,
+		InterestRate: req.InterestRate,
+		StartDate:    req.StartDate,
+		DueDate:      req.DueDate,
+		Note:         req.Note,
+		CreatedAt:    utils.Timestamp(),
+	}
+	debt.UpdatedAt = debt.CreatedAt
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionDebtsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.InsertOne(ctx, debt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create debt"})
+		return
+	}
+	debt.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	applyRepaid(&debt, 0, time.Now())
+
+	c.JSON(http.StatusCreated, debt)
+}
+
+// HandleGetDebts lists the user's debts with their outstanding balance, earliest due
+// first. Optional filters are direction (lent or borrowed) and status (open, settled or
+// overdue); status=overdue lists the unpaid debts past their due date.
+func (h *Handler) HandleGetDebts(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	direction, status := c.Query("direction"), c.Query("status")
+	if direction != "" && direction != DirectionLent && direction != DirectionBorrowed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "direction must be lent or borrowed"})
+		return
+	}
+	if status != "" && status != StatusOpen && status != StatusSettled && status != StatusOverdue {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be open, settled or overdue"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	debts, err := Load(ctx, h.mongoClient, h.config, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch debts"})
+		return
+	}
+
+	filtered := make([]Debt, 0, len(debts))
+	for _, debt := range debts {
+		if direction != "" && debt.Direction != direction {
+			continue
+		}
+		// Overdue debts are still open
+		if status != "" && debt.Status != status && !(status == StatusOpen && debt.Status == StatusOverdue) {
+			continue
+		}
+		filtered = append(filtered, debt)
+	}
+
+	c.JSON(http.StatusOK, filtered)
+}
+
+// Get single debt with its repayments, latest first
+func (h *Handler) HandleGetDebt(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	debt, repayments, ok := h.findDebt(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, GetDebtResponse{Debt: debt, Repayments: repayments})
+}
+
+// Update debt
+func (h *Handler) HandleUpdateDebt(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req UpdateDebtRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Principal < 0 || (req.InterestRate != nil && *req.InterestRate < 0) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "principal and interest_rate must not be negative"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	debt, repayments, ok := h.findDebt(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	update := bson.M{}
+	unset := bson.M{}
+	if counterparty := strings.TrimSpace(req.Counterparty); counterparty != "" {
+		debt.Counterparty = counterparty
+		update["counterparty"] = counterparty
+	}
+	if req.Principal > 0 {
+		debt.Principal = req.Principal
+		update["principal"] = req.Principal
+	}
+	if req.InterestRate != nil {
+		debt.InterestRate = *req.InterestRate
+		update["interest_rate"] = debt.InterestRate
+	}
+	if req.StartDate != "" {
+		if _, err := time.Parse("2006-01-02", req.StartDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date, expected YYYY-MM-DD"})
+			return
+		}
+		debt.StartDate = req.StartDate
+		update["start_date"] = req.StartDate
+	}
+	if req.DueDate != nil {
+		debt.DueDate = *req.DueDate
+		if debt.DueDate == "" {
+			unset["due_date"] = ""
+		} else if _, err := time.Parse("2006-01-02", debt.DueDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid due_date, expected YYYY-MM-DD"})
+			return
+		} else {
+			update["due_date"] = debt.DueDate
+		}
+	}
+	if debt.DueDate != "" && debt.DueDate < debt.StartDate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "due_date must not be before start_date"})
+		return
+	}
+	if req.Note != nil {
+		debt.Note = *req.Note
+		update["note"] = debt.Note
+	}
+	debt.UpdatedAt = utils.Timestamp()
+	update["updated_at"] = debt.UpdatedAt
+
+	updateDocument := bson.M{"$set": update}
+	if len(unset) > 0 {
+		updateDocument["$unset"] = unset
+	}
+	objectId, _ := utils.StringToObjectId(debt.ID)
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionDebtsName)
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": objectId, "user_id": userID}, updateDocument); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update debt"})
+		return
+	}
+
+	repaid := 0.0
+	for _, repayment := range repayments {
+		repaid += repayment.Amount
+	}
+	applyRepaid(&debt, repaid, time.Now())
+
+	c.JSON(http.StatusOK, debt)
+}
+
+// Delete debt with its repayments. Linked expenses and incomes are kept.
+func (h *Handler) HandleDeleteDebt(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid debt ID " + error.Error()})
+		return
+	}
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := database.Collection(h.config.CollectionDebtsName).DeleteOne(ctx, bson.M{"_id": objectId, "user_id": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete debt"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Debt not found"})
+		return
+	}
+	if _, err := database.Collection(h.config.CollectionRepaymentsName).DeleteMany(ctx, bson.M{"user_id": userID, "debt_id": objectId.Hex()}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete repayments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Debt deleted successfully"})
+}
+
+// linkedRecord loads the expense or income a repayment points to and checks that it fits
+// the debt. It returns the amount and date of the record, or a non-zero status with the
+// error message.
+func (h *Handler) linkedRecord(ctx context.Context, userID string, debt Debt, req CreateRepaymentRequest) (float64, string, int, string) {
+	database := h.mongoClient.Database(h.config.DatabaseName)
+
+	var amount float64
+	var date, currencyCode, field, id string
+	switch {
+	case req.ExpenseID != "" && req.IncomeID != "":
+		return 0, "", http.StatusBadRequest, "expense_id and income_id cannot be combined"
+	case req.ExpenseID != "":
+		if debt.Direction != DirectionBorrowed {
+			return 0, "", http.StatusBadRequest, "Repayments of lent money are linked to an income"
+		}
+		objectId, err := utils.StringToObjectId(req.ExpenseID)
+		if err != nil {
+			return 0, "", http.StatusBadRequest, "Invalid expense ID"
+		}
+		var linked expense.Expense
+		err = database.Collection(h.config.CollectionExpensesName).FindOne(ctx, utils.NotDeleted(bson.M{"_id": objectId, "user_id": userID})).Decode(&linked)
+		if err == mongo.ErrNoDocuments {
+			return 0, "", http.StatusNotFound, "Expense not found"
+		} else if err != nil {
+			return 0, "", http.StatusInternalServerError, "Could not fetch expense"
+		}
+		amount, date, currencyCode = linked.Amount, linked.Date, linked.CurrencyCode
+		field, id = "expense_id", req.ExpenseID
+	case req.IncomeID != "":
+		if debt.Direction != DirectionLent {
+			return 0, "", http.StatusBadRequest, "Repayments of borrowed money are linked to an expense"
+		}
+		objectId, err := utils.StringToObjectId(req.IncomeID)
+		if err != nil {
+			return 0, "", http.StatusBadRequest, "Invalid income ID"
+		}
+		var linked income.Income
+		err = database.Collection(h.config.CollectionIncomesName).FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&linked)
+		if err == mongo.ErrNoDocuments {
+			return 0, "", http.StatusNotFound, "Income not found"
+		} else if err != nil {
+			return 0, "", http.StatusInternalServerError, "Could not fetch income"
+		}
+		amount, date, currencyCode = linked.Amount, linked.Date, linked.CurrencyCode
+		field, id = "income_id", req.IncomeID
+	default:
+		return 0, "", 0, ""
+	}
+
+	if currencyCode != debt.CurrencyCode {
+		return 0, "", http.StatusBadRequest, "Currency does not match the debt currency"
+	}
+	count, err := database.Collection(h.config.CollectionRepaymentsName).CountDocuments(ctx, bson.M{"user_id": userID, field: id})
+	if err != nil {
+		return 0, "", http.StatusInternalServerError, "Could not fetch repayments"
+	}
+	if count > 0 {
+		return 0, "", http.StatusConflict, "Record is already linked to a repayment"
+	}
+	return amount, date, 0, ""
+}
+
+// HandleCreateRepayment records a partial or full repayment of a debt
+func (h *Handler) HandleCreateRepayment(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateRepaymentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	debt, _, ok := h.findDebt(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	linkedAmount, linkedDate, status, message := h.linkedRecord(ctx, userID, debt, req)
+	if status != 0 {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+	if req.Amount == 0 {
+		req.Amount = linkedAmount
+	}
+	if req.Date == "" {
+		req.Date = linkedDate
+	}
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount is required"})
+		return
+	}
+	if req.Date == "" {
+		req.Date = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+	if req.Amount > debt.Outstanding+amountTolerance {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Repayment exceeds the outstanding balance"})
+		return
+	}
+
+	repayment := Repayment{
+		UserID:    userID,
+		DebtID:    debt.ID,
+		Amount:    req.Amount,
+		Date:      req.Date,
+		ExpenseID: req.ExpenseID,
+		IncomeID:  req.IncomeID,
+		Note:      req.Note,
+		CreatedAt: utils.Timestamp(),
+	}
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRepaymentsName)
+	result, err := collection.InsertOne(ctx, repayment)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create repayment"})
+		return
+	}
+	repayment.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	c.JSON(http.StatusCreated, repayment)
+}
+
+// Delete repayment
+func (h *Handler) HandleDeleteRepayment(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("repayment_id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid repayment ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRepaymentsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectId, "user_id": userID, "debt_id": c.Param("id")})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete repayment"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Repayment not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Repayment deleted successfully"})
+}
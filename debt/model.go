@@ -0,0 +1,84 @@
+package debt
+
+const (
+	DirectionLent     = "lent"     // the counterparty owes the user
+	DirectionBorrowed = "borrowed" // the user owes the counterparty
+
+	StatusOpen    = "open"
+	StatusSettled = "settled"
+	StatusOverdue = "overdue"
+)
+
+// Debt is money lent to or borrowed from someone outside the app, repaid in parts
+type Debt struct {
+	ID           string  `bson:"_id,omitempty" json:"id"`
+	UserID       string  `bson:"user_id" json:"user_id"`
+	Direction    string  `bson:"direction" json:"direction"`
+	Counterparty string  `bson:"counterparty" json:"counterparty"`
+	Principal    float64 `bson:"principal" json:"principal"`
+	CurrencyCode string  `bson:"currency_code" json:"currency_code"`
+	// InterestRate is a yearly simple interest in percent
+	InterestRate float64 `bson:"interest_rate,omitempty" json:"interest_rate,omitempty"`
+	StartDate    string  `bson:"start_date" json:"start_date"`
+	DueDate      string  `bson:"due_date,omitempty" json:"due_date,omitempty"`
+	Note         string  `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt    string  `bson:"created_at" json:"created_at"`
+	UpdatedAt    string  `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+
+	// Computed on read from the repayments
+	Interest    float64 `bson:"-" json:"interest"`
+	Repaid      float64 `bson:"-" json:"repaid"`
+	Outstanding float64 `bson:"-" json:"outstanding"`
+	Status      string  `bson:"-" json:"status"` // open, settled or overdue
+}
+
+type CreateDebtRequest struct {
+	Direction    string  `json:"direction" binding:"required"`
+	Counterparty string  `json:"counterparty" binding:"required"`
+	Principal    float64 `json:"principal" binding:"required"`
+	CurrencyCode string  `json:"currency_code" binding:"required"`
+	InterestRate float64 `json:"interest_rate"`
+	StartDate    string  `json:"start_date"`
+	DueDate      string  `json:"due_date"`
+	Note         string  `json:"note"`
+}
+
+// UpdateDebtRequest cannot change the direction or currency, which would change the
+// meaning of the repayments
+type UpdateDebtRequest struct {
+	Counterparty string   `json:"counterparty"`
+	Principal    float64  `json:"principal"`
+	InterestRate *float64 `json:"interest_rate"`
+	StartDate    string   `json:"start_date"`
+	DueDate      *string  `json:"due_date"` // an empty string removes the due date
+	Note         *string  `json:"note"`
+}
+
+// Repayment pays back part of a debt. Repayments of borrowed money can point to the
+// expense that paid them, repayments of lent money to the income that received them.
+type Repayment struct {
+	ID        string  `bson:"_id,omitempty" json:"id"`
+	UserID    string  `bson:"user_id" json:"user_id"`
+	DebtID    string  `bson:"debt_id" json:"debt_id"`
+	Amount    float64 `bson:"amount" json:"amount"`
+	Date      string  `bson:"date" json:"date"`
+	ExpenseID string  `bson:"expense_id,omitempty" json:"expense_id,omitempty"`
+	IncomeID  string  `bson:"income_id,omitempty" json:"income_id,omitempty"`
+	Note      string  `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt string  `bson:"created_at" json:"created_at"`
+}
+
+// CreateRepaymentRequest takes the amount and date of the linked expense or income
+// when they are not given
+type CreateRepaymentRequest struct {
+	Amount    float64 `json:"amount"`
+	Date      string  `json:"date"`
+	ExpenseID string  `json:"expense_id"`
+	IncomeID  string  `json:"income_id"`
+	Note      string  `json:"note"`
+}
+
+type GetDebtResponse struct {
+	Debt       Debt        `json:"debt"`
+	Repayments []Repayment `json:"repayments"`
+}
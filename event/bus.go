@@ -0,0 +1,127 @@
+package event
+
+import (
+	"my-finance-backend/utils"
+	"sync"
+	"sync/atomic"
+)
+
+// subscriptionBuffer is how many events may be queued for a client before it is
+// considered too slow and disconnected
+const subscriptionBuffer = 256
+
+// Bus fans out events published by the handlers to the subscriptions of the same user
+// and of the users the event is shared with.
+// It is in memory only: clients that were disconnected catch up through the sync API.
+type Bus struct {
+	mu            sync.RWMutex
+	lastID        int64
+	subscriptions map[string]map[*Subscription]struct{}
+	listeners     []func(Event)
+}
+
+// Subscription receives the events of one user until it is closed. Events is closed
+// when the subscription ends, including when the bus drops a client that fell behind.
+type Subscription struct {
+	UserID string
+	Events chan Event
+	bus    *Bus
+	once   sync.Once
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		subscriptions: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+// Listen registers a function called with every published event, whatever the user.
+// Listeners run synchronously in the publishing request, so they must be quick.
+func (b *Bus) Listen(listener func(Event)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.listeners = append(b.listeners, listener)
+}
+
+// Subscribe registers a new subscription for the user's events
+func (b *Bus) Subscribe(userID string) *Subscription {
+	subscription := &Subscription{
+		UserID: userID,
+		Events: make(chan Event, subscriptionBuffer),
+		bus:    b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscriptions[userID] == nil {
+		b.subscriptions[userID] = make(map[*Subscription]struct{})
+	}
+	b.subscriptions[userID][subscription] = struct{}{}
+	return subscription
+}
+
+// Close unregisters the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.bus.mu.Lock()
+	defer s.bus.mu.Unlock()
+	s.bus.remove(s)
+}
+
+// remove must be called with the write lock held
+func (b *Bus) remove(s *Subscription) {
+	s.once.Do(func() {
+		delete(b.subscriptions[s.UserID], s)
+		if len(b.subscriptions[s.UserID]) == 0 {
+			delete(b.subscriptions, s.UserID)
+		}
+		close(s.Events)
+	})
+}
+
+// Publish delivers the event to every subscription of event.UserID and event.SharedWith
+// without blocking. Subscriptions whose buffer is full are closed so the client reconnects
+// and resyncs. Listeners get the event once, for its owner.
+func (b *Bus) Publish(event Event) {
+	if b == nil || event.UserID == "" {
+		return
+	}
+	event.ID = atomic.AddInt64(&b.lastID, 1)
+	if event.Timestamp == "" {
+		event.Timestamp = utils.Timestamp()
+	}
+
+	recipients := []string{event.UserID}
+	seen := map[string]bool{event.UserID: true}
+	for _, userID := range event.SharedWith {
+		if userID != "" && !seen[userID] {
+			seen[userID] = true
+			recipients = append(recipients, userID)
+		}
+	}
+
+	var slow []*Subscription
+	b.mu.RLock()
+	listeners := b.listeners
+	for _, userID := range recipients {
+		for subscription := range b.subscriptions[userID] {
+			select {
+			case subscription.Events <- event:
+			default:
+				slow = append(slow, subscription)
+			}
+		}
+	}
+	b.mu.RUnlock()
+
+	if len(slow) > 0 {
+		b.mu.Lock()
+		for _, subscription := range slow {
+			b.remove(subscription)
+		}
+		b.mu.Unlock()
+	}
+
+	for _, listener := range listeners {
+		listener(event)
+	}
+}
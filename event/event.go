@@ -0,0 +1,96 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// heartbeatInterval keeps idle streams open through proxies that close silent connections
+const heartbeatInterval = 25 * time.Second
+
+type Handler struct {
+	bus *Bus
+}
+
+func NewHandler(bus *Bus) *Handler {
+	return &Handler{
+		bus: bus,
+	}
+}
+
+// HandleStream streams the user's change events as Server-Sent Events, along with those of
+// the split expenses other household members share with them. There are no budget events,
+// as the application has no budgets. The optional types query parameter is a comma
+// separated list of event type prefixes, e.g. expense,category.
+func (h *Handler) HandleStream(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var prefixes []string
+	for _, value := range strings.Split(c.Query("types"), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			prefixes = append(prefixes, value)
+		}
+	}
+
+	subscription := h.bus.Subscribe(userID)
+	defer subscription.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+
+	// Tell the client the stream is live, so it knows when to resync missed changes
+	fmt.Fprint(c.Writer, "event: ready\ndata: {}\n\n")
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-heartbeat.C:
+			fmt.Fprint(w, ": ping\n\n")
+			return true
+		case event, ok := <-subscription.Events:
+			if !ok {
+				// Dropped for falling behind
+				return false
+			}
+			if !matchesType(event.Type, prefixes) {
+				return true
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return true
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+			return true
+		}
+	})
+}
+
+func matchesType(eventType string, prefixes []string) bool {
+	if len(prefixes) == 0 {
+		return true
+	}
+	for _, prefix := range prefixes {
+		if eventType == prefix || strings.HasPrefix(eventType, prefix+".") {
+			return true
+		}
+	}
+	return false
+}
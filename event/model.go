@@ -0,0 +1,44 @@
+package event
+
+const (
+	EntityImport       = "import"
+	EntityNotification = "notification"
+
+	// TypeImportCompleted is published once a CSV import has been processed
+	TypeImportCompleted = "import.completed"
+	// TypeNotificationCreated is published when a notification is added to the user's feed
+	TypeNotificationCreated = "notification.created"
+)
+
+// Event is a change pushed to the connected clients of the user owning the entity and of
+// the users it is shared with
+type Event struct {
+	ID     int64  `json:"id"`
+	Type   string `json:"type"` // <entity_type>.<past tense action>, e.g. expense.created
+	UserID string `json:"-"`
+	// SharedWith lists the other household members who see the entity, such as the
+	// participants of a split expense. Their clients receive the event too.
+	SharedWith []string    `json:"-"`
+	EntityType string      `json:"entity_type"`
+	EntityID   string      `json:"entity_id"`
+	Version    int64       `json:"version,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
+	Timestamp  string      `json:"timestamp"`
+}
+
+// actionTypes maps audit actions to the suffix of the event type
+var actionTypes = map[string]string{
+	"create":  "created",
+	"update":  "updated",
+	"delete":  "deleted",
+	"restore": "restored",
+	"revert":  "reverted",
+}
+
+// ChangeType returns the event type for an action on an entity, e.g. expense.deleted
+func ChangeType(entityType string, action string) string {
+	if suffix, ok := actionTypes[action]; ok {
+		return entityType + "." + suffix
+	}
+	return entityType + "." + action
+}
@@ -0,0 +1,303 @@
+package expense
+
+import (
+	"context"
+	"errors"
+	"my-finance-backend/account"
+	"my-finance-backend/audit"
+	"my-finance-backend/category"
+	"my-finance-backend/event"
+	"my-finance-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// validateBulkRequest checks the action specific fields of a bulk request
+func (h *Handler) validateBulkRequest(ctx context.Context, userID string, req *BulkExpenseRequest) (int, string) {
+	if len(req.ExpenseIDs) == 0 && !req.AllMatching {
+		return http.StatusBadRequest, "expense_ids or all_matching is required"
+	}
+	if len(req.ExpenseIDs) > 0 && req.AllMatching {
+		return http.StatusBadRequest, "expense_ids and all_matching cannot be combined"
+	}
+
+	switch req.Action {
+	case BulkActionRecategorize:
+		if req.CategoryID == "" {
+			return 0, ""
+		}
+		categoryObjectId, err := utils.StringToObjectId(req.CategoryID)
+		if err != nil {
+			return http.StatusBadRequest, "Invalid category ID"
+		}
+		collectionCategory := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
+		var existing category.Category
+		err = collectionCategory.FindOne(ctx, utils.NotDeleted(bson.M{"_id": categoryObjectId, "user_id": userID})).Decode(&existing)
+		if err == mongo.ErrNoDocuments {
+			return http.StatusNotFound, "Category not found"
+		} else if err != nil {
+			return http.StatusInternalServerError, "Could not fetch category"
+		}
+	case BulkActionRetag:
+		if req.TagMode == "" {
+			req.TagMode = BulkTagModeAdd
+		}
+		if req.TagMode != BulkTagModeAdd && req.TagMode != BulkTagModeRemove && req.TagMode != BulkTagModeReplace {
+			return http.StatusBadRequest, "tag_mode must be add, remove or replace"
+		}
+		if req.TagIDs == nil {
+			req.TagIDs = make([]string, 0)
+		}
+	case BulkActionSetCurrency:
+		if req.CurrencyCode == "" {
+			return http.StatusBadRequest, "currency_code is required"
+		}
+	case BulkActionShiftDate:
+		if req.Days == 0 {
+			return http.StatusBadRequest, "days must not be zero"
+		}
+	case BulkActionDelete:
+	default:
+		return http.StatusBadRequest, "Unknown action: " + req.Action
+	}
+	return 0, ""
+}
+
+// applyBulkAction performs the requested action on a single expense and records it in the
+// audit trail. It returns the change event, which the caller publishes once the change is final.
+func (h *Handler) applyBulkAction(ctx context.Context, c *gin.Context, collection *mongo.Collection, userID string, req *BulkExpenseRequest, expense Expense) (event.Event, error) {
+	if expense.ReconciliationID != "" {
+		return event.Event{}, ErrReconciled
+	}
+	objectId, err := utils.StringToObjectId(expense.ID)
+	if err != nil {
+		return event.Event{}, err
+	}
+	filter := utils.NotDeleted(bson.M{"_id": objectId, "user_id": userID, "reconciliation_id": bson.M{"$exists": false}})
+
+	var update bson.M
+	switch req.Action {
+	case BulkActionDelete:
+		// Deleted expenses go to the trash like HandleDeleteExpense does
+		update = utils.SoftDeleteUpdate()
+	case BulkActionRecategorize:
+		if req.CategoryID == "" {
+			update = bson.M{"$unset": bson.M{"category_id": ""}}
+		} else {
+			update = bson.M{"$set": bson.M{"category_id": req.CategoryID}}
+		}
+	case BulkActionRetag:
+		switch req.TagMode {
+		case BulkTagModeRemove:
+			update = bson.M{"$pull": bson.M{"tag_ids": bson.M{"$in": req.TagIDs}}}
+		case BulkTagModeReplace:
+			update = bson.M{"$set": bson.M{"tag_ids": req.TagIDs}}
+		default:
+			update = bson.M{"$addToSet": bson.M{"tag_ids": bson.M{"$each": req.TagIDs}}}
+		}
+	case BulkActionSetCurrency:
+		// Money recorded in an account must stay in its currency
+		if expense.AccountID != "" && expense.CurrencyCode != req.CurrencyCode {
+			if err := account.Check(ctx, h.mongoClient, h.config, userID, expense.AccountID, req.CurrencyCode); err != nil {
+				return event.Event{}, err
+			}
+		}
+		update = bson.M{"$set": bson.M{"currency_code": req.CurrencyCode}}
+	case BulkActionShiftDate:
+		date, err := time.Parse("2006-01-02", expense.Date)
+		if err != nil {
+			return event.Event{}, errors.New("Expense has an invalid date")
+		}
+		update = bson.M{"$set": bson.M{"date": date.AddDate(0, 0, req.Days).Format("2006-01-02")}}
+	}
+
+	var after Expense
+	err = collection.FindOneAndUpdate(ctx, filter, utils.Touch(update), options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+	if err == mongo.ErrNoDocuments {
+		return event.Event{}, errors.New("Expense not found")
+	} else if err != nil {
+		return event.Event{}, err
+	}
+
+	action := audit.ActionUpdate
+	if req.Action == BulkActionDelete {
+		action = audit.ActionDelete
+	}
+	audit.Record(ctx, h.mongoClient, h.config, c, audit.EntityExpense, expense.ID, action, &expense, &after)
+	return changeEvent(action, expense.ID, &expense, &after), nil
+}
+
+// HandleBulkExpenses applies one action (recategorize, retag, set_currency, shift_date or delete)
+// to many expenses at once. Targets are either expense_ids or, with all_matching, every expense
+// matching the query filters of parseExpenseFilter, at least one of which is required.
+// With atomic, either all items succeed or the whole operation is rolled back.
+func (h *Handler) HandleBulkExpenses(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req BulkExpenseRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	if status, message := h.validateBulkRequest(ctx, userID, &req); status != 0 {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	// Resolve the target expenses
+	var response BulkExpenseResponse
+	response.Results = make([]BulkItemResult, 0)
+
+	var filter bson.M
+	if req.AllMatching {
+		var err error
+		filter, err = parseExpenseFilter(c, userID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		// Without any filter every expense of the user would be changed
+		if len(filter) == len(utils.NotDeleted(bson.M{"user_id": userID})) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "all_matching requires at least one filter parameter"})
+			return
+		}
+	} else {
+		objectIDs := make([]interface{}, 0, len(req.ExpenseIDs))
+		for _, expenseID := range req.ExpenseIDs {
+			objectId, err := utils.StringToObjectId(expenseID)
+			if err != nil {
+				response.Results = append(response.Results, BulkItemResult{ID: expenseID, Status: "error", Error: "Invalid expense ID"})
+				continue
+			}
+			objectIDs = append(objectIDs, objectId)
+		}
+		filter = utils.NotDeleted(bson.M{"_id": bson.M{"$in": objectIDs}, "user_id": userID})
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expenses"})
+		return
+	}
+	var expenses []Expense = make([]Expense, 0)
+	if err = cursor.All(ctx, &expenses); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode expenses"})
+		return
+	}
+
+	if !req.AllMatching {
+		found := make(map[string]bool, len(expenses))
+		for _, expense := range expenses {
+			found[expense.ID] = true
+		}
+		for _, expenseID := range req.ExpenseIDs {
+			if _, err := utils.StringToObjectId(expenseID); err == nil && !found[expenseID] {
+				response.Results = append(response.Results, BulkItemResult{ID: expenseID, Status: "error", Error: "Expense not found"})
+			}
+		}
+	}
+
+	if req.Atomic {
+		if len(response.Results) > 0 {
+			// Some targets are invalid, nothing is applied
+			for _, expense := range expenses {
+				response.Results = append(response.Results, BulkItemResult{ID: expense.ID, Status: "rolled_back"})
+			}
+			response.ErrorCount = len(response.Results)
+			c.JSON(http.StatusUnprocessableEntity, response)
+			return
+		}
+		h.runBulkAtomic(ctx, c, collection, userID, &req, expenses, response)
+		return
+	}
+
+	for _, expense := range expenses {
+		changed, err := h.applyBulkAction(ctx, c, collection, userID, &req, expense)
+		if err != nil {
+			response.Results = append(response.Results, BulkItemResult{ID: expense.ID, Status: "error", Error: err.Error()})
+			continue
+		}
+		h.bus.Publish(changed)
+		response.Results = append(response.Results, BulkItemResult{ID: expense.ID, Status: "ok"})
+	}
+
+	for _, result := range response.Results {
+		if result.Status == "ok" {
+			response.SuccessCount++
+		} else {
+			response.ErrorCount++
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// runBulkAtomic applies the bulk action to all expenses inside a transaction
+func (h *Handler) runBulkAtomic(ctx context.Context, c *gin.Context, collection *mongo.Collection, userID string, req *BulkExpenseRequest, expenses []Expense, response BulkExpenseResponse) {
+	session, err := h.mongoClient.StartSession()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not start transaction"})
+		return
+	}
+	defer session.EndSession(ctx)
+
+	failedID := ""
+	var failure error
+	var changes []event.Event
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		// The callback runs again when the transaction is retried
+		failedID, failure, changes = "", nil, nil
+		for _, expense := range expenses {
+			changed, err := h.applyBulkAction(sessionCtx, c, collection, userID, req, expense)
+			if err != nil {
+				failedID, failure = expense.ID, err
+				return nil, err
+			}
+			changes = append(changes, changed)
+		}
+		return nil, nil
+	})
+
+	if err != nil && failure == nil {
+		// The transaction itself failed, e.g. the deployment is not a replica set
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not run transaction: " + err.Error()})
+		return
+	}
+
+	for _, expense := range expenses {
+		switch {
+		case failure == nil:
+			response.Results = append(response.Results, BulkItemResult{ID: expense.ID, Status: "ok"})
+			response.SuccessCount++
+		case expense.ID == failedID:
+			response.Results = append(response.Results, BulkItemResult{ID: expense.ID, Status: "error", Error: failure.Error()})
+			response.ErrorCount++
+		default:
+			response.Results = append(response.Results, BulkItemResult{ID: expense.ID, Status: "rolled_back"})
+			response.ErrorCount++
+		}
+	}
+
+	if failure != nil {
+		c.JSON(http.StatusUnprocessableEntity, response)
+		return
+	}
+	// Announce the changes only now that they are committed
+	for _, changed := range changes {
+		h.bus.Publish(changed)
+	}
+	c.JSON(http.StatusOK, response)
+}
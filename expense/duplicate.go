@@ -0,0 +1,363 @@
+package expense
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"my-finance-backend/utils"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateDetector decides whether two expenses are likely the same purchase
+type duplicateDetector struct {
+	DateWindowDays    int
+	AmountTolerance   float64
+	MinNameSimilarity float64
+}
+
+// newDuplicateDetector builds a detector from the configured defaults, overridden by the
+// date_window_days, amount_tolerance and name_similarity query parameters when present
+func (h *Handler) newDuplicateDetector(c *gin.Context) (duplicateDetector, error) {
+	detector := duplicateDetector{
+		DateWindowDays:    h.config.DuplicateDateWindowDays,
+		AmountTolerance:   h.config.DuplicateAmountTolerance,
+		MinNameSimilarity: h.config.DuplicateNameSimilarity,
+	}
+
+	if value := c.Query("date_window_days"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			return detector, fmt.Errorf("Invalid date_window_days parameter")
+		}
+		detector.DateWindowDays = days
+	}
+	if value := c.Query("amount_tolerance"); value != "" {
+		tolerance, err := strconv.ParseFloat(value, 64)
+		if err != nil || tolerance < 0 || tolerance > 1 {
+			return detector, fmt.Errorf("Invalid amount_tolerance parameter")
+		}
+		detector.AmountTolerance = tolerance
+	}
+	if value := c.Query("name_similarity"); value != "" {
+		similarity, err := strconv.ParseFloat(value, 64)
+		if err != nil || similarity < 0 || similarity > 1 {
+			return detector, fmt.Errorf("Invalid name_similarity parameter")
+		}
+		detector.MinNameSimilarity = similarity
+	}
+	return detector, nil
+}
+
+// amountsMatch reports whether the amounts differ by at most the tolerated fraction
+func (d duplicateDetector) amountsMatch(a float64, b float64) bool {
+	return math.Abs(a-b) <= d.AmountTolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+// datesMatch reports whether two YYYY-MM-DD dates are within the date window
+func (d duplicateDetector) datesMatch(a string, b string) bool {
+	left, err := time.Parse("2006-01-02", a)
+	if err != nil {
+		return a == b
+	}
+	right, err := time.Parse("2006-01-02", b)
+	if err != nil {
+		return a == b
+	}
+	days := math.Abs(left.Sub(right).Hours() / 24)
+	return days <= float64(d.DateWindowDays)
+}
+
+// IsDuplicate reports whether two expenses are suspected duplicates
+func (d duplicateDetector) IsDuplicate(a Expense, b Expense) bool {
+	if a.CurrencyCode != b.CurrencyCode {
+		return false
+	}
+	return d.amountsMatch(a.Amount, b.Amount) &&
+		d.datesMatch(a.Date, b.Date) &&
+		utils.Similarity(a.Name, b.Name) >= d.MinNameSimilarity
+}
+
+// candidateFilter returns a filter selecting the stored expenses that could be duplicates of expense
+func (d duplicateDetector) candidateFilter(userID string, expense Expense) bson.M {
+	filter := utils.NotDeleted(bson.M{
+		"user_id":       userID,
+		"currency_code": expense.CurrencyCode,
+	})
+	// amountsMatch measures the difference against the larger amount, so a candidate may
+	// be up to tolerance/(1-tolerance) of the expense amount away
+	if d.AmountTolerance < 1 {
+		delta := d.AmountTolerance * math.Abs(expense.Amount) / (1 - d.AmountTolerance)
+		filter["amount"] = bson.M{
+			"$gte": expense.Amount - delta,
+			"$lte": expense.Amount + delta,
+		}
+	}
+	if date, err := time.Parse("2006-01-02", expense.Date); err == nil {
+		filter["date"] = bson.M{
+			"$gte": date.AddDate(0, 0, -d.DateWindowDays).Format("2006-01-02"),
+			"$lte": date.AddDate(0, 0, d.DateWindowDays).Format("2006-01-02"),
+		}
+	} else {
+		filter["date"] = expense.Date
+	}
+	return filter
+}
+
+// findDuplicates returns the stored expenses that expense duplicates, leaving out those skip reports
+func (d duplicateDetector) findDuplicates(ctx context.Context, collection *mongo.Collection, userID string, expense Expense, skip func(Expense) bool) ([]Expense, error) {
+	cursor, err := collection.Find(ctx, d.candidateFilter(userID, expense))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var duplicates []Expense
+	for cursor.Next(ctx) {
+		var candidate Expense
+		if err := cursor.Decode(&candidate); err != nil {
+			return nil, err
+		}
+		if !skip(candidate) && d.IsDuplicate(expense, candidate) {
+			duplicates = append(duplicates, candidate)
+		}
+	}
+	return duplicates, cursor.Err()
+}
+
+// confirmedRepeat reports whether the user marked two of the expenses as not duplicates
+// of each other: an expense matching them both is then a repeat purchase, not a duplicate
+func confirmedRepeat(expenses []Expense, dismissed map[string]bool) bool {
+	for i := range expenses {
+		for j := i + 1; j < len(expenses); j++ {
+			if dismissed[duplicatePairKey(expenses[i].ID, expenses[j].ID)] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// loadDismissals returns the pair keys the user confirmed as not duplicates
+func (h *Handler) loadDismissals(ctx context.Context, userID string) (map[string]bool, error) {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionDuplicateDismissalsName)
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return nil, err
+	}
+	var dismissals []DuplicateDismissal
+	if err = cursor.All(ctx, &dismissals); err != nil {
+		return nil, err
+	}
+	dismissed := make(map[string]bool, len(dismissals))
+	for _, dismissal := range dismissals {
+		dismissed[dismissal.PairKey] = true
+	}
+	return dismissed, nil
+}
+
+// duplicatePairKey identifies an unordered pair of expenses
+func duplicatePairKey(a string, b string) string {
+	if a > b {
+		a, b = b, a
+	}
+	return a + ":" + b
+}
+
+// HandleGetDuplicates lists groups of suspected duplicate expenses.
+// Optional query parameters: from, to (YYYY-MM-DD) and the detector overrides
+// accepted by newDuplicateDetector.
+func (h *Handler) HandleGetDuplicates(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	detector, err := h.newDuplicateDetector(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := utils.NotDeleted(bson.M{"user_id": userID})
+	dateFilter := bson.M{}
+	if from := c.Query("from"); from != "" {
+		if _, err := time.Parse("2006-01-02", from); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from, expected YYYY-MM-DD"})
+			return
+		}
+		dateFilter["$gte"] = from
+	}
+	if to := c.Query("to"); to != "" {
+		if _, err := time.Parse("2006-01-02", to); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to, expected YYYY-MM-DD"})
+			return
+		}
+		dateFilter["$lte"] = to
+	}
+	if len(dateFilter) > 0 {
+		filter["date"] = dateFilter
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Load the pairs the user already confirmed as not duplicates
+	dismissed, err := h.loadDismissals(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch dismissed duplicates"})
+		return
+	}
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "date", Value: 1}})
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expenses"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var expenses []Expense = make([]Expense, 0)
+	if err = cursor.All(ctx, &expenses); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode expenses"})
+		return
+	}
+
+	// Union-find over expenses, joining every suspected duplicate pair
+	parent := make([]int, len(expenses))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	// Expenses are sorted by date, so only the following expenses inside the window need comparing
+	for i := range expenses {
+		for j := i + 1; j < len(expenses); j++ {
+			if !detector.datesMatch(expenses[i].Date, expenses[j].Date) {
+				break
+			}
+			if dismissed[duplicatePairKey(expenses[i].ID, expenses[j].ID)] {
+				continue
+			}
+			if detector.IsDuplicate(expenses[i], expenses[j]) {
+				parent[find(j)] = find(i)
+			}
+		}
+	}
+
+	groupIndex := make(map[int]int)
+	groups := make([]DuplicateGroup, 0)
+	for i, expense := range expenses {
+		root := find(i)
+		index, ok := groupIndex[root]
+		if !ok {
+			index = len(groups)
+			groupIndex[root] = index
+			groups = append(groups, DuplicateGroup{})
+		}
+		groups[index].Expenses = append(groups[index].Expenses, expense)
+	}
+
+	response := GetDuplicatesResponse{Groups: make([]DuplicateGroup, 0)}
+	for _, group := range groups {
+		if len(group.Expenses) > 1 {
+			response.Groups = append(response.Groups, group)
+		}
+	}
+	sort.SliceStable(response.Groups, func(i, j int) bool {
+		return response.Groups[i].Expenses[0].Date > response.Groups[j].Expenses[0].Date
+	})
+
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleDismissDuplicates marks the given expenses as not duplicates of each other
+func (h *Handler) HandleDismissDuplicates(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req DismissDuplicatesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least two expense IDs are required"})
+		return
+	}
+	expenseIDs := make([]string, 0, len(req.ExpenseIDs))
+	seen := make(map[string]bool)
+	for _, expenseID := range req.ExpenseIDs {
+		if !seen[expenseID] {
+			seen[expenseID] = true
+			expenseIDs = append(expenseIDs, expenseID)
+		}
+	}
+	req.ExpenseIDs = expenseIDs
+	if len(req.ExpenseIDs) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At least two distinct expense IDs are required"})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Make sure all expenses belong to the user
+	objectIDs := make([]interface{}, 0, len(req.ExpenseIDs))
+	for _, expenseID := range req.ExpenseIDs {
+		objectId, err := utils.StringToObjectId(expenseID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expense ID " + expenseID})
+			return
+		}
+		objectIDs = append(objectIDs, objectId)
+	}
+	count, err := collection.CountDocuments(ctx, utils.NotDeleted(bson.M{"_id": bson.M{"$in": objectIDs}, "user_id": userID}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expenses"})
+		return
+	}
+	if count != int64(len(objectIDs)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		return
+	}
+
+	dismissalCollection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionDuplicateDismissalsName)
+	now := time.Now().UTC().Format(time.RFC3339)
+	models := make([]mongo.WriteModel, 0)
+	for i := range req.ExpenseIDs {
+		for j := i + 1; j < len(req.ExpenseIDs); j++ {
+			pairKey := duplicatePairKey(req.ExpenseIDs[i], req.ExpenseIDs[j])
+			models = append(models, mongo.NewUpdateOneModel().
+				SetFilter(bson.M{"user_id": userID, "pair_key": pairKey}).
+				SetUpdate(bson.M{"$setOnInsert": DuplicateDismissal{
+					UserID:    userID,
+					PairKey:   pairKey,
+					CreatedAt: now,
+				}}).
+				SetUpsert(true))
+		}
+	}
+
+	if _, err := dismissalCollection.BulkWrite(ctx, models); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not dismiss duplicates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Expenses marked as not duplicates"})
+}
@@ -1,15 +1,20 @@
 package expense
 
 import (
-	"bytes"
 	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
+	"my-finance-backend/account"
+	"my-finance-backend/audit"
 	"my-finance-backend/category"
 	"my-finance-backend/config"
+	"my-finance-backend/event"
+	"my-finance-backend/rule"
+	"my-finance-backend/suggestion"
 	"my-finance-backend/utils"
 	"net/http"
 	"strconv"
@@ -23,17 +28,22 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrReconciled is returned for changes to an expense locked by a completed reconciliation
+var ErrReconciled = errors.New("Expense is reconciled and cannot be changed")
+
 type Handler struct {
 	mongoClient *mongo.Client
 	jwtSecret   []byte
 	config      *config.Config
+	bus         *event.Bus
 }
 
-func NewHandler(mongoClient *mongo.Client, config *config.Config, jwtSecret []byte) *Handler {
+func NewHandler(mongoClient *mongo.Client, config *config.Config, jwtSecret []byte, bus *event.Bus) *Handler {
 	return &Handler{
 		mongoClient: mongoClient,
 		jwtSecret:   jwtSecret,
 		config:      config,
+		bus:         bus,
 	}
 }
 
@@ -42,7 +52,7 @@ func (h *Handler) HandleGetLastExpenses(c *gin.Context) {
 	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	filter := bson.M{"user_id": userID}
+	filter := utils.NotDeleted(bson.M{"user_id": userID})
 	// Get total count of expenses
 	_, err := collection.CountDocuments(ctx, filter)
 	if err != nil {
@@ -53,6 +63,8 @@ func (h *Handler) HandleGetLastExpenses(c *gin.Context) {
 	calculateSum := func(limit int) float64 {
 
 		pipeline := mongo.Pipeline{
+			// Step 0: Only the user's expenses that are not in the trash
+			{{Key: "$match", Value: filter}},
 			// Step 1: Group by unique dates
 			// Step 1: Group by date and calculate total amount per date
 			{{"$group", bson.D{
@@ -123,18 +135,55 @@ func (h *Handler) HandleCreateExpense(c *gin.Context) {
 	expense := Expense{
 		UserID:       userID,
 		CategoryID:   req.CategoryID,
+		AccountID:    req.AccountID,
 		Amount:       req.Amount,
 		CurrencyCode: req.CurrencyCode,
 		Name:         req.Name,
 		Description:  req.Description,
 		Date:         req.Date,
+		TagIDs:       req.TagIDs,
+		SearchText:   BuildSearchText(req.Name, req.Description),
+		Version:      1,
+		UpdatedAt:    utils.Timestamp(),
 	}
 
 	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	categoryID := req.CategoryID
+	// Apply the user's categorization rules
+	rules, err := rule.LoadRules(ctx, h.mongoClient, h.config, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load categorization rules"})
+		return
+	}
+	applyRules(rules, &expense, false)
+
+	if expense.AccountID != "" {
+		if err := account.Check(ctx, h.mongoClient, h.config, userID, expense.AccountID, expense.CurrencyCode); err != nil {
+			c.JSON(account.CheckStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if len(req.LineItems) > 0 {
+		if err := ValidateLineItems(ctx, h.mongoClient, h.config, userID, req.LineItems, expense.Amount); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		expense.LineItems = req.LineItems
+	}
+
+	if req.Split != nil {
+		split, err := h.resolveSplit(ctx, userID, req.Split, expense.Amount)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		expense.Split = split
+	}
+
+	categoryID := expense.CategoryID
 	if categoryID != "" {
 		collectionCategory := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
 
@@ -144,7 +193,7 @@ func (h *Handler) HandleCreateExpense(c *gin.Context) {
 			return
 		}
 		// Check if category exists
-		filter := bson.M{"_id": categoryObjectId}
+		filter := utils.NotDeleted(bson.M{"_id": categoryObjectId})
 		var category category.Category
 		err := collectionCategory.FindOne(ctx, filter).Decode(&category)
 		if err == mongo.ErrNoDocuments {
@@ -160,10 +209,29 @@ func (h *Handler) HandleCreateExpense(c *gin.Context) {
 		return
 	}
 	expense.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	h.recordChange(ctx, c, audit.ActionCreate, expense.ID, nil, &expense)
 
+	c.Header("ETag", utils.VersionETag(expense.ID, expense.Version))
 	c.JSON(http.StatusCreated, expense)
 }
 
+// Insert stores an expense created on behalf of another feature, such as paying a bill,
+// and records it like HandleCreateExpense does. Validation is left to the caller.
+func (h *Handler) Insert(ctx context.Context, c *gin.Context, expense *Expense) error {
+	expense.SearchText = BuildSearchText(expense.Name, expense.Description)
+	expense.Version = 1
+	expense.UpdatedAt = utils.Timestamp()
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+	result, err := collection.InsertOne(ctx, expense)
+	if err != nil {
+		return err
+	}
+	expense.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	h.recordChange(ctx, c, audit.ActionCreate, expense.ID, nil, expense)
+	return nil
+}
+
 func (h *Handler) HandleGetExpensesMonthly(c *gin.Context) {
 	userID := c.GetString("user_id")
 
@@ -205,13 +273,13 @@ func (h *Handler) HandleGetExpensesMonthly(c *gin.Context) {
 	endDateStr := endDate.Format("2006-01-02")
 
 	// Build filter for the date range and user
-	filter := bson.M{
+	filter := utils.NotDeleted(bson.M{
 		"user_id": userID,
 		"date": bson.M{
 			"$gte": startDateStr,
 			"$lt":  endDateStr,
 		},
-	}
+	})
 
 	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -240,12 +308,16 @@ func (h *Handler) HandleGetExpensesMonthly(c *gin.Context) {
 	response := GetMontlyExpensesResponse{
 		Expenses:    expenses,
 		TotalAmount: int64(totalAmount),
+		Categories:  categoryTotals(expenses),
 	}
 
-	c.JSON(http.StatusOK, response)
+	utils.JSONWithETag(c, http.StatusOK, response)
 }
 
-// Get all expenses for user with pagination
+// Get all expenses for user with pagination.
+// Accepts the filters of parseExpenseFilter, sort and order (see parseExpenseSort), and
+// either offset or cursor for paging. Pass the next_cursor of a response as cursor to get
+// the following page without skipping or repeating rows when expenses are added meanwhile.
 func (h *Handler) HandleGetExpenses(c *gin.Context) {
 	userID := c.GetString("user_id")
 
@@ -267,11 +339,16 @@ func (h *Handler) HandleGetExpenses(c *gin.Context) {
 	}
 
 	// Build filter
-	filter := bson.M{"user_id": userID}
+	filter, err := parseExpenseFilter(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// Add category filter if provided
-	if categoryID := c.Query("category_id"); categoryID != "" {
-		filter["category_id"] = categoryID
+	sort, err := parseExpenseSort(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
@@ -289,11 +366,20 @@ func (h *Handler) HandleGetExpenses(c *gin.Context) {
 	totalPages := int(math.Ceil(float64(totalCount) / float64(limit)))
 	currentPage := (offset / limit) + 1
 
-	// Get paginated expenses
+	// Get paginated expenses, fetching one extra row to know whether a next page exists
 	findOptions := options.Find().
-		SetSkip(int64(offset)).
-		SetLimit(int64(limit)).
-		SetSort(bson.D{{Key: "date", Value: -1}}) // Sort by date descending
+		SetLimit(int64(limit) + 1).
+		SetSort(sort.Document())
+
+	if pageCursor := c.Query("cursor"); pageCursor != "" {
+		if err := applyPageCursor(filter, sort, pageCursor); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		currentPage = 0
+	} else {
+		findOptions.SetSkip(int64(offset))
+	}
 
 	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
@@ -308,15 +394,22 @@ func (h *Handler) HandleGetExpenses(c *gin.Context) {
 		return
 	}
 
+	nextCursor := ""
+	if len(expenses) > limit {
+		expenses = expenses[:limit]
+		nextCursor = encodePageCursor(sort, expenses[limit-1])
+	}
+
 	response := PaginatedExpenseResponse{
 		Expenses:    expenses,
 		TotalCount:  totalCount,
 		CurrentPage: currentPage,
 		TotalPages:  totalPages,
 		Limit:       limit,
+		NextCursor:  nextCursor,
 	}
 
-	c.JSON(http.StatusOK, response)
+	utils.JSONWithETag(c, http.StatusOK, response)
 }
 
 // Get single expense
@@ -335,10 +428,10 @@ func (h *Handler) HandleGetExpense(c *gin.Context) {
 	}
 
 	var expense Expense
-	err := collection.FindOne(ctx, bson.M{
+	err := collection.FindOne(ctx, utils.NotDeleted(bson.M{
 		"_id":     objectId,
 		"user_id": userID,
-	}).Decode(&expense)
+	})).Decode(&expense)
 
 	if err == mongo.ErrNoDocuments {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
@@ -348,10 +441,12 @@ func (h *Handler) HandleGetExpense(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, expense)
+	utils.JSONWithVersionETag(c, http.StatusOK, expense.ID, expense.Version, expense)
 }
 
-// Update expense
+// Update expense.
+// With an If-Match header carrying the ETag of the expense, the update is only applied
+// if nobody else changed it in the meantime, otherwise 412 Precondition Failed is returned.
 func (h *Handler) HandleUpdateExpense(c *gin.Context) {
 	userID := c.GetString("user_id")
 	expenseID := c.Param("id")
@@ -383,6 +478,9 @@ func (h *Handler) HandleUpdateExpense(c *gin.Context) {
 	if req.Date != "" {
 		update["date"] = req.Date
 	}
+	if req.TagIDs != nil {
+		update["tag_ids"] = req.TagIDs
+	}
 
 	categoryID := req.CategoryID
 	if categoryID != "" {
@@ -394,7 +492,7 @@ func (h *Handler) HandleUpdateExpense(c *gin.Context) {
 			return
 		}
 		// Check if category exists
-		filter := bson.M{"_id": categoryObjectId}
+		filter := utils.NotDeleted(bson.M{"_id": categoryObjectId})
 		var category category.Category
 		err := collectionCategory.FindOne(ctx, filter).Decode(&category)
 		if err == mongo.ErrNoDocuments {
@@ -410,13 +508,125 @@ func (h *Handler) HandleUpdateExpense(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expense ID " + error.Error()})
 		return
 	}
+
+	expectedVersion, checkVersion, err := utils.IfMatchVersion(c, expenseID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Keep the current state for the audit trail
+	var before Expense
+	err = collection.FindOne(ctx, utils.NotDeleted(bson.M{
+		"_id":     objectId,
+		"user_id": userID,
+	})).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expense"})
+		return
+	}
+	if checkVersion && before.Version != expectedVersion {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Expense was modified by someone else"})
+		return
+	}
+	if before.ReconciliationID != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": ErrReconciled.Error()})
+		return
+	}
+
+	// Keep the search text in sync with the new name and description
+	if req.Name != "" || req.Description != "" {
+		name, description := before.Name, before.Description
+		if req.Name != "" {
+			name = req.Name
+		}
+		if req.Description != "" {
+			description = req.Description
+		}
+		update["search_text"] = BuildSearchText(name, description)
+	}
+
+	// Keep the split shares in line with the amount
+	unset := bson.M{}
+	amount := before.Amount
+	if req.Amount != 0 {
+		amount = req.Amount
+	}
+	if req.Split != nil {
+		split, err := h.resolveSplit(ctx, userID, req.Split, amount)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if split == nil {
+			unset["split"] = ""
+		} else {
+			update["split"] = split
+		}
+	} else if req.Amount != 0 && before.Split != nil {
+		split, err := before.Split.WithAmount(amount, before.UserID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		update["split"] = split
+	}
+
+	// Line items must keep adding up to the amount
+	if req.LineItems != nil {
+		if len(req.LineItems) == 0 {
+			unset["line_items"] = ""
+		} else {
+			if err := ValidateLineItems(ctx, h.mongoClient, h.config, userID, req.LineItems, amount); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+			update["line_items"] = req.LineItems
+		}
+	} else if req.Amount != 0 && len(before.LineItems) > 0 {
+		if err := ValidateLineItems(ctx, h.mongoClient, h.config, userID, before.LineItems, amount); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Line items must be updated with the amount: " + err.Error()})
+			return
+		}
+	}
+
+	// Money recorded in an account must be in its currency
+	accountID := before.AccountID
+	if req.AccountID != nil {
+		accountID = *req.AccountID
+		if accountID == "" {
+			unset["account_id"] = ""
+		} else {
+			update["account_id"] = accountID
+		}
+	}
+	if accountID != "" && (req.AccountID != nil || req.CurrencyCode != "") {
+		currencyCode := before.CurrencyCode
+		if req.CurrencyCode != "" {
+			currencyCode = req.CurrencyCode
+		}
+		if err := account.Check(ctx, h.mongoClient, h.config, userID, accountID, currencyCode); err != nil {
+			c.JSON(account.CheckStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	updateDocument := bson.M{"$set": update}
+	if len(unset) > 0 {
+		updateDocument["$unset"] = unset
+	}
+
+	// The update only applies to the version read above, so concurrent writes are detected
 	result, err := collection.UpdateOne(
 		ctx,
-		bson.M{
+		utils.MatchVersion(utils.NotDeleted(bson.M{
 			"_id":     objectId,
 			"user_id": userID,
-		},
-		bson.M{"$set": update},
+		}), before.Version),
+		utils.Touch(updateDocument),
 	)
 
 	if err != nil {
@@ -425,7 +635,7 @@ func (h *Handler) HandleUpdateExpense(c *gin.Context) {
 	}
 
 	if result.MatchedCount == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Expense was modified by someone else"})
 		return
 	}
 
@@ -441,10 +651,14 @@ func (h *Handler) HandleUpdateExpense(c *gin.Context) {
 		return
 	}
 
+	h.recordChange(ctx, c, audit.ActionUpdate, expense.ID, &before, &expense)
+
+	c.Header("ETag", utils.VersionETag(expense.ID, expense.Version))
 	c.JSON(http.StatusOK, expense)
 }
 
-// Delete expense
+// Delete expense. The expense is moved to the trash and can be restored until it is purged.
+// An If-Match header is honoured like in HandleUpdateExpense.
 func (h *Handler) HandleDeleteExpense(c *gin.Context) {
 	userID := c.GetString("user_id")
 	expenseID := c.Param("id")
@@ -457,25 +671,127 @@ func (h *Handler) HandleDeleteExpense(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expense ID " + error.Error()})
 		return
 	}
-	result, err := collection.DeleteOne(ctx, bson.M{
-		"_id":     objectId,
-		"user_id": userID,
-	})
 
+	expectedVersion, checkVersion, err := utils.IfMatchVersion(c, expenseID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete expense"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if result.DeletedCount == 0 {
+	filter := utils.NotDeleted(bson.M{
+		"_id":               objectId,
+		"user_id":           userID,
+		"reconciliation_id": bson.M{"$exists": false},
+	})
+	if checkVersion {
+		filter = utils.MatchVersion(filter, expectedVersion)
+	}
+
+	var after Expense
+	err = collection.FindOneAndUpdate(ctx, filter, utils.Touch(utils.SoftDeleteUpdate()),
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+
+	if err == mongo.ErrNoDocuments {
+		if h.expenseReconciled(ctx, collection, userID, objectId) {
+			c.JSON(http.StatusConflict, gin.H{"error": ErrReconciled.Error()})
+			return
+		}
+		if checkVersion && h.expenseExists(ctx, collection, userID, objectId) {
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Expense was modified by someone else"})
+			return
+		}
 		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
 		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete expense"})
+		return
 	}
 
+	before := after
+	before.DeletedAt = ""
+	before.Version--
+	h.recordChange(ctx, c, audit.ActionDelete, after.ID, &before, &after)
+
 	c.JSON(http.StatusOK, gin.H{"message": "Expense deleted successfully"})
 }
 
-// HandleUploadCSV handles the upload of expenses via CSV file
+// expenseExists reports whether a non-deleted expense of the user exists, used to tell
+// a missing expense from a version conflict
+func (h *Handler) expenseExists(ctx context.Context, collection *mongo.Collection, userID string, objectId primitive.ObjectID) bool {
+	count, err := collection.CountDocuments(ctx, utils.NotDeleted(bson.M{"_id": objectId, "user_id": userID}))
+	return err == nil && count > 0
+}
+
+// expenseReconciled reports whether the expense is locked by a completed reconciliation
+func (h *Handler) expenseReconciled(ctx context.Context, collection *mongo.Collection, userID string, objectId primitive.ObjectID) bool {
+	count, err := collection.CountDocuments(ctx, bson.M{"_id": objectId, "user_id": userID, "reconciliation_id": bson.M{"$exists": true}})
+	return err == nil && count > 0
+}
+
+// checkCategory verifies that a category exists and belongs to the user
+func (h *Handler) checkCategory(ctx context.Context, userID string, categoryID string) error {
+	objectId, err := utils.StringToObjectId(categoryID)
+	if err != nil {
+		return errors.New("Invalid category ID")
+	}
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
+	count, err := collection.CountDocuments(ctx, utils.NotDeleted(bson.M{"_id": objectId, "user_id": userID}))
+	if err != nil {
+		return errors.New("Could not fetch category")
+	}
+	if count == 0 {
+		return errors.New("Category not found")
+	}
+	return nil
+}
+
+// HandleRestoreExpense takes an expense out of the trash
+func (h *Handler) HandleRestoreExpense(c *gin.Context) {
+	userID := c.GetString("user_id")
+	expenseID := c.Param("id")
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	objectId, error := utils.StringToObjectId(expenseID)
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expense ID " + error.Error()})
+		return
+	}
+
+	result, err := collection.UpdateOne(ctx, utils.OnlyDeleted(bson.M{
+		"_id":     objectId,
+		"user_id": userID,
+	}), utils.Touch(utils.RestoreUpdate()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not restore expense"})
+		return
+	}
+
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found in trash"})
+		return
+	}
+
+	var expense Expense
+	err = collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&expense)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch restored expense"})
+		return
+	}
+	h.recordChange(ctx, c, audit.ActionRestore, expense.ID, nil, &expense)
+
+	c.JSON(http.StatusOK, expense)
+}
+
+// HandleUploadCSV handles the upload of expenses via CSV file.
+// With suggest=true, rows left uncategorized by the rules get the learned
+// category suggestion when its confidence reaches SuggestionMinConfidence.
+// Rows that look like duplicates of stored expenses are imported and listed in the
+// response, or rejected with reject_duplicates=true. Rows imported earlier in the same
+// file do not count, and neither do repeats the user confirmed by dismissing a pair of
+// the matching expenses. skip_duplicate_check=true turns the check off; see
+// newDuplicateDetector for the tuning parameters.
 func (h *Handler) HandleUploadCSV(c *gin.Context) {
 	userID := c.GetString("user_id")
 	if userID == "" {
@@ -483,6 +799,14 @@ func (h *Handler) HandleUploadCSV(c *gin.Context) {
 		return
 	}
 
+	checkDuplicates := c.Query("skip_duplicate_check") != "true"
+	rejectDuplicates := c.Query("reject_duplicates") == "true"
+	detector, err := h.newDuplicateDetector(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Get the file from the request
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -517,9 +841,38 @@ func (h *Handler) HandleUploadCSV(c *gin.Context) {
 	}
 
 	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Covers training the suggestion model and a duplicate check and audit entry per row
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
+	// Load the categorization rules once for the whole import
+	rules, err := rule.LoadRules(ctx, h.mongoClient, h.config, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load categorization rules"})
+		return
+	}
+
+	var model *suggestion.Model
+	if c.Query("suggest") == "true" {
+		model, err = suggestion.Train(ctx, h.mongoClient, h.config, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not train suggestion model"})
+			return
+		}
+	}
+
+	var dismissed map[string]bool
+	if checkDuplicates {
+		dismissed, err = h.loadDismissals(ctx, userID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch dismissed duplicates"})
+			return
+		}
+	}
+	// Expenses imported from this file are not duplicates of its other rows
+	imported := make(map[string]bool)
+	skipImported := func(candidate Expense) bool { return imported[candidate.ID] }
+
 	var response CSVUploadResponse
 	var errors []string
 
@@ -601,37 +954,101 @@ func (h *Handler) HandleUploadCSV(c *gin.Context) {
 			Description:  strings.TrimSpace(record[3]),
 			Date:         date.Format("2006-01-02"),
 		}
-		// Check if expense with same name and date existed
-		existingExpense := Expense{}
-		err = collection.FindOne(ctx, bson.M{
-			"user_id": userID,
-			"name":    expense.Name,
-			"date":    expense.Date,
-		}).Decode(&existingExpense)
-		if err == nil {
-			errors = append(errors, fmt.Sprintf("Line %d: Expense with same name and date existed", lineCount))
-			response.ErrorCount++
-			lineCount++
-			continue
+		expense.SearchText = BuildSearchText(expense.Name, expense.Description)
+		expense.Version = 1
+		expense.UpdatedAt = utils.Timestamp()
+		// Check if a matching expense already exists
+		var duplicate *Expense
+		if checkDuplicates {
+			duplicates, err := detector.findDuplicates(ctx, collection, userID, expense, skipImported)
+			if err != nil {
+				errors = append(errors, fmt.Sprintf("Line %d: Could not check for duplicates", lineCount))
+				response.ErrorCount++
+				lineCount++
+				continue
+			}
+			if len(duplicates) > 0 && !confirmedRepeat(duplicates, dismissed) {
+				duplicate = &duplicates[0]
+			}
+			if duplicate != nil && rejectDuplicates {
+				errors = append(errors, fmt.Sprintf("Line %d: Possible duplicate of expense %s (%s, %s)", lineCount, duplicate.ID, duplicate.Name, duplicate.Date))
+				response.ErrorCount++
+				lineCount++
+				continue
+			}
+		}
+
+		categorized := applyRules(rules, &expense, false) && expense.CategoryID != ""
+		if !categorized && model != nil {
+			if best, ok := model.Best(expense.Name, expense.Description, h.config.SuggestionMinConfidence); ok {
+				expense.CategoryID = best.CategoryID
+				categorized = true
+			}
 		}
 
 		// Insert expense
-		_, err = collection.InsertOne(ctx, expense)
+		result, err := collection.InsertOne(ctx, expense)
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("Line %d: Could not save expense", lineCount))
 			response.ErrorCount++
 		} else {
+			expense.ID = result.InsertedID.(primitive.ObjectID).Hex()
+			imported[expense.ID] = true
+			h.recordChange(ctx, c, audit.ActionCreate, expense.ID, nil, &expense)
 			response.SuccessCount++
+			if duplicate != nil {
+				response.Duplicates = append(response.Duplicates, CSVDuplicate{Line: lineCount, ExpenseID: expense.ID, DuplicateOfID: duplicate.ID})
+			}
+			if categorized {
+				response.CategorizedCount++
+			}
 		}
 
 		lineCount++
 	}
 
 	response.Errors = errors
+	h.bus.Publish(event.Event{
+		Type:       event.TypeImportCompleted,
+		UserID:     userID,
+		EntityType: event.EntityImport,
+		Data:       response,
+	})
 	c.JSON(http.StatusOK, response)
 }
 
-// HandleDownloadCSV handles the download of all expenses in CSV format
+// csvExportColumns maps the column names accepted by the "columns" query
+// parameter of HandleDownloadCSV to the header and value of each column.
+var csvExportColumns = map[string]struct {
+	Header string
+	Value  func(expense Expense, categoryName string) string
+}{
+	"date": {"Date", func(expense Expense, _ string) string {
+		// Format date from YYYY-MM-DD to MM/dd/YYYY
+		if date, err := time.Parse("2006-01-02", expense.Date); err == nil {
+			return date.Format("1/2/2006")
+		}
+		return expense.Date
+	}},
+	"name": {"Name", func(expense Expense, _ string) string { return expense.Name }},
+	"amount": {"Amount", func(expense Expense, _ string) string {
+		return fmt.Sprintf("%.2f", expense.Amount) // Keep original amount (already x1000)
+	}},
+	"currency_code": {"CurrencyCode", func(expense Expense, _ string) string { return expense.CurrencyCode }},
+	"description":   {"Description", func(expense Expense, _ string) string { return expense.Description }},
+	"category_id":   {"CategoryID", func(expense Expense, _ string) string { return expense.CategoryID }},
+	"category":      {"Category", func(_ Expense, categoryName string) string { return categoryName }},
+}
+
+// defaultCSVExportColumns is the column order used when no "columns" query parameter is given
+var defaultCSVExportColumns = []string{"date", "name", "amount", "currency_code", "description", "category_id", "category"}
+
+// csvExportFlushEvery is the number of rows written before the CSV writer is flushed to the client
+const csvExportFlushEvery = 500
+
+// HandleDownloadCSV streams the user's expenses in CSV format.
+// It accepts the filters of parseExpenseFilter and an optional columns
+// parameter (comma separated, see csvExportColumns).
 func (h *Handler) HandleDownloadCSV(c *gin.Context) {
 	userID := c.GetString("user_id")
 	if userID == "" {
@@ -639,100 +1056,324 @@ func (h *Handler) HandleDownloadCSV(c *gin.Context) {
 		return
 	}
 
-	// Get all expenses for the user
+	// Build filter
+	filter, err := parseExpenseFilter(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Resolve the selected columns
+	columns := defaultCSVExportColumns
+	if columnsParam := c.Query("columns"); columnsParam != "" {
+		columns = splitQueryList(columnsParam)
+		for _, column := range columns {
+			if _, ok := csvExportColumns[column]; !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown column: " + column})
+				return
+			}
+		}
+	}
+
 	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
+	// Resolve all category names with a single batched lookup
+	categoryMap, err := h.loadCategoryNames(ctx, collection, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch categories"})
+		return
+	}
+
 	// Sort by date
 	findOptions := options.Find().SetSort(bson.D{{Key: "date", Value: 1}})
-	cursor, err := collection.Find(ctx, bson.M{"user_id": userID}, findOptions)
+	cursor, err := collection.Find(ctx, filter, findOptions)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expenses"})
 		return
 	}
 	defer cursor.Close(ctx)
 
-	var expenses []Expense
-	if err = cursor.All(ctx, &expenses); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode expenses"})
+	// Set headers for file download
+	currentTime := time.Now().Format("20060102_150405")
+	filename := fmt.Sprintf("expenses_%s.csv", currentTime)
+
+	c.Header("Content-Description", "File Transfer")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Transfer-Encoding", "binary")
+	c.Header("Expires", "0")
+	c.Header("Cache-Control", "must-revalidate")
+	c.Header("Pragma", "public")
+	c.Status(http.StatusOK)
+
+	// Write UTF-8 BOM
+	c.Writer.Write([]byte{0xEF, 0xBB, 0xBF})
+
+	writer := csv.NewWriter(c.Writer)
+
+	// Write header
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = csvExportColumns[column].Header
+	}
+	if err := writer.Write(header); err != nil {
+		log.Printf("Could not write CSV header: %v", err)
 		return
 	}
 
-	// Create a map of category IDs to names
-	categoryCollection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
+	// Stream rows straight from the cursor
+	rowCount := 0
+	row := make([]string, len(columns))
+	for cursor.Next(ctx) {
+		var expense Expense
+		if err := cursor.Decode(&expense); err != nil {
+			log.Printf("Could not decode expense during CSV export: %v", err)
+			return
+		}
+
+		categoryName := categoryMap[expense.CategoryID]
+		for i, column := range columns {
+			row[i] = csvExportColumns[column].Value(expense, categoryName)
+		}
+
+		if err := writer.Write(row); err != nil {
+			log.Printf("Could not write CSV row: %v", err)
+			return
+		}
+
+		rowCount++
+		if rowCount%csvExportFlushEvery == 0 {
+			writer.Flush()
+			c.Writer.Flush()
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		log.Printf("Cursor error during CSV export: %v", err)
+	}
+
+	writer.Flush()
+	c.Writer.Flush()
+}
+
+// loadCategoryNames returns a map of category ID to category name for every
+// category referenced by the expenses matching filter.
+func (h *Handler) loadCategoryNames(ctx context.Context, expenses *mongo.Collection, filter bson.M) (map[string]string, error) {
 	categoryMap := make(map[string]string)
 
-	for _, expense := range expenses {
-		if expense.CategoryID != "" {
-			if _, exists := categoryMap[expense.CategoryID]; !exists {
-				var cat category.Category
-				err := categoryCollection.FindOne(ctx, bson.M{"_id": expense.CategoryID}).Decode(&cat)
-				if err == nil {
-					categoryMap[expense.CategoryID] = cat.Name
-				}
-			}
+	categoryIDs, err := expenses.Distinct(ctx, "category_id", filter)
+	if err != nil {
+		return nil, err
+	}
+
+	objectIDs := make([]primitive.ObjectID, 0, len(categoryIDs))
+	for _, id := range categoryIDs {
+		idString, ok := id.(string)
+		if !ok || idString == "" {
+			continue
+		}
+		objectID, err := utils.StringToObjectId(idString)
+		if err != nil {
+			continue
 		}
+		objectIDs = append(objectIDs, objectID)
+	}
+	if len(objectIDs) == 0 {
+		return categoryMap, nil
 	}
 
-	// Create CSV buffer with UTF-8 BOM
-	buf := new(bytes.Buffer)
+	categoryCollection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
+	cursor, err := categoryCollection.Find(ctx, bson.M{"_id": bson.M{"$in": objectIDs}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
 
-	// Write UTF-8 BOM
-	buf.Write([]byte{0xEF, 0xBB, 0xBF})
+	var categories []category.Category
+	if err = cursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	for _, cat := range categories {
+		categoryMap[cat.ID] = cat.Name
+	}
+	return categoryMap, nil
+}
 
-	writer := csv.NewWriter(buf)
+// splitQueryList splits a comma separated query parameter, dropping empty entries
+func splitQueryList(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
 
-	// Write header
-	header := []string{"Date", "Name", "Amount", "CurrencyCode", "Description", "CategoryID", "Category"}
-	if err := writer.Write(header); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not write CSV header"})
+// applyRules runs the categorization rules against expense and copies the result back.
+// It returns true if a rule matched.
+func applyRules(rules []rule.Rule, expense *Expense, overwriteCategory bool) bool {
+	target := rule.Target{
+		Name:         expense.Name,
+		Description:  expense.Description,
+		Amount:       expense.Amount,
+		CurrencyCode: expense.CurrencyCode,
+		CategoryID:   expense.CategoryID,
+		TagIDs:       expense.TagIDs,
+	}
+	if rule.Apply(rules, &target, overwriteCategory) == nil {
+		return false
+	}
+	expense.CategoryID = target.CategoryID
+	expense.TagIDs = target.TagIDs
+	return true
+}
+
+// HandleApplyRules re-runs the categorization rules over the user's expense history.
+// By default rules fill in missing categories and add tags; pass overwrite=true to let
+// them replace existing categories as well. Changes are written in batches and recorded
+// in the audit trail once their batch is written. Expenses edited or reconciled in the
+// meantime are skipped.
+func (h *Handler) HandleApplyRules(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
 		return
 	}
+	overwrite := c.Query("overwrite") == "true"
 
-	// Write data
-	for _, expense := range expenses {
-		// Get category name if exists
-		categoryName := ""
-		if expense.CategoryID != "" {
-			categoryName = categoryMap[expense.CategoryID]
-		}
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
 
-		// Format date from YYYY-MM-DD to MM/dd/YYYY
-		date, err := time.Parse("2006-01-02", expense.Date)
-		if err == nil {
-			expense.Date = date.Format("1/2/2006")
-		}
+	rules, err := rule.LoadRules(ctx, h.mongoClient, h.config, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load categorization rules"})
+		return
+	}
+
+	var response ApplyRulesResponse
+	if len(rules) == 0 {
+		c.JSON(http.StatusOK, response)
+		return
+	}
 
-		row := []string{
-			expense.Date,
-			expense.Name,
-			fmt.Sprintf("%.2f", expense.Amount), // Keep original amount (already x1000)
-			expense.CurrencyCode,
-			expense.Description,
-			expense.CategoryID,
-			categoryName,
+	cursor, err := collection.Find(ctx, utils.NotDeleted(bson.M{"user_id": userID}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expenses"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	const batchSize = 500
+	models := make([]mongo.WriteModel, 0, batchSize)
+	// changes holds the expenses of the current batch, recorded once it is written
+	type change struct{ before, after Expense }
+	changes := make([]change, 0, batchSize)
+	flush := func() error {
+		if len(models) == 0 {
+			return nil
+		}
+		result, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false))
+		if err != nil {
+			return err
+		}
+		response.UpdatedCount += result.ModifiedCount
+		response.SkippedCount += int64(len(models)) - result.MatchedCount
+
+		written := changes
+		if result.MatchedCount < int64(len(models)) {
+			// Find out which updates were applied: they left their version and timestamp
+			ids := make(bson.A, 0, len(changes))
+			for i := range changes {
+				objectId, _ := utils.StringToObjectId(changes[i].after.ID)
+				ids = append(ids, objectId)
+			}
+			appliedCursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": ids}},
+				options.Find().SetProjection(bson.M{"version": 1, "updated_at": 1}))
+			if err != nil {
+				return err
+			}
+			var current []Expense
+			if err = appliedCursor.All(ctx, &current); err != nil {
+				return err
+			}
+			applied := make(map[string]bool, len(current))
+			for _, item := range current {
+				applied[item.ID+"@"+item.UpdatedAt+"@"+strconv.FormatInt(item.Version, 10)] = true
+			}
+			written = nil
+			for _, pending := range changes {
+				if applied[pending.after.ID+"@"+pending.after.UpdatedAt+"@"+strconv.FormatInt(pending.after.Version, 10)] {
+					written = append(written, pending)
+				}
+			}
 		}
+		for i := range written {
+			h.recordChange(ctx, c, audit.ActionUpdate, written[i].after.ID, &written[i].before, &written[i].after)
+		}
+		models = models[:0]
+		changes = changes[:0]
+		return nil
+	}
 
-		if err := writer.Write(row); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not write CSV row"})
+	for cursor.Next(ctx) {
+		var expense Expense
+		if err := cursor.Decode(&expense); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode expenses"})
 			return
 		}
-	}
+		response.ScannedCount++
 
-	writer.Flush()
+		before := expense
+		before.TagIDs = append([]string(nil), expense.TagIDs...)
+		previousCategory := expense.CategoryID
+		previousTagCount := len(expense.TagIDs)
+		if !applyRules(rules, &expense, overwrite) {
+			continue
+		}
+		response.MatchedCount++
 
-	// Set headers for file download
-	currentTime := time.Now().Format("20060102_150405")
-	filename := fmt.Sprintf("expenses_%s.csv", currentTime)
+		if expense.CategoryID == previousCategory && len(expense.TagIDs) == previousTagCount {
+			continue
+		}
 
-	c.Header("Content-Description", "File Transfer")
-	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	c.Header("Content-Type", "text/csv; charset=utf-8")
-	c.Header("Content-Transfer-Encoding", "binary")
-	c.Header("Expires", "0")
-	c.Header("Cache-Control", "must-revalidate")
-	c.Header("Pragma", "public")
+		objectId, err := utils.StringToObjectId(expense.ID)
+		if err != nil {
+			continue
+		}
+		// The update only applies to the version read, and never to a reconciled expense
+		filter := utils.MatchVersion(bson.M{
+			"_id":               objectId,
+			"user_id":           userID,
+			"reconciliation_id": bson.M{"$exists": false},
+		}, expense.Version)
+		update := utils.Touch(bson.M{"$set": bson.M{
+			"category_id": expense.CategoryID,
+			"tag_ids":     expense.TagIDs,
+		}})
+		models = append(models, mongo.NewUpdateOneModel().SetFilter(filter).SetUpdate(update))
+		expense.Version++
+		expense.UpdatedAt = update["$set"].(bson.M)[utils.UpdatedAtField].(string)
+		changes = append(changes, change{before: before, after: expense})
+
+		if len(models) >= batchSize {
+			if err := flush(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update expenses"})
+				return
+			}
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expenses"})
+		return
+	}
+	if err := flush(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update expenses"})
+		return
+	}
 
-	c.Data(http.StatusOK, "text/csv", buf.Bytes())
+	c.JSON(http.StatusOK, response)
 }
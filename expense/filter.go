@@ -0,0 +1,191 @@
+package expense
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"my-finance-backend/utils"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// parseExpenseFilter builds a Mongo filter for the user's expenses from the common query parameters:
+//
+//	from, to            date range, YYYY-MM-DD, inclusive
+//	amount_min, amount_max
+//	category_id         comma separated category IDs, also matching line item categories
+//	uncategorized       "true" to only match expenses with spending without a category
+//	tag_id              comma separated tag IDs, matching expenses or line items carrying any of them
+//	currency_code       comma separated currency codes
+func parseExpenseFilter(c *gin.Context, userID string) (bson.M, error) {
+	filter := utils.NotDeleted(bson.M{"user_id": userID})
+
+	dateFilter := bson.M{}
+	if from := c.Query("from"); from != "" {
+		if _, err := time.Parse("2006-01-02", from); err != nil {
+			return nil, errors.New("Invalid from parameter, expected YYYY-MM-DD")
+		}
+		dateFilter["$gte"] = from
+	}
+	if to := c.Query("to"); to != "" {
+		if _, err := time.Parse("2006-01-02", to); err != nil {
+			return nil, errors.New("Invalid to parameter, expected YYYY-MM-DD")
+		}
+		dateFilter["$lte"] = to
+	}
+	if len(dateFilter) > 0 {
+		filter["date"] = dateFilter
+	}
+
+	amountFilter := bson.M{}
+	if amountMin := c.Query("amount_min"); amountMin != "" {
+		value, err := strconv.ParseFloat(amountMin, 64)
+		if err != nil {
+			return nil, errors.New("Invalid amount_min parameter")
+		}
+		amountFilter["$gte"] = value
+	}
+	if amountMax := c.Query("amount_max"); amountMax != "" {
+		value, err := strconv.ParseFloat(amountMax, 64)
+		if err != nil {
+			return nil, errors.New("Invalid amount_max parameter")
+		}
+		amountFilter["$lte"] = value
+	}
+	if len(amountFilter) > 0 {
+		filter["amount"] = amountFilter
+	}
+
+	// Conditions needing $or are combined with $and, which leaves $or to cursor pagination
+	var conditions bson.A
+	if c.Query("uncategorized") == "true" {
+		if c.Query("category_id") != "" {
+			return nil, errors.New("category_id and uncategorized cannot be combined")
+		}
+		conditions = append(conditions, uncategorizedCondition())
+	} else if categoryParam := c.Query("category_id"); categoryParam != "" {
+		conditions = append(conditions, categoryCondition(splitQueryList(categoryParam)))
+	}
+	if tagParam := c.Query("tag_id"); tagParam != "" {
+		tagIDs := splitQueryList(tagParam)
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"tag_ids": bson.M{"$in": tagIDs}},
+			bson.M{"line_items.tag_ids": bson.M{"$in": tagIDs}},
+		}})
+	}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
+	}
+	if currencyParam := c.Query("currency_code"); currencyParam != "" {
+		filter["currency_code"] = bson.M{"$in": splitQueryList(currencyParam)}
+	}
+
+	return filter, nil
+}
+
+// expenseSortFields maps the values accepted by the "sort" query parameter to document fields
+var expenseSortFields = map[string]string{
+	"date":     "date",
+	"amount":   "amount",
+	"name":     "name",
+	"currency": "currency_code",
+}
+
+// expenseSort is the ordering of an expense listing. The _id is always used as a
+// tie breaker so that cursor pagination is stable.
+type expenseSort struct {
+	Field     string
+	Direction int
+}
+
+// parseExpenseSort reads the sort (see expenseSortFields) and order (asc or desc) query parameters.
+// The default is date descending.
+func parseExpenseSort(c *gin.Context) (expenseSort, error) {
+	sort := expenseSort{Field: "date", Direction: -1}
+	if sortParam := c.Query("sort"); sortParam != "" {
+		field, ok := expenseSortFields[sortParam]
+		if !ok {
+			return sort, errors.New("Invalid sort parameter")
+		}
+		sort.Field = field
+	}
+	switch c.Query("order") {
+	case "":
+	case "asc":
+		sort.Direction = 1
+	case "desc":
+		sort.Direction = -1
+	default:
+		return sort, errors.New("Invalid order parameter, expected asc or desc")
+	}
+	return sort, nil
+}
+
+// Document returns the Mongo sort document
+func (s expenseSort) Document() bson.D {
+	return bson.D{{Key: s.Field, Value: s.Direction}, {Key: "_id", Value: s.Direction}}
+}
+
+// pageCursor is the position after the last expense of a page. It is handed to clients
+// as an opaque base64 string.
+type pageCursor struct {
+	Field     string      `json:"f"`
+	Direction int         `json:"d"`
+	Value     interface{} `json:"v"`
+	ID        string      `json:"id"`
+}
+
+// encodePageCursor returns the cursor pointing after expense for the given sort
+func encodePageCursor(sort expenseSort, expense Expense) string {
+	var value interface{}
+	switch sort.Field {
+	case "amount":
+		value = expense.Amount
+	case "name":
+		value = expense.Name
+	case "currency_code":
+		value = expense.CurrencyCode
+	default:
+		value = expense.Date
+	}
+
+	data, _ := json.Marshal(pageCursor{
+		Field:     sort.Field,
+		Direction: sort.Direction,
+		Value:     value,
+		ID:        expense.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// applyPageCursor restricts filter to the expenses following the cursor
+func applyPageCursor(filter bson.M, sort expenseSort, encoded string) error {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return errors.New("Invalid cursor")
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return errors.New("Invalid cursor")
+	}
+	if cursor.Field != sort.Field || cursor.Direction != sort.Direction {
+		return errors.New("Cursor does not match the requested sort order")
+	}
+	objectId, err := utils.StringToObjectId(cursor.ID)
+	if err != nil {
+		return errors.New("Invalid cursor")
+	}
+
+	operator := "$lt"
+	if sort.Direction > 0 {
+		operator = "$gt"
+	}
+	filter["$or"] = bson.A{
+		bson.M{sort.Field: bson.M{operator: cursor.Value}},
+		bson.M{sort.Field: cursor.Value, "_id": bson.M{operator: objectId}},
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package expense
+
+import (
+	"context"
+	"my-finance-backend/account"
+	"my-finance-backend/audit"
+	"my-finance-backend/event"
+	"my-finance-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// recordChange writes an audit entry for a change to an expense and notifies the
+// owner's connected clients
+func (h *Handler) recordChange(ctx context.Context, c *gin.Context, action string, expenseID string, before *Expense, after *Expense) {
+	audit.Record(ctx, h.mongoClient, h.config, c, audit.EntityExpense, expenseID, action, before, after)
+	h.bus.Publish(changeEvent(action, expenseID, before, after))
+}
+
+// changeEvent returns the event announcing a change to an expense. Changes made inside a
+// transaction are announced only once it commits.
+func changeEvent(action string, expenseID string, before *Expense, after *Expense) event.Event {
+	current := after
+	if current == nil {
+		current = before
+	}
+	return event.Event{
+		Type:       event.ChangeType(audit.EntityExpense, action),
+		UserID:     current.UserID,
+		SharedWith: splitMembers(before, after),
+		EntityType: audit.EntityExpense,
+		EntityID:   expenseID,
+		Version:    current.Version,
+		Data:       current,
+	}
+}
+
+// splitMembers returns the participants who accepted a share of the expense before or
+// after the change, other than its owner: the household members who see it
+func splitMembers(states ...*Expense) []string {
+	var members []string
+	for _, state := range states {
+		if state == nil || state.Split == nil {
+			continue
+		}
+		for _, share := range state.Split.Shares {
+			if !share.Pending && share.UserID != "" && share.UserID != state.UserID {
+				members = append(members, share.UserID)
+			}
+		}
+	}
+	return members
+}
+
+// HandleRevertExpense restores an expense to the state it had right after the given
+// history entry. Reverting to a deleted state moves the expense to the trash. The old
+// version is checked like an update: its category, line items and account must still be
+// valid, and split participants must accept their shares again.
+func (h *Handler) HandleRevertExpense(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+	expenseID := c.Param("id")
+
+	objectId, error := utils.StringToObjectId(expenseID)
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expense ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entry, err := audit.FindEntry(ctx, h.mongoClient, h.config, userID, audit.EntityExpense, expenseID, c.Param("entry_id"))
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "History entry not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch history entry"})
+		return
+	}
+	if entry.After == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "History entry has no version to revert to"})
+		return
+	}
+
+	var before Expense
+	err = collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&before)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Expense not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expense"})
+		return
+	}
+
+	expectedVersion, checkVersion, err := utils.IfMatchVersion(c, expenseID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if checkVersion && before.Version != expectedVersion {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Expense was modified by someone else"})
+		return
+	}
+	if before.ReconciliationID != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": ErrReconciled.Error()})
+		return
+	}
+
+	// Rebuild the expense from the snapshot
+	data, err := bson.Marshal(entry.After)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read history entry"})
+		return
+	}
+	var reverted Expense
+	if err := bson.Unmarshal(data, &reverted); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read history entry"})
+		return
+	}
+	reverted.ID = ""
+	reverted.UserID = userID
+	reverted.SearchText = BuildSearchText(reverted.Name, reverted.Description)
+	// Reconciliation is not part of the expense history
+	reverted.ReconciliationID = ""
+	reverted.Version = before.Version + 1
+	reverted.UpdatedAt = utils.Timestamp()
+
+	// The version is written back as a new edit, so it must pass the checks of HandleUpdateExpense
+	if reverted.CategoryID != "" {
+		if err := h.checkCategory(ctx, userID, reverted.CategoryID); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Cannot revert: " + err.Error()})
+			return
+		}
+	}
+	if len(reverted.LineItems) > 0 {
+		if err := ValidateLineItems(ctx, h.mongoClient, h.config, userID, reverted.LineItems, reverted.Amount); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Cannot revert: " + err.Error()})
+			return
+		}
+	}
+	if reverted.AccountID != "" {
+		if err := account.Check(ctx, h.mongoClient, h.config, userID, reverted.AccountID, reverted.CurrencyCode); err != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "Cannot revert: " + err.Error()})
+			return
+		}
+	}
+	if reverted.Split != nil {
+		// Participants agree to the split again rather than to an old version of it
+		reverted.Split.requireAcceptance(userID)
+	}
+
+	result, err := collection.ReplaceOne(ctx, utils.MatchVersion(bson.M{"_id": objectId, "user_id": userID}, before.Version), reverted)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not revert expense"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Expense was modified by someone else"})
+		return
+	}
+
+	reverted.ID = expenseID
+	h.recordChange(ctx, c, audit.ActionRevert, expenseID, &before, &reverted)
+
+	c.Header("ETag", utils.VersionETag(expenseID, reverted.Version))
+	c.JSON(http.StatusOK, reverted)
+}
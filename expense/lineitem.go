@@ -0,0 +1,127 @@
+package expense
+
+import (
+	"context"
+	"errors"
+	"math"
+	"my-finance-backend/config"
+	"my-finance-backend/utils"
+	"sort"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// lineItemTolerance is how far the line items may add up from the expense amount
+const lineItemTolerance = 0.01
+
+// CategoryAmounts splits the amount of the expense by category. Line items count under
+// their own category, falling back to the expense's; "" is uncategorized. Every
+// aggregation by category goes through it.
+func (e Expense) CategoryAmounts() map[string]float64 {
+	amounts := make(map[string]float64)
+	if len(e.LineItems) == 0 {
+		amounts[e.CategoryID] = e.Amount
+		return amounts
+	}
+	for _, item := range e.LineItems {
+		categoryID := item.CategoryID
+		if categoryID == "" {
+			categoryID = e.CategoryID
+		}
+		amounts[categoryID] += item.Amount
+	}
+	return amounts
+}
+
+// categoryTotals sums the category amounts of expenses, largest first
+func categoryTotals(expenses []Expense) []CategoryTotal {
+	sums := make(map[string]float64)
+	for _, expense := range expenses {
+		for categoryID, amount := range expense.CategoryAmounts() {
+			sums[categoryID] += amount
+		}
+	}
+
+	totals := make([]CategoryTotal, 0, len(sums))
+	for categoryID, amount := range sums {
+		totals = append(totals, CategoryTotal{CategoryID: categoryID, Amount: utils.RoundAmount(amount)})
+	}
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].Amount != totals[j].Amount {
+			return totals[i].Amount > totals[j].Amount
+		}
+		return totals[i].CategoryID < totals[j].CategoryID
+	})
+	return totals
+}
+
+// ValidateLineItems checks that line items have an amount, use existing categories of
+// the user and add up to the expense amount. Every path writing line items goes through it.
+func ValidateLineItems(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string, items []LineItem, amount float64) error {
+	total := 0.0
+	categoryIDs := make(map[string]bool)
+	for _, item := range items {
+		if item.Amount == 0 {
+			return errors.New("line item amounts must not be zero")
+		}
+		total += item.Amount
+		if item.CategoryID != "" {
+			categoryIDs[item.CategoryID] = true
+		}
+	}
+	if math.Abs(total-amount) > lineItemTolerance {
+		return errors.New("line items must add up to the expense amount")
+	}
+
+	if len(categoryIDs) == 0 {
+		return nil
+	}
+	objectIds := make(bson.A, 0, len(categoryIDs))
+	for categoryID := range categoryIDs {
+		objectId, err := utils.StringToObjectId(categoryID)
+		if err != nil {
+			return errors.New("Invalid line item category ID")
+		}
+		objectIds = append(objectIds, objectId)
+	}
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionCategoriesName)
+	count, err := collection.CountDocuments(ctx, utils.NotDeleted(bson.M{"_id": bson.M{"$in": objectIds}, "user_id": userID}))
+	if err != nil {
+		return err
+	}
+	if count != int64(len(objectIds)) {
+		return errors.New("Line item category not found")
+	}
+	return nil
+}
+
+// categoryCondition matches expenses with spending in one of the categories, whether
+// through their own category or a line item's
+func categoryCondition(categoryIDs []string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{"line_items.category_id": bson.M{"$in": categoryIDs}},
+		bson.M{
+			"category_id": bson.M{"$in": categoryIDs},
+			"$or":         uncategorizedLineItems(),
+		},
+	}}
+}
+
+// uncategorizedCondition matches expenses with spending without a category
+func uncategorizedCondition() bson.M {
+	return bson.M{
+		// $in with null also matches documents without the field
+		"category_id": bson.M{"$in": bson.A{nil, ""}},
+		"$or":         uncategorizedLineItems(),
+	}
+}
+
+// uncategorizedLineItems are the conditions of which one holds when some of the expense
+// amount falls under the expense's own category: no line items, or one without a category
+func uncategorizedLineItems() bson.A {
+	return bson.A{
+		bson.M{"line_items": bson.M{"$exists": false}},
+		bson.M{"line_items": bson.M{"$elemMatch": bson.M{"category_id": bson.M{"$in": bson.A{nil, ""}}}}},
+	}
+}
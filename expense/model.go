@@ -1,32 +1,51 @@
 package expense
 
 type Expense struct {
-	ID           string  `bson:"_id,omitempty"  json:"id,omitempty"`
-	UserID       string  `bson:"user_id" json:"user_id"`
-	CategoryID   string  `bson:"category_id,omitempty" json:"category_id,omitempty"`
-	Amount       float64 `bson:"amount" json:"amount"`
-	CurrencyCode string  `bson:"currency_code" json:"currency_code"`
-	Name         string  `bson:"name" json:"name"`
-	Description  string  `bson:"description" json:"description"`
-	Date         string  `bson:"date" json:"date"`
+	ID           string     `bson:"_id,omitempty"  json:"id,omitempty"`
+	UserID       string     `bson:"user_id" json:"user_id"`
+	CategoryID   string     `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	AccountID    string     `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	Amount       float64    `bson:"amount" json:"amount"`
+	CurrencyCode string     `bson:"currency_code" json:"currency_code"`
+	Name         string     `bson:"name" json:"name"`
+	Description  string     `bson:"description" json:"description"`
+	Date         string     `bson:"date" json:"date"`
+	TagIDs       []string   `bson:"tag_ids,omitempty" json:"tag_ids,omitempty"`
+	SearchText   string     `bson:"search_text,omitempty" json:"-"` // normalized name and description, see BuildSearchText
+	DeletedAt    string     `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	Version      int64      `bson:"version" json:"version"` // incremented on every write, exposed as ETag
+	UpdatedAt    string     `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	Split        *Split     `bson:"split,omitempty" json:"split,omitempty"`
+	LineItems    []LineItem `bson:"line_items,omitempty" json:"line_items,omitempty"` // when present, they add up to Amount
+	// ReconciliationID is set once the expense is matched against an account statement,
+	// after which it can no longer be changed
+	ReconciliationID string `bson:"reconciliation_id,omitempty" json:"reconciliation_id,omitempty"`
 }
 
 type CreateExpenseRequest struct {
-	Amount       float64 `json:"amount" binding:"required"`
-	CategoryID   string  `json:"category_id,omitempty"`
-	CurrencyCode string  `json:"currency_code" binding:"required"`
-	Name         string  `json:"name" binding:"required"`
-	Description  string  `json:"description"`
-	Date         string  `json:"date"`
+	Amount       float64       `json:"amount" binding:"required"`
+	CategoryID   string        `json:"category_id,omitempty"`
+	CurrencyCode string        `json:"currency_code" binding:"required"`
+	Name         string        `json:"name" binding:"required"`
+	Description  string        `json:"description"`
+	Date         string        `json:"date"`
+	TagIDs       []string      `json:"tag_ids,omitempty"`
+	Split        *SplitRequest `json:"split,omitempty"`
+	LineItems    []LineItem    `json:"line_items,omitempty"`
+	AccountID    string        `json:"account_id,omitempty"`
 }
 
 type UpdateExpenseRequest struct {
-	Amount       float64 `json:"amount"`
-	CurrencyCode string  `json:"currency_code"`
-	Name         string  `json:"name"`
-	Description  string  `json:"description"`
-	CategoryID   string  `bson:"category_id,omitempty" json:"category_id,omitempty"`
-	Date         string  `json:"date"`
+	Amount       float64       `json:"amount"`
+	CurrencyCode string        `json:"currency_code"`
+	Name         string        `json:"name"`
+	Description  string        `json:"description"`
+	CategoryID   string        `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	Date         string        `json:"date"`
+	TagIDs       []string      `json:"tag_ids,omitempty"`
+	Split        *SplitRequest `json:"split,omitempty"` // an empty participant list removes the split
+	LineItems    []LineItem    `json:"line_items"`      // an empty list removes the line items
+	AccountID    *string       `json:"account_id"`      // an empty string unlinks the account
 }
 
 // PaginatedExpenseResponse represents the paginated response for expenses
@@ -36,6 +55,7 @@ type PaginatedExpenseResponse struct {
 	CurrentPage int       `json:"current_page"`
 	TotalPages  int       `json:"total_pages"`
 	Limit       int       `json:"limit"`
+	NextCursor  string    `json:"next_cursor,omitempty"` // set when more expenses follow, see HandleGetExpenses
 }
 
 type GetLastExpensesResponse struct {
@@ -45,14 +65,42 @@ type GetLastExpensesResponse struct {
 
 // PaginatedExpenseResponse represents the paginated response for expenses
 type GetMontlyExpensesResponse struct {
-	Expenses    []Expense `json:"expenses"`
-	TotalAmount int64     `json:"total_amount"`
+	Expenses    []Expense       `json:"expenses"`
+	TotalAmount int64           `json:"total_amount"`
+	Categories  []CategoryTotal `json:"categories"`
+}
+
+// CategoryTotal is the amount spent in a category, see Expense.CategoryAmounts.
+// An empty CategoryID stands for uncategorized spending.
+type CategoryTotal struct {
+	CategoryID string  `json:"category_id"`
+	Amount     float64 `json:"amount"`
 }
 
 type CSVUploadResponse struct {
-	SuccessCount int      `json:"success_count"`
-	ErrorCount   int      `json:"error_count"`
-	Errors       []string `json:"errors,omitempty"`
+	SuccessCount     int      `json:"success_count"`
+	ErrorCount       int      `json:"error_count"`
+	CategorizedCount int      `json:"categorized_count"`
+	Errors           []string `json:"errors,omitempty"`
+	// Duplicates lists the imported rows that look like duplicates of stored expenses
+	Duplicates []CSVDuplicate `json:"duplicates,omitempty"`
+}
+
+// CSVDuplicate is an imported row suspected to duplicate a stored expense. Once checked,
+// the pair can be dismissed like the groups of GetDuplicatesResponse.
+type CSVDuplicate struct {
+	Line          int    `json:"line"`
+	ExpenseID     string `json:"expense_id"`
+	DuplicateOfID string `json:"duplicate_of_id"`
+}
+
+// ApplyRulesResponse reports the outcome of re-running the categorization rules over the expense history
+type ApplyRulesResponse struct {
+	ScannedCount int64 `json:"scanned_count"`
+	MatchedCount int64 `json:"matched_count"`
+	UpdatedCount int64 `json:"updated_count"`
+	// SkippedCount counts expenses edited or reconciled while the rules were running, left as they were
+	SkippedCount int64 `json:"skipped_count"`
 }
 
 type CSVExpense struct {
@@ -71,3 +119,124 @@ type CSVExportExpense struct {
 	CategoryID   string
 	CategoryName string
 }
+
+// DuplicateGroup is a set of expenses suspected to be duplicates of each other
+type DuplicateGroup struct {
+	Expenses []Expense `json:"expenses"`
+}
+
+type GetDuplicatesResponse struct {
+	Groups []DuplicateGroup `json:"groups"`
+}
+
+// DismissDuplicatesRequest marks every pair of the given expenses as "not duplicates"
+type DismissDuplicatesRequest struct {
+	ExpenseIDs []string `json:"expense_ids" binding:"required"`
+}
+
+// DuplicateDismissal records that two expenses were confirmed not to be duplicates
+type DuplicateDismissal struct {
+	ID        string `bson:"_id,omitempty" json:"id"`
+	UserID    string `bson:"user_id" json:"user_id"`
+	PairKey   string `bson:"pair_key" json:"pair_key"`
+	CreatedAt string `bson:"created_at" json:"created_at"`
+}
+
+// SearchResult is an expense matched by HandleSearchExpenses with its relevance score
+type SearchResult struct {
+	Expense `bson:",inline"`
+	Score   float64 `bson:"score" json:"score"`
+}
+
+type SearchExpensesResponse struct {
+	Results    []SearchResult `json:"results"`
+	TotalCount int64          `json:"total_count"`
+	Offset     int            `json:"offset"`
+	Limit      int            `json:"limit"`
+}
+
+const (
+	BulkActionRecategorize = "recategorize"
+	BulkActionRetag        = "retag"
+	BulkActionSetCurrency  = "set_currency"
+	BulkActionShiftDate    = "shift_date"
+	BulkActionDelete       = "delete"
+
+	BulkTagModeAdd     = "add"
+	BulkTagModeRemove  = "remove"
+	BulkTagModeReplace = "replace"
+)
+
+// BulkExpenseRequest applies one action to a list of expenses, or to every expense
+// matching the query filters when AllMatching is set
+type BulkExpenseRequest struct {
+	Action       string   `json:"action" binding:"required"`
+	ExpenseIDs   []string `json:"expense_ids"`
+	AllMatching  bool     `json:"all_matching"`
+	Atomic       bool     `json:"atomic"`        // all-or-nothing using a Mongo transaction
+	CategoryID   string   `json:"category_id"`   // recategorize, empty removes the category
+	TagIDs       []string `json:"tag_ids"`       // retag
+	TagMode      string   `json:"tag_mode"`      // retag: add, remove or replace
+	CurrencyCode string   `json:"currency_code"` // set_currency
+	Days         int      `json:"days"`          // shift_date, may be negative
+}
+
+type BulkItemResult struct {
+	ID     string `json:"id"`
+	Status string `json:"status"` // ok, error or rolled_back
+	Error  string `json:"error,omitempty"`
+}
+
+type BulkExpenseResponse struct {
+	SuccessCount int              `json:"success_count"`
+	ErrorCount   int              `json:"error_count"`
+	Results      []BulkItemResult `json:"results"`
+}
+
+const (
+	SplitEqual      = "equal"
+	SplitExact      = "exact"
+	SplitPercentage = "percentage"
+)
+
+// Split shares an expense paid by its owner among participants, usually including the
+// owner. Each participant other than the owner owes the owner the amount of their share
+// once they have accepted it.
+type Split struct {
+	Method string  `bson:"method" json:"method"` // equal, exact or percentage
+	Shares []Share `bson:"shares" json:"shares"`
+}
+
+// Share is the part of a split expense owed by one participant. A participant invited by
+// email has no user ID until a user with that email accepts the share.
+type Share struct {
+	UserID  string  `bson:"user_id,omitempty" json:"user_id,omitempty"`
+	Email   string  `bson:"email,omitempty" json:"email,omitempty"`
+	Amount  float64 `bson:"amount" json:"amount"`
+	Percent float64 `bson:"percent,omitempty" json:"percent,omitempty"`
+	// Pending shares are not owed until the participant accepts them
+	Pending bool `bson:"pending,omitempty" json:"pending,omitempty"`
+}
+
+type SplitRequest struct {
+	Method       string                  `json:"method"`
+	Participants []SplitParticipantInput `json:"participants"`
+}
+
+// SplitParticipantInput identifies a participant by user ID or email. Amount is used
+// by exact splits and Percent by percentage splits.
+type SplitParticipantInput struct {
+	UserID  string  `json:"user_id"`
+	Email   string  `json:"email"`
+	Amount  float64 `json:"amount"`
+	Percent float64 `json:"percent"`
+}
+
+// LineItem is a part of an expense with its own category and tags, such as one product
+// of a supermarket receipt. Without a category it falls under the expense's category.
+type LineItem struct {
+	Name       string   `bson:"name,omitempty" json:"name,omitempty"`
+	Amount     float64  `bson:"amount" json:"amount"`
+	CategoryID string   `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	TagIDs     []string `bson:"tag_ids,omitempty" json:"tag_ids,omitempty"`
+}
@@ -0,0 +1,148 @@
+package expense
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"my-finance-backend/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// BuildSearchText returns the lowercase, diacritic-free text the search index is built on,
+// so that "pho" matches "Phở" in both directions
+func BuildSearchText(name string, description string) string {
+	return strings.Join(utils.Tokenize(name+" "+description), " ")
+}
+
+// EnsureSearchIndex creates the text index used by HandleSearchExpenses and fills
+// search_text for expenses stored before the field existed
+func (h *Handler) EnsureSearchIndex(ctx context.Context) error {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+
+	// The "none" language disables stemming and stop words, which are meaningless for Vietnamese
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "search_text", Value: "text"}},
+		Options: options.Index().
+			SetName("search_text_index").
+			SetDefaultLanguage("none"),
+	})
+	if err != nil {
+		return err
+	}
+
+	cursor, err := collection.Find(ctx, bson.M{"search_text": bson.M{"$exists": false}},
+		options.Find().SetProjection(bson.M{"name": 1, "description": 1}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	models := make([]mongo.WriteModel, 0)
+	for cursor.Next(ctx) {
+		var expense struct {
+			ID          interface{} `bson:"_id"`
+			Name        string      `bson:"name"`
+			Description string      `bson:"description"`
+		}
+		if err := cursor.Decode(&expense); err != nil {
+			return err
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": expense.ID}).
+			SetUpdate(bson.M{"$set": bson.M{"search_text": BuildSearchText(expense.Name, expense.Description)}}))
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if len(models) > 0 {
+		if _, err := collection.BulkWrite(ctx, models, options.BulkWrite().SetOrdered(false)); err != nil {
+			return err
+		}
+		log.Printf("Backfilled search text for %d expenses\n", len(models))
+	}
+	return nil
+}
+
+// HandleSearchExpenses searches expense names and descriptions, most relevant first.
+// Besides q it accepts the filters of parseExpenseFilter and offset/limit pagination.
+func (h *Handler) HandleSearchExpenses(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	query := BuildSearchText(c.Query("q"), "")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q parameter is required"})
+		return
+	}
+
+	offset := 0
+	if offsetStr := c.Query("offset"); offsetStr != "" {
+		if _, err := fmt.Sscanf(offsetStr, "%d", &offset); err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset parameter"})
+			return
+		}
+	}
+
+	limit := 20 // default limit
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if _, err := fmt.Sscanf(limitStr, "%d", &limit); err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+			return
+		}
+	}
+
+	filter, err := parseExpenseFilter(c, userID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter["$text"] = bson.M{"$search": query}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	totalCount, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not count expenses"})
+		return
+	}
+
+	score := bson.M{"$meta": "textScore"}
+	findOptions := options.Find().
+		SetProjection(bson.M{"score": score}).
+		SetSort(bson.D{{Key: "score", Value: score}, {Key: "date", Value: -1}}).
+		SetSkip(int64(offset)).
+		SetLimit(int64(limit))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not search expenses"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var results []SearchResult = make([]SearchResult, 0)
+	if err = cursor.All(ctx, &results); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode expenses"})
+		return
+	}
+
+	c.JSON(http.StatusOK, SearchExpensesResponse{
+		Results:    results,
+		TotalCount: totalCount,
+		Offset:     offset,
+		Limit:      limit,
+	})
+}
@@ -0,0 +1,129 @@
+package expense
+
+import (
+	"context"
+	"errors"
+	"math"
+	"my-finance-backend/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// splitTolerance is how far exact shares may be from the expense amount, and
+// percentages from 100, to absorb rounding on the client
+const splitTolerance = 0.01
+
+// resolveSplit validates a split request for an expense of the given amount paid by
+// ownerID and returns the split with the share of every participant computed. Shares of
+// other participants are pending until they accept them. Participants given by email are
+// not looked up, so that the response does not tell whether the email is registered:
+// their share goes to the user with that email who accepts it.
+func (h *Handler) resolveSplit(ctx context.Context, ownerID string, req *SplitRequest, amount float64) (*Split, error) {
+	if len(req.Participants) == 0 {
+		return nil, nil
+	}
+
+	method := req.Method
+	if method == "" {
+		method = SplitEqual
+	}
+	if method != SplitEqual && method != SplitExact && method != SplitPercentage {
+		return nil, errors.New("split method must be equal, exact or percentage")
+	}
+
+	users := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionUserName)
+	split := &Split{Method: method, Shares: make([]Share, 0, len(req.Participants))}
+	seen := make(map[string]bool)
+	for _, participant := range req.Participants {
+		share := Share{UserID: participant.UserID, Amount: participant.Amount, Percent: participant.Percent}
+		key := "user:" + participant.UserID
+		if participant.UserID == "" {
+			if participant.Email == "" {
+				return nil, errors.New("split participants need a user_id or an email")
+			}
+			share.Email = participant.Email
+			key = "email:" + participant.Email
+		} else if participant.UserID != ownerID {
+			count, err := users.CountDocuments(ctx, bson.M{"_id": participant.UserID})
+			if err != nil {
+				return nil, err
+			}
+			if count == 0 {
+				return nil, errors.New("split participant not found: " + participant.UserID)
+			}
+		}
+		if seen[key] {
+			return nil, errors.New("split participants must be distinct")
+		}
+		seen[key] = true
+
+		split.Shares = append(split.Shares, share)
+	}
+
+	if err := computeShares(split, amount); err != nil {
+		return nil, err
+	}
+	split.requireAcceptance(ownerID)
+	return split, nil
+}
+
+// WithAmount returns a copy of the split for an expense of ownerID whose amount changed
+// to amount. Shares are computed again and the other participants must accept them again.
+func (split Split) WithAmount(amount float64, ownerID string) (Split, error) {
+	split.Shares = append([]Share(nil), split.Shares...)
+	if err := computeShares(&split, amount); err != nil {
+		return Split{}, err
+	}
+	split.requireAcceptance(ownerID)
+	return split, nil
+}
+
+// requireAcceptance marks the shares of every participant other than ownerID as pending,
+// for splits whose terms changed
+func (split *Split) requireAcceptance(ownerID string) {
+	for i := range split.Shares {
+		split.Shares[i].Pending = split.Shares[i].UserID != ownerID
+	}
+}
+
+// computeShares fills in the share amounts of equal and percentage splits and checks that
+// the shares add up to the expense amount. Rounding differences go to the first share.
+func computeShares(split *Split, amount float64) error {
+	total := 0.0
+	switch split.Method {
+	case SplitEqual:
+		each := utils.RoundAmount(amount / float64(len(split.Shares)))
+		for i := range split.Shares {
+			split.Shares[i].Amount = each
+			split.Shares[i].Percent = 0
+			total += each
+		}
+	case SplitPercentage:
+		percent := 0.0
+		for i := range split.Shares {
+			if split.Shares[i].Percent <= 0 {
+				return errors.New("split percentages must be positive")
+			}
+			percent += split.Shares[i].Percent
+			split.Shares[i].Amount = utils.RoundAmount(amount * split.Shares[i].Percent / 100)
+			total += split.Shares[i].Amount
+		}
+		if math.Abs(percent-100) > splitTolerance {
+			return errors.New("split percentages must add up to 100")
+		}
+	case SplitExact:
+		for i := range split.Shares {
+			if split.Shares[i].Amount <= 0 {
+				return errors.New("split amounts must be positive")
+			}
+			split.Shares[i].Percent = 0
+			total += split.Shares[i].Amount
+		}
+		if math.Abs(total-amount) > splitTolerance {
+			return errors.New("split amounts must add up to the expense amount")
+		}
+	}
+
+	split.Shares[0].Amount = utils.RoundAmount(split.Shares[0].Amount + amount - total)
+	return nil
+}
@@ -0,0 +1,370 @@
+package forecast
+
+import (
+	"context"
+	"fmt"
+	"my-finance-backend/account"
+	"my-finance-backend/bill"
+	"my-finance-backend/config"
+	"my-finance-backend/expense"
+	"my-finance-backend/networth"
+	"my-finance-backend/recurring"
+	"my-finance-backend/utils"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultMonths = 3
+	maxMonths     = 12
+	// historyMonths is how many complete past months category averages are taken from
+	historyMonths = 3
+	// maxOccurrences guards against stepping through a schedule that is far in the past
+	maxOccurrences = 1000
+)
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+	netWorth    *networth.Handler
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config, netWorth *networth.Handler) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+		netWorth:    netWorth,
+	}
+}
+
+// occurrences returns the dates of a schedule from first up to end, stepping with next.
+// Dates before start are still pending, e.g. an overdue bill, and are moved to start.
+func occurrences(first time.Time, start time.Time, end time.Time, next func(time.Time) time.Time) []time.Time {
+	var dates []time.Time
+	for date, i := first, 0; !date.After(end) && i < maxOccurrences; date, i = next(date), i+1 {
+		if date.Before(start) {
+			dates = append(dates, start)
+		} else {
+			dates = append(dates, date)
+		}
+	}
+	return dates
+}
+
+// startingBalance sums the current balances of the user's open accounts in the currency
+func (h *Handler) startingBalance(ctx context.Context, userID string, currencyCode string) (float64, error) {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAccountsName)
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID, "currency_code": currencyCode, "archived": bson.M{"$ne": true}})
+	if err != nil {
+		return 0, err
+	}
+	var accounts []account.Account
+	if err = cursor.All(ctx, &accounts); err != nil {
+		return 0, err
+	}
+	if err := account.ComputeBalances(ctx, h.mongoClient, h.config, userID, accounts, ""); err != nil {
+		return 0, err
+	}
+
+	balance := 0.0
+	for _, acc := range accounts {
+		balance += acc.Balance
+	}
+	return utils.RoundAmount(balance), nil
+}
+
+// scheduledEvents returns the recurring expenses, bills and regular incomes of the user
+// expected between start and end in the currency. It also returns the normalized names
+// of the templates and bills, whose past expenses are not part of the category averages.
+func (h *Handler) scheduledEvents(ctx context.Context, userID string, currencyCode string, start time.Time, end time.Time) ([]Event, map[string]bool, error) {
+	events := make([]Event, 0)
+	covered := make(map[string]bool)
+
+	templates, err := recurring.Load(ctx, h.mongoClient, h.config, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, template := range templates {
+		first, err := time.Parse("2006-01-02", template.NextDate)
+		if err != nil || template.CurrencyCode != currencyCode {
+			continue
+		}
+		covered[recurring.SubscriptionKey(template.Name)] = true
+		last := end
+		if endDate, err := time.Parse("2006-01-02", template.EndDate); err == nil && endDate.Before(last) {
+			last = endDate
+		}
+		next := func(date time.Time) time.Time {
+			return recurring.NextDate(date, template.Frequency, template.DayOfMonth)
+		}
+		for _, date := range occurrences(first, start, last, next) {
+			events = append(events, Event{
+				Date:       date.Format("2006-01-02"),
+				Source:     SourceRecurring,
+				SourceID:   template.ID,
+				Name:       template.Name,
+				Amount:     -template.Amount,
+				CategoryID: template.CategoryID,
+			})
+		}
+	}
+
+	bills, err := bill.Load(ctx, h.mongoClient, h.config, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, b := range bills {
+		first, err := time.Parse("2006-01-02", b.DueDate)
+		if err != nil || b.CurrencyCode != currencyCode {
+			continue
+		}
+		covered[recurring.SubscriptionKey(b.Name)] = true
+		next := func(date time.Time) time.Time { return bill.NextDueDate(date, b.Frequency, b.DueDay) }
+		if b.Frequency == bill.FrequencyOnce {
+			// A one-off bill is due once, whatever comes after it
+			next = func(date time.Time) time.Time { return end.AddDate(0, 0, 1) }
+		}
+		for _, date := range occurrences(first, start, end, next) {
+			events = append(events, Event{
+				Date:       date.Format("2006-01-02"),
+				Source:     SourceBill,
+				SourceID:   b.ID,
+				Name:       b.Name,
+				Amount:     -b.Amount,
+				CategoryID: b.CategoryID,
+			})
+		}
+	}
+
+	incomes, err := recurring.DetectIncomes(ctx, h.mongoClient, h.config, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, income := range incomes {
+		first, err := time.Parse("2006-01-02", income.NextExpectedDate)
+		if err != nil || !income.Active || income.CurrencyCode != currencyCode {
+			continue
+		}
+		last, _ := time.Parse("2006-01-02", income.LastChargeDate)
+		next := func(date time.Time) time.Time { return recurring.NextDate(date, income.Cadence, last.Day()) }
+		for _, date := range occurrences(first, start, end, next) {
+			events = append(events, Event{
+				Date:   date.Format("2006-01-02"),
+				Source: SourceIncome,
+				Name:   income.Name,
+				Amount: income.AverageAmount,
+			})
+		}
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Date < events[j].Date
+	})
+	return events, covered, nil
+}
+
+// categoryAverages returns the monthly spending per category over the last complete
+// months, leaving out expenses whose normalized name is covered by a template or bill
+func (h *Handler) categoryAverages(ctx context.Context, userID string, currencyCode string, today time.Time, covered map[string]bool) ([]CategoryAverage, error) {
+	to := time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+	from := to.AddDate(0, -historyMonths, 0)
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+	cursor, err := collection.Find(ctx, utils.NotDeleted(bson.M{
+		"user_id":       userID,
+		"currency_code": currencyCode,
+		"date":          bson.M{"$gte": from.Format("2006-01-02"), "$lt": to.Format("2006-01-02")},
+	}), options.Find().SetProjection(bson.M{"name": 1, "amount": 1, "category_id": 1, "line_items": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var expenses []expense.Expense
+	if err = cursor.All(ctx, &expenses); err != nil {
+		return nil, err
+	}
+
+	totals := make(map[string]float64)
+	for _, item := range expenses {
+		if covered[recurring.SubscriptionKey(item.Name)] {
+			continue
+		}
+		for categoryID, amount := range item.CategoryAmounts() {
+			totals[categoryID] += amount
+		}
+	}
+
+	averages := make([]CategoryAverage, 0, len(totals))
+	for categoryID, total := range totals {
+		averages = append(averages, CategoryAverage{CategoryID: categoryID, MonthlyAverage: utils.RoundAmount(total / historyMonths)})
+	}
+	sort.Slice(averages, func(i, j int) bool {
+		if averages[i].MonthlyAverage != averages[j].MonthlyAverage {
+			return averages[i].MonthlyAverage > averages[j].MonthlyAverage
+		}
+		return averages[i].CategoryID < averages[j].CategoryID
+	})
+	return averages, nil
+}
+
+// project plays the events and the estimated daily spending day by day from start to end,
+// filling in the balances, periods and warnings of forecast
+func project(forecast *Forecast, start time.Time, end time.Time, dailySpending float64) {
+	byDate := make(map[string][]Event)
+	for _, event := range forecast.Events {
+		byDate[event.Date] = append(byDate[event.Date], event)
+	}
+
+	balance := forecast.StartingBalance
+	below := false
+	forecast.LowestBalance = balance
+	forecast.LowestBalanceDate = start.Format("2006-01-02")
+	forecast.Periods = make([]Period, 0)
+	forecast.Warnings = make([]Warning, 0)
+
+	for day := start; !day.After(end); day = day.AddDate(0, 0, 1) {
+		date := day.Format("2006-01-02")
+		if len(forecast.Periods) == 0 || forecast.Granularity == GranularityDay || day.Day() == 1 {
+			forecast.Periods = append(forecast.Periods, Period{Start: date})
+		}
+		period := &forecast.Periods[len(forecast.Periods)-1]
+
+		for _, event := range byDate[date] {
+			if event.Amount > 0 {
+				period.Income += event.Amount
+				forecast.TotalIncome += event.Amount
+			} else {
+				period.ScheduledSpending -= event.Amount
+				forecast.TotalSpending -= event.Amount
+			}
+			balance += event.Amount
+		}
+		period.EstimatedSpending += dailySpending
+		forecast.TotalSpending += dailySpending
+		balance -= dailySpending
+
+		if period.End == "" || balance < period.LowestBalance {
+			period.LowestBalance = balance
+		}
+		period.End = date
+		period.ClosingBalance = balance
+		if balance < forecast.LowestBalance {
+			forecast.LowestBalance = balance
+			forecast.LowestBalanceDate = date
+		}
+
+		if balance < forecast.Threshold && !below {
+			forecast.Warnings = append(forecast.Warnings, Warning{
+				Date:    date,
+				Balance: utils.RoundAmount(balance),
+				Message: fmt.Sprintf("Balance is expected to fall to %.2f %s, below %.2f", balance, forecast.CurrencyCode, forecast.Threshold),
+			})
+		}
+		below = balance < forecast.Threshold
+	}
+
+	for i := range forecast.Periods {
+		period := &forecast.Periods[i]
+		period.Income = utils.RoundAmount(period.Income)
+		period.ScheduledSpending = utils.RoundAmount(period.ScheduledSpending)
+		period.EstimatedSpending = utils.RoundAmount(period.EstimatedSpending)
+		period.Net = utils.RoundAmount(period.Income - period.ScheduledSpending - period.EstimatedSpending)
+		period.ClosingBalance = utils.RoundAmount(period.ClosingBalance)
+		period.LowestBalance = utils.RoundAmount(period.LowestBalance)
+	}
+	forecast.EndingBalance = utils.RoundAmount(balance)
+	forecast.LowestBalance = utils.RoundAmount(forecast.LowestBalance)
+	forecast.TotalIncome = utils.RoundAmount(forecast.TotalIncome)
+	forecast.TotalSpending = utils.RoundAmount(forecast.TotalSpending)
+}
+
+// HandleGetForecast projects the balance of the user's accounts in one currency for the
+// coming months (months, 1 to 12, default 3) from the recurring templates, the bills, the
+// regular incomes and the average spending per category. granularity=day returns one
+// period per day instead of one per month. A warning is raised whenever the projected
+// balance falls below threshold (default 0). currency_code defaults to the base currency.
+func (h *Handler) HandleGetForecast(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	months := defaultMonths
+	if value := c.Query("months"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxMonths {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "months must be between 1 and " + strconv.Itoa(maxMonths)})
+			return
+		}
+		months = parsed
+	}
+	granularity := c.DefaultQuery("granularity", GranularityMonth)
+	if granularity != GranularityDay && granularity != GranularityMonth {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "granularity must be day or month"})
+		return
+	}
+	threshold := 0.0
+	if value := c.Query("threshold"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid threshold"})
+			return
+		}
+		threshold = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	currencyCode := c.Query("currency_code")
+	if currencyCode == "" {
+		var err error
+		if currencyCode, err = h.netWorth.BaseCurrency(ctx, userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch net worth settings"})
+			return
+		}
+	}
+
+	// The forecast starts tomorrow, today's movements being part of the current balance
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	start := today.AddDate(0, 0, 1)
+	end := today.AddDate(0, months, 0)
+	forecast := Forecast{
+		CurrencyCode: currencyCode,
+		Granularity:  granularity,
+		StartDate:    start.Format("2006-01-02"),
+		EndDate:      end.Format("2006-01-02"),
+		Threshold:    threshold,
+	}
+
+	var err error
+	if forecast.StartingBalance, err = h.startingBalance(ctx, userID, currencyCode); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute account balances"})
+		return
+	}
+	events, covered, err := h.scheduledEvents(ctx, userID, currencyCode, start, end)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not load scheduled transactions"})
+		return
+	}
+	forecast.Events = events
+	if forecast.CategoryAverages, err = h.categoryAverages(ctx, userID, currencyCode, today, covered); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute category averages"})
+		return
+	}
+
+	monthly := 0.0
+	for _, average := range forecast.CategoryAverages {
+		monthly += average.MonthlyAverage
+	}
+	project(&forecast, start, end, monthly*12/365.25)
+
+	c.JSON(http.StatusOK, forecast)
+}
@@ -0,0 +1,65 @@
+package forecast
+
+const (
+	GranularityDay   = "day"
+	GranularityMonth = "month"
+
+	SourceRecurring = "recurring"
+	SourceBill      = "bill"
+	SourceIncome    = "income"
+)
+
+// Event is a movement expected on a date of the forecast. Spending has a negative amount.
+type Event struct {
+	Date       string  `json:"date"`
+	Source     string  `json:"source"`              // recurring, bill or income
+	SourceID   string  `json:"source_id,omitempty"` // template or bill ID
+	Name       string  `json:"name"`
+	Amount     float64 `json:"amount"`
+	CategoryID string  `json:"category_id,omitempty"`
+}
+
+// CategoryAverage is the monthly spending of a category over the last complete months,
+// leaving out expenses covered by recurring templates and bills
+type CategoryAverage struct {
+	CategoryID     string  `json:"category_id,omitempty"` // empty for uncategorized expenses
+	MonthlyAverage float64 `json:"monthly_average"`
+}
+
+// Period is one day or one month of the forecast
+type Period struct {
+	Start             string  `json:"start"`
+	End               string  `json:"end"`
+	Income            float64 `json:"income"`
+	ScheduledSpending float64 `json:"scheduled_spending"` // recurring templates and bills
+	EstimatedSpending float64 `json:"estimated_spending"` // from category averages
+	Net               float64 `json:"net"`
+	ClosingBalance    float64 `json:"closing_balance"`
+	LowestBalance     float64 `json:"lowest_balance"`
+}
+
+// Warning is raised on each day the projected balance falls below the threshold
+type Warning struct {
+	Date    string  `json:"date"`
+	Balance float64 `json:"balance"`
+	Message string  `json:"message"`
+}
+
+// Forecast projects the balance of the user's accounts in one currency
+type Forecast struct {
+	CurrencyCode      string            `json:"currency_code"`
+	Granularity       string            `json:"granularity"`
+	StartDate         string            `json:"start_date"`
+	EndDate           string            `json:"end_date"`
+	Threshold         float64           `json:"threshold"`
+	StartingBalance   float64           `json:"starting_balance"` // current balance of the accounts
+	EndingBalance     float64           `json:"ending_balance"`
+	LowestBalance     float64           `json:"lowest_balance"`
+	LowestBalanceDate string            `json:"lowest_balance_date"`
+	TotalIncome       float64           `json:"total_income"`
+	TotalSpending     float64           `json:"total_spending"`
+	CategoryAverages  []CategoryAverage `json:"category_averages"`
+	Events            []Event           `json:"events"`
+	Periods           []Period          `json:"periods"`
+	Warnings          []Warning         `json:"warnings"`
+}
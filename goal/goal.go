@@ -0,0 +1,437 @@
+package goal
+
+import (
+	"context"
+	"my-finance-backend/account"
+	"my-finance-backend/config"
+	"my-finance-backend/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+	}
+}
+
+// loadProgress sums the contributions of the given goals
+func (h *Handler) loadProgress(ctx context.Context, userID string, goalIDs []string) (map[string]progress, error) {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionGoalContributionsName)
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"user_id": userID, "goal_id": bson.M{"$in": goalIDs}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   "$goal_id",
+			"saved": bson.M{"$sum": "$amount"},
+			"first": bson.M{"$min": "$date"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var results []struct {
+		GoalID string  `bson:"_id"`
+		Saved  float64 `bson:"saved"`
+		First  string  `bson:"first"`
+	}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	progressByGoal := make(map[string]progress, len(results))
+	for _, result := range results {
+		progressByGoal[result.GoalID] = progress{Saved: result.Saved, FirstContribution: result.First}
+	}
+	return progressByGoal, nil
+}
+
+// withProgress fills the computed fields of the goals
+func (h *Handler) withProgress(ctx context.Context, userID string, goals []Goal) error {
+	goalIDs := make([]string, 0, len(goals))
+	for _, goal := range goals {
+		goalIDs = append(goalIDs, goal.ID)
+	}
+	progressByGoal, err := h.loadProgress(ctx, userID, goalIDs)
+	if err != nil {
+		return err
+	}
+	today := time.Now()
+	for i := range goals {
+		applyProgress(&goals[i], progressByGoal[goals[i].ID], today)
+	}
+	return nil
+}
+
+// Load returns the user's goals with their progress
+func Load(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string) ([]Goal, error) {
+	h := NewHandler(mongoClient, config)
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionGoalsName)
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "created_at", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	goals := make([]Goal, 0)
+	if err = cursor.All(ctx, &goals); err != nil {
+		return nil, err
+	}
+	if err := h.withProgress(ctx, userID, goals); err != nil {
+		return nil, err
+	}
+	return goals, nil
+}
+
+// findGoal loads the goal of the id route parameter, writing the error response on failure
+func (h *Handler) findGoal(ctx context.Context, c *gin.Context, userID string) (Goal, bool) {
+	var goal Goal
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid goal ID " + error.Error()})
+		return goal, false
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionGoalsName)
+	err := collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&goal)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Goal not found"})
+		return goal, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch goal"})
+		return goal, false
+	}
+	return goal, true
+}
+
+// Create savings goal
+func (h *Handler) HandleCreateGoal(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateGoalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.TargetAmount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target_amount must be positive"})
+		return
+	}
+	if req.Deadline != "" {
+		if _, err := time.Parse("2006-01-02", req.Deadline); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deadline, expected YYYY-MM-DD"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if req.AccountID != "" {
+		if err := account.Check(ctx, h.mongoClient, h.config, userID, req.AccountID, req.CurrencyCode); err != nil {
+			c.JSON(account.CheckStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	goal := Goal{
+		UserID:       userID,
+		Name:         strings.TrimSpace(req.Name),
+		TargetAmount: req.TargetAmount,
+		CurrencyCode: req.CurrencyCode,
+		Deadline:     req.Deadline,
+		AccountID:    req.AccountID,
+		CreatedAt:    utils.Timestamp(),
+	}
+	goal.UpdatedAt = goal.CreatedAt
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionGoalsName)
+	result, err := collection.InsertOne(ctx, goal)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create goal"})
+		return
+	}
+	goal.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	applyProgress(&goal, progress{}, time.Now())
+
+	c.JSON(http.StatusCreated, goal)
+}
+
+// Get the user's savings goals with their progress
+func (h *Handler) HandleGetGoals(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	goals, err := Load(ctx, h.mongoClient, h.config, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch goals"})
+		return
+	}
+
+	c.JSON(http.StatusOK, goals)
+}
+
+// Get single goal with its contributions, latest first
+func (h *Handler) HandleGetGoal(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	goal, ok := h.findGoal(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionGoalContributionsName)
+	cursor, err := collection.Find(ctx,
+		bson.M{"user_id": userID, "goal_id": goal.ID},
+		options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch contributions"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	response := GetGoalResponse{Contributions: make([]Contribution, 0)}
+	if err = cursor.All(ctx, &response.Contributions); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode contributions"})
+		return
+	}
+
+	p := progress{}
+	for _, contribution := range response.Contributions {
+		p.Saved += contribution.Amount
+		p.FirstContribution = contribution.Date
+	}
+	applyProgress(&goal, p, time.Now())
+	response.Goal = goal
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Update goal
+func (h *Handler) HandleUpdateGoal(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req UpdateGoalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.TargetAmount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target_amount must be positive"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	goal, ok := h.findGoal(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	update := bson.M{}
+	unset := bson.M{}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		goal.Name = name
+		update["name"] = name
+	}
+	if req.TargetAmount > 0 {
+		goal.TargetAmount = req.TargetAmount
+		update["target_amount"] = req.TargetAmount
+	}
+	if req.Deadline != nil {
+		goal.Deadline = *req.Deadline
+		if goal.Deadline == "" {
+			unset["deadline"] = ""
+		} else if _, err := time.Parse("2006-01-02", goal.Deadline); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid deadline, expected YYYY-MM-DD"})
+			return
+		} else {
+			update["deadline"] = goal.Deadline
+		}
+	}
+	if req.AccountID != nil {
+		goal.AccountID = *req.AccountID
+		if goal.AccountID == "" {
+			unset["account_id"] = ""
+		} else if err := account.Check(ctx, h.mongoClient, h.config, userID, goal.AccountID, goal.CurrencyCode); err != nil {
+			c.JSON(account.CheckStatus(err), gin.H{"error": err.Error()})
+			return
+		} else {
+			update["account_id"] = goal.AccountID
+		}
+	}
+	goal.UpdatedAt = utils.Timestamp()
+	update["updated_at"] = goal.UpdatedAt
+
+	updateDocument := bson.M{"$set": update}
+	if len(unset) > 0 {
+		updateDocument["$unset"] = unset
+	}
+	objectId, _ := utils.StringToObjectId(goal.ID)
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionGoalsName)
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": objectId, "user_id": userID}, updateDocument); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update goal"})
+		return
+	}
+
+	goals := []Goal{goal}
+	if err := h.withProgress(ctx, userID, goals); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute goal progress"})
+		return
+	}
+
+	c.JSON(http.StatusOK, goals[0])
+}
+
+// Delete goal with its contributions
+func (h *Handler) HandleDeleteGoal(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	goal, ok := h.findGoal(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	if _, err := database.Collection(h.config.CollectionGoalContributionsName).DeleteMany(ctx, bson.M{"user_id": userID, "goal_id": goal.ID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete contributions"})
+		return
+	}
+	objectId, _ := utils.StringToObjectId(goal.ID)
+	if _, err := database.Collection(h.config.CollectionGoalsName).DeleteOne(ctx, bson.M{"_id": objectId, "user_id": userID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete goal"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Goal deleted successfully"})
+}
+
+// HandleCreateContribution records money put into a goal, or taken out with a negative
+// amount. Withdrawals cannot exceed the saved amount.
+func (h *Handler) HandleCreateContribution(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateContributionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Date == "" {
+		req.Date = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	goal, ok := h.findGoal(ctx, c, userID)
+	if !ok {
+		return
+	}
+	progressByGoal, err := h.loadProgress(ctx, userID, []string{goal.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute goal progress"})
+		return
+	}
+	if req.Amount < 0 && progressByGoal[goal.ID].Saved+req.Amount < -0.005 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Withdrawal exceeds the saved amount"})
+		return
+	}
+
+	contribution := Contribution{
+		UserID:    userID,
+		GoalID:    goal.ID,
+		Amount:    req.Amount,
+		Date:      req.Date,
+		Note:      req.Note,
+		CreatedAt: utils.Timestamp(),
+	}
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionGoalContributionsName)
+	result, err := collection.InsertOne(ctx, contribution)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create contribution"})
+		return
+	}
+	contribution.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	c.JSON(http.StatusCreated, contribution)
+}
+
+// Delete contribution
+func (h *Handler) HandleDeleteContribution(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("contribution_id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid contribution ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionGoalContributionsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectId, "user_id": userID, "goal_id": c.Param("id")})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete contribution"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Contribution not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Contribution deleted successfully"})
+}
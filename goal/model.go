@@ -0,0 +1,69 @@
+package goal
+
+// Goal is an amount the user is saving towards, optionally by a deadline and in a
+// dedicated account. Progress is tracked through contributions.
+type Goal struct {
+	ID           string  `bson:"_id,omitempty" json:"id"`
+	UserID       string  `bson:"user_id" json:"user_id"`
+	Name         string  `bson:"name" json:"name"`
+	TargetAmount float64 `bson:"target_amount" json:"target_amount"`
+	CurrencyCode string  `bson:"currency_code" json:"currency_code"`
+	Deadline     string  `bson:"deadline,omitempty" json:"deadline,omitempty"`
+	AccountID    string  `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	CreatedAt    string  `bson:"created_at" json:"created_at"`
+	UpdatedAt    string  `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+
+	// Computed on read from the contributions
+	SavedAmount float64     `bson:"-" json:"saved_amount"`
+	Progress    float64     `bson:"-" json:"progress"` // percentage of the target, capped at 100
+	Projection  *Projection `bson:"-" json:"projection,omitempty"`
+}
+
+// Projection estimates when a goal is reached at the average monthly contribution so far
+type Projection struct {
+	MonthlyAverage float64 `json:"monthly_average"`
+	Completed      bool    `json:"completed"`
+	// CompletionDate is empty when the goal is not reached and nothing is being saved
+	CompletionDate string `json:"completion_date,omitempty"`
+	// OnTrack and RequiredMonthly are only set for goals with a deadline
+	OnTrack         *bool   `json:"on_track,omitempty"`
+	RequiredMonthly float64 `json:"required_monthly,omitempty"` // to reach the target by the deadline
+}
+
+type CreateGoalRequest struct {
+	Name         string  `json:"name" binding:"required"`
+	TargetAmount float64 `json:"target_amount" binding:"required"`
+	CurrencyCode string  `json:"currency_code" binding:"required"`
+	Deadline     string  `json:"deadline"`
+	AccountID    string  `json:"account_id"`
+}
+
+// UpdateGoalRequest cannot change the currency, which would change the meaning of the contributions
+type UpdateGoalRequest struct {
+	Name         string  `json:"name"`
+	TargetAmount float64 `json:"target_amount"`
+	Deadline     *string `json:"deadline"`   // an empty string removes the deadline
+	AccountID    *string `json:"account_id"` // an empty string unlinks the account
+}
+
+// Contribution is money put towards a goal, or taken out of it when negative
+type Contribution struct {
+	ID        string  `bson:"_id,omitempty" json:"id"`
+	UserID    string  `bson:"user_id" json:"user_id"`
+	GoalID    string  `bson:"goal_id" json:"goal_id"`
+	Amount    float64 `bson:"amount" json:"amount"`
+	Date      string  `bson:"date" json:"date"`
+	Note      string  `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedAt string  `bson:"created_at" json:"created_at"`
+}
+
+type CreateContributionRequest struct {
+	Amount float64 `json:"amount" binding:"required"`
+	Date   string  `json:"date"`
+	Note   string  `json:"note"`
+}
+
+type GetGoalResponse struct {
+	Goal          Goal           `json:"goal"`
+	Contributions []Contribution `json:"contributions"`
+}
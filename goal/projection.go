@@ -0,0 +1,54 @@
+package goal
+
+import (
+	"math"
+	"my-finance-backend/utils"
+	"time"
+)
+
+// daysPerMonth is the average length of a month, used to turn day spans into months
+const daysPerMonth = 365.25 / 12
+
+// progress is the saved total of a goal and the date of its first contribution
+type progress struct {
+	Saved             float64
+	FirstContribution string
+}
+
+// applyProgress fills the computed fields of a goal. The monthly average spreads the
+// saved amount over the months since the first contribution, counting at least one.
+func applyProgress(goal *Goal, p progress, today time.Time) {
+	goal.SavedAmount = utils.RoundAmount(p.Saved)
+	if goal.TargetAmount > 0 {
+		goal.Progress = math.Min(100, math.Round(goal.SavedAmount/goal.TargetAmount*10000)/100)
+	}
+
+	projection := &Projection{}
+	goal.Projection = projection
+	today = time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, time.UTC)
+
+	if first, err := time.Parse("2006-01-02", p.FirstContribution); err == nil {
+		months := math.Max(1, today.Sub(first).Hours()/24/daysPerMonth)
+		projection.MonthlyAverage = utils.RoundAmount(goal.SavedAmount / months)
+	}
+
+	remaining := goal.TargetAmount - goal.SavedAmount
+	if remaining <= 0 {
+		projection.Completed = true
+	} else if projection.MonthlyAverage > 0 {
+		days := math.Ceil(remaining / projection.MonthlyAverage * daysPerMonth)
+		projection.CompletionDate = today.AddDate(0, 0, int(days)).Format("2006-01-02")
+	}
+
+	deadline, err := time.Parse("2006-01-02", goal.Deadline)
+	if err != nil {
+		return
+	}
+	onTrack := projection.Completed || (projection.CompletionDate != "" && projection.CompletionDate <= goal.Deadline)
+	projection.OnTrack = &onTrack
+	if !projection.Completed {
+		// Whatever is left is due at once when the deadline has passed
+		months := math.Max(1, deadline.Sub(today).Hours()/24/daysPerMonth)
+		projection.RequiredMonthly = utils.RoundAmount(remaining / months)
+	}
+}
@@ -0,0 +1,206 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"my-finance-backend/config"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// HeaderKey is the request header carrying the client generated idempotency key
+const HeaderKey = "Idempotency-Key"
+
+// maxKeyLength bounds the size of accepted keys
+const maxKeyLength = 255
+
+// bodySlack is how much larger than the largest upload a request body may be, for the
+// multipart framing
+const bodySlack = 64 << 10
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+	}
+}
+
+// EnsureIndexes creates the TTL index expiring stored responses after IdempotencyTTLHours
+func (h *Handler) EnsureIndexes(ctx context.Context) error {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionIdempotencyName)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "created_at", Value: 1}},
+		Options: options.Index().
+			SetName("created_at_ttl").
+			SetExpireAfterSeconds(int32(h.config.IdempotencyTTLHours * 3600)),
+	})
+	return err
+}
+
+// responseRecorder captures the response body while it is written to the client
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(data string) (int, error) {
+	w.body.WriteString(data)
+	return w.ResponseWriter.WriteString(data)
+}
+
+// recordID scopes a key to the user sending it, so users cannot replay each other's responses
+func recordID(userID string, key string) string {
+	return userID + ":" + key
+}
+
+// Middleware makes POST requests carrying an Idempotency-Key header safe to retry.
+// The first response for a key is stored and replayed for retries with the same key
+// and payload. Reusing a key with a different payload is rejected with 422, and a retry
+// arriving while the first request is still running gets 409. Bodies are buffered to
+// fingerprint them, so those larger than the attachment size limit get 413.
+// It must run after the authentication middleware so that keys are scoped per user;
+// requests without a user pass straight through, so that anonymous clients cannot share
+// keys and authentication responses are never stored.
+func (h *Handler) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(HeaderKey)
+		if key == "" || c.Request.Method != http.MethodPost || c.GetString("user_id") == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxKeyLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key is too long"})
+			c.Abort()
+			return
+		}
+
+		// Read the body for the fingerprint and put it back for the handler. The body is
+		// held in memory, so it is bounded by the largest upload accepted.
+		maxBody := int64(h.config.AttachmentMaxSizeMB)<<20 + bodySlack
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+			c.Abort()
+			return
+		} else if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "?" + c.Request.URL.RawQuery + "\n"))
+		hash.Write(body)
+		fingerprint := hex.EncodeToString(hash.Sum(nil))
+
+		userID := c.GetString("user_id")
+		id := recordID(userID, key)
+		collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionIdempotencyName)
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err = collection.InsertOne(ctx, Record{
+			ID:          id,
+			UserID:      userID,
+			Key:         key,
+			Fingerprint: fingerprint,
+			Status:      StatusInProgress,
+			CreatedAt:   time.Now().UTC(),
+		})
+		if mongo.IsDuplicateKeyError(err) {
+			h.replay(ctx, c, collection, id, fingerprint)
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not store idempotency key"})
+			c.Abort()
+			return
+		}
+
+		// A handler that panics leaves no response to store: release the key so that the
+		// client can retry instead of getting 409 until the record expires
+		finished := false
+		defer func() {
+			if finished {
+				return
+			}
+			releaseCtx, releaseCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer releaseCancel()
+			if _, err := collection.DeleteOne(releaseCtx, bson.M{"_id": id}); err != nil {
+				log.Printf("Could not release idempotency key %s: %v\n", key, err)
+			}
+		}()
+
+		recorder := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		finished = true
+
+		// Handlers may run longer than the context created above
+		storeCtx, storeCancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer storeCancel()
+
+		status := recorder.Status()
+		if status >= http.StatusInternalServerError {
+			// Server errors are not final, let the client retry with the same key
+			if _, err := collection.DeleteOne(storeCtx, bson.M{"_id": id}); err != nil {
+				log.Printf("Could not release idempotency key %s: %v\n", key, err)
+			}
+			return
+		}
+
+		_, err = collection.UpdateOne(storeCtx, bson.M{"_id": id}, bson.M{"$set": bson.M{
+			"status":          StatusCompleted,
+			"response_status": status,
+			"response_body":   recorder.body.Bytes(),
+			"content_type":    recorder.Header().Get("Content-Type"),
+		}})
+		if err != nil {
+			log.Printf("Could not store response for idempotency key %s: %v\n", key, err)
+		}
+	}
+}
+
+// replay answers a retried request from the stored record
+func (h *Handler) replay(ctx context.Context, c *gin.Context, collection *mongo.Collection, id string, fingerprint string) {
+	defer c.Abort()
+
+	var record Record
+	if err := collection.FindOne(ctx, bson.M{"_id": id}).Decode(&record); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not read idempotency key"})
+		return
+	}
+
+	if record.Fingerprint != fingerprint {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+		return
+	}
+	if record.Status != StatusCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still being processed"})
+		return
+	}
+
+	c.Header("Idempotent-Replayed", "true")
+	c.Data(record.ResponseStatus, record.ContentType, record.ResponseBody)
+}
@@ -0,0 +1,21 @@
+package idempotency
+
+import "time"
+
+const (
+	StatusInProgress = "in_progress"
+	StatusCompleted  = "completed"
+)
+
+// Record is the stored outcome of the first request made with an idempotency key
+type Record struct {
+	ID             string    `bson:"_id"` // user scope and key, see recordID
+	UserID         string    `bson:"user_id"`
+	Key            string    `bson:"key"`
+	Fingerprint    string    `bson:"fingerprint"` // hash of method, path and body
+	Status         string    `bson:"status"`
+	ResponseStatus int       `bson:"response_status,omitempty"`
+	ResponseBody   []byte    `bson:"response_body,omitempty"`
+	ContentType    string    `bson:"content_type,omitempty"`
+	CreatedAt      time.Time `bson:"created_at"` // expired by a TTL index
+}
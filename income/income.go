@@ -0,0 +1,290 @@
+package income
+
+import (
+	"context"
+	"my-finance-backend/account"
+	"my-finance-backend/config"
+	"my-finance-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+	}
+}
+
+// Create income
+func (h *Handler) HandleCreateIncome(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateIncomeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if req.Date == "" {
+		req.Date = time.Now().Format("2006-01-02")
+	} else if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if req.AccountID != "" {
+		if err := account.Check(ctx, h.mongoClient, h.config, userID, req.AccountID, req.CurrencyCode); err != nil {
+			c.JSON(account.CheckStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	income := Income{
+		UserID:       userID,
+		AccountID:    req.AccountID,
+		Amount:       req.Amount,
+		CurrencyCode: req.CurrencyCode,
+		Name:         req.Name,
+		Description:  req.Description,
+		Date:         req.Date,
+		CreatedAt:    utils.Timestamp(),
+	}
+	income.UpdatedAt = income.CreatedAt
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionIncomesName)
+	result, err := collection.InsertOne(ctx, income)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create income"})
+		return
+	}
+	income.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	c.JSON(http.StatusCreated, income)
+}
+
+// Get the user's incomes, most recent first. Optional query parameters: from and to
+// (YYYY-MM-DD, inclusive) and account_id.
+func (h *Handler) HandleGetIncomes(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	filter := bson.M{"user_id": userID}
+	dateFilter := bson.M{}
+	if from := c.Query("from"); from != "" {
+		if _, err := time.Parse("2006-01-02", from); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from parameter, expected YYYY-MM-DD"})
+			return
+		}
+		dateFilter["$gte"] = from
+	}
+	if to := c.Query("to"); to != "" {
+		if _, err := time.Parse("2006-01-02", to); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to parameter, expected YYYY-MM-DD"})
+			return
+		}
+		dateFilter["$lte"] = to
+	}
+	if len(dateFilter) > 0 {
+		filter["date"] = dateFilter
+	}
+	if accountID := c.Query("account_id"); accountID != "" {
+		filter["account_id"] = accountID
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionIncomesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch incomes"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var incomes []Income = make([]Income, 0)
+	if err = cursor.All(ctx, &incomes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode incomes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, incomes)
+}
+
+// Get single income
+func (h *Handler) HandleGetIncome(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid income ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionIncomesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var income Income
+	err := collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&income)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Income not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch income"})
+		return
+	}
+
+	c.JSON(http.StatusOK, income)
+}
+
+// Update income
+func (h *Handler) HandleUpdateIncome(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid income ID " + error.Error()})
+		return
+	}
+
+	var req UpdateIncomeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionIncomesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var income Income
+	err := collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&income)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Income not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch income"})
+		return
+	}
+	if income.ReconciliationID != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Income is reconciled and cannot be changed"})
+		return
+	}
+
+	if req.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if req.Amount != 0 {
+		income.Amount = req.Amount
+	}
+	if req.CurrencyCode != "" {
+		income.CurrencyCode = req.CurrencyCode
+	}
+	if req.Name != "" {
+		income.Name = req.Name
+	}
+	if req.Description != "" {
+		income.Description = req.Description
+	}
+	if req.Date != "" {
+		if _, err := time.Parse("2006-01-02", req.Date); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+			return
+		}
+		income.Date = req.Date
+	}
+	if req.AccountID != nil {
+		income.AccountID = *req.AccountID
+	}
+	if income.AccountID != "" && (req.AccountID != nil || req.CurrencyCode != "") {
+		if err := account.Check(ctx, h.mongoClient, h.config, userID, income.AccountID, income.CurrencyCode); err != nil {
+			c.JSON(account.CheckStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+	income.UpdatedAt = utils.Timestamp()
+
+	income.ID = ""
+	if _, err := collection.ReplaceOne(ctx, bson.M{"_id": objectId, "user_id": userID}, income); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update income"})
+		return
+	}
+	income.ID = objectId.Hex()
+
+	c.JSON(http.StatusOK, income)
+}
+
+// Delete income
+func (h *Handler) HandleDeleteIncome(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid income ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionIncomesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{
+		"_id":               objectId,
+		"user_id":           userID,
+		"reconciliation_id": bson.M{"$exists": false},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete income"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		if count, err := collection.CountDocuments(ctx, bson.M{"_id": objectId, "user_id": userID}); err == nil && count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Income is reconciled and cannot be deleted"})
+			return
+		}
+		c.JSON(http.StatusNotFound, gin.H{"error": "Income not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Income deleted successfully"})
+}
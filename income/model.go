@@ -0,0 +1,36 @@
+package income
+
+// Income is money received, optionally into an account
+type Income struct {
+	ID           string  `bson:"_id,omitempty" json:"id"`
+	UserID       string  `bson:"user_id" json:"user_id"`
+	AccountID    string  `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	Amount       float64 `bson:"amount" json:"amount"`
+	CurrencyCode string  `bson:"currency_code" json:"currency_code"`
+	Name         string  `bson:"name" json:"name"`
+	Description  string  `bson:"description" json:"description"`
+	Date         string  `bson:"date" json:"date"`
+	CreatedAt    string  `bson:"created_at" json:"created_at"`
+	UpdatedAt    string  `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+	// ReconciliationID is set once the income is matched against an account statement,
+	// after which it can no longer be changed
+	ReconciliationID string `bson:"reconciliation_id,omitempty" json:"reconciliation_id,omitempty"`
+}
+
+type CreateIncomeRequest struct {
+	Amount       float64 `json:"amount" binding:"required"`
+	CurrencyCode string  `json:"currency_code" binding:"required"`
+	Name         string  `json:"name" binding:"required"`
+	Description  string  `json:"description"`
+	Date         string  `json:"date"`
+	AccountID    string  `json:"account_id"`
+}
+
+type UpdateIncomeRequest struct {
+	Amount       float64 `json:"amount"`
+	CurrencyCode string  `json:"currency_code"`
+	Name         string  `json:"name"`
+	Description  string  `json:"description"`
+	Date         string  `json:"date"`
+	AccountID    *string `json:"account_id"` // an empty string unlinks the account
+}
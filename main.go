@@ -3,12 +3,32 @@ package main
 import (
 	"context"
 	"log"
+	"my-finance-backend/account"
+	"my-finance-backend/attachment"
+	"my-finance-backend/audit"
 	"my-finance-backend/authentication"
+	"my-finance-backend/bill"
 	"my-finance-backend/category"
+	"my-finance-backend/debt"
+	"my-finance-backend/event"
 	"my-finance-backend/expense"
+	"my-finance-backend/forecast"
+	"my-finance-backend/goal"
+	"my-finance-backend/idempotency"
+	"my-finance-backend/income"
+	"my-finance-backend/networth"
+	"my-finance-backend/notification"
+	"my-finance-backend/reconciliation"
+	"my-finance-backend/recurring"
+	"my-finance-backend/rule"
+	"my-finance-backend/split"
+	"my-finance-backend/suggestion"
+	"my-finance-backend/synchronization"
 	"my-finance-backend/tag"
+	"my-finance-backend/trash"
 
 	"my-finance-backend/version"
+	"my-finance-backend/webhook"
 	"net/http"
 	"runtime"
 	"strings"
@@ -60,6 +80,19 @@ func authMiddleware() gin.HandlerFunc {
 	}
 }
 
+// queryTokenMiddleware accepts the bearer token from the access_token query parameter
+// when no Authorization header is sent
+func queryTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "" {
+			if token := c.Query("access_token"); token != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		c.Next()
+	}
+}
+
 func main() {
 	// Load configuration
 	config := LoadConfig()
@@ -83,11 +116,72 @@ func main() {
 	log.Printf("Connected to MongoDB! Environment: %s, Database: %s\n", config.AppEnv, config.DatabaseName)
 
 	// Initialize handlers
+	eventBus := event.NewBus()
 	authHandler := authentication.NewHandler(client, config, []byte(config.JWTSecret))
-	expenseHandler := expense.NewHandler(client, config, []byte(config.JWTSecret))
+	expenseHandler := expense.NewHandler(client, config, []byte(config.JWTSecret), eventBus)
 
-	categoryHandler := category.NewHandler(client, config, []byte(config.JWTSecret))
+	categoryHandler := category.NewHandler(client, config, []byte(config.JWTSecret), eventBus)
 	tagHandler := tag.NewHandler(client, config)
+	ruleHandler := rule.NewHandler(client, config)
+	suggestionHandler := suggestion.NewHandler(client, config)
+	blobStore, err := attachment.NewBlobStore(client, config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	attachmentHandler := attachment.NewHandler(client, config, blobStore)
+	splitHandler := split.NewHandler(client, config)
+	accountHandler := account.NewHandler(client, config)
+	incomeHandler := income.NewHandler(client, config)
+	reconciliationHandler := reconciliation.NewHandler(client, config)
+	goalHandler := goal.NewHandler(client, config)
+	debtHandler := debt.NewHandler(client, config)
+	netWorthHandler := networth.NewHandler(client, config)
+	notificationHandler := notification.NewHandler(client, config)
+	billHandler := bill.NewHandler(client, config, expenseHandler, eventBus)
+	recurringHandler := recurring.NewHandler(client, config, expenseHandler)
+	forecastHandler := forecast.NewHandler(client, config, netWorthHandler)
+	trashHandler := trash.NewHandler(client, config, attachmentHandler)
+	auditHandler := audit.NewHandler(client, config)
+	idempotencyHandler := idempotency.NewHandler(client, config)
+	syncHandler := synchronization.NewHandler(client, config, eventBus)
+	eventHandler := event.NewHandler(eventBus)
+	webhookHandler := webhook.NewHandler(client, config)
+	eventBus.Listen(webhookHandler.HandleEvent)
+
+	// Create the search index and backfill older expenses
+	indexCtx, indexCancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	if err := expenseHandler.EnsureSearchIndex(indexCtx); err != nil {
+		log.Printf("Could not prepare expense search index: %v\n", err)
+	}
+	if err := idempotencyHandler.EnsureIndexes(indexCtx); err != nil {
+		log.Printf("Could not prepare idempotency key index: %v\n", err)
+	}
+	if err := webhookHandler.EnsureIndexes(indexCtx); err != nil {
+		log.Printf("Could not prepare webhook delivery indexes: %v\n", err)
+	}
+	if err := netWorthHandler.EnsureIndexes(indexCtx); err != nil {
+		log.Printf("Could not prepare net worth snapshot index: %v\n", err)
+	}
+	if err := notificationHandler.EnsureIndexes(indexCtx); err != nil {
+		log.Printf("Could not prepare notification indexes: %v\n", err)
+	}
+	indexCancel()
+
+	// Purge expired trash in the background
+	trashHandler.StartPurger(context.Background())
+
+	// Deliver queued webhook payloads in the background
+	webhookHandler.StartDispatcher(context.Background())
+
+	// Keep the monthly net worth snapshots up to date
+	netWorthHandler.StartSnapshotter(context.Background())
+
+	// Remind users of upcoming and overdue bills
+	billHandler.StartReminders(context.Background())
+
+	// Create the expenses of due recurring templates
+	recurringHandler.StartGenerator(context.Background())
+
 	// Initialize Gin router
 	r := gin.Default()
 
@@ -107,9 +201,12 @@ func main() {
 	// Login by token
 	r.POST("/api/user", authHandler.HandleLoginByToken)
 
+	// Real-time change events. EventSource cannot set headers, so the token may be passed as access_token.
+	r.GET("/api/events", queryTokenMiddleware(), authMiddleware(), eventHandler.HandleStream)
+
 	// Protected routes
 	auth := r.Group("/api")
-	auth.Use(authMiddleware())
+	auth.Use(authMiddleware(), idempotencyHandler.Middleware())
 	{
 		// Category routes
 		auth.POST("/categories", categoryHandler.HandleCreateCategory)
@@ -117,12 +214,22 @@ func main() {
 		auth.GET("/categories/:id", categoryHandler.HandleGetCategory)
 		auth.PUT("/categories/:id", categoryHandler.HandleUpdateCategory)
 		auth.DELETE("/categories/:id", categoryHandler.HandleDeleteCategory)
+		auth.POST("/categories/:id/restore", categoryHandler.HandleRestoreCategory)
+		auth.GET("/categories/:id/history", auditHandler.HandleGetCategoryHistory)
 
 		// Tag routes
 		r.POST("/api/tags", tagHandler.HandleCreateTag)
 		r.GET("/api/tags", tagHandler.HandleGetTags)
 		r.GET("/api/tags/:id", tagHandler.HandleGetTag)
 
+		// Categorization rule routes
+		auth.POST("/rules", ruleHandler.HandleCreateRule)
+		auth.GET("/rules", ruleHandler.HandleGetRules)
+		auth.PUT("/rules/order", ruleHandler.HandleReorderRules)
+		auth.GET("/rules/:id", ruleHandler.HandleGetRule)
+		auth.PUT("/rules/:id", ruleHandler.HandleUpdateRule)
+		auth.DELETE("/rules/:id", ruleHandler.HandleDeleteRule)
+
 		// Expense routes
 		auth.POST("/expenses", expenseHandler.HandleCreateExpense)
 		auth.GET("/expenses", expenseHandler.HandleGetExpenses)
@@ -130,10 +237,124 @@ func main() {
 		auth.GET("/expenses_montly", expenseHandler.HandleGetExpensesMonthly)
 		auth.POST("/expenses/upload", expenseHandler.HandleUploadCSV)
 		auth.GET("/expenses/download", expenseHandler.HandleDownloadCSV)
+		auth.POST("/expenses/apply_rules", expenseHandler.HandleApplyRules)
+		auth.GET("/expenses/suggest_category", suggestionHandler.HandleSuggestCategory)
+		auth.GET("/expenses/duplicates", expenseHandler.HandleGetDuplicates)
+		auth.POST("/expenses/duplicates/dismiss", expenseHandler.HandleDismissDuplicates)
+		auth.GET("/expenses/search", expenseHandler.HandleSearchExpenses)
+		auth.POST("/expenses/bulk", expenseHandler.HandleBulkExpenses)
 
 		auth.GET("/expenses/:id", expenseHandler.HandleGetExpense)
 		auth.PUT("/expenses/:id", expenseHandler.HandleUpdateExpense)
 		auth.DELETE("/expenses/:id", expenseHandler.HandleDeleteExpense)
+		auth.POST("/expenses/:id/restore", expenseHandler.HandleRestoreExpense)
+		auth.GET("/expenses/:id/history", auditHandler.HandleGetExpenseHistory)
+		auth.POST("/expenses/:id/history/:entry_id/revert", expenseHandler.HandleRevertExpense)
+		auth.POST("/expenses/:id/attachments", attachmentHandler.HandleUploadAttachment)
+		auth.GET("/expenses/:id/attachments", attachmentHandler.HandleGetAttachments)
+		auth.GET("/expenses/:id/attachments/:attachment_id", attachmentHandler.HandleDownloadAttachment)
+		auth.DELETE("/expenses/:id/attachments/:attachment_id", attachmentHandler.HandleDeleteAttachment)
+
+		// Shared expense routes
+		auth.GET("/splits/balances", splitHandler.HandleGetBalances)
+		auth.GET("/splits/settle_up", splitHandler.HandleGetSettleUp)
+		auth.GET("/splits/pending", splitHandler.HandleGetPendingShares)
+		auth.POST("/splits/:id/accept", splitHandler.HandleAcceptShare)
+		auth.POST("/settlements", splitHandler.HandleCreateSettlement)
+		auth.GET("/settlements", splitHandler.HandleGetSettlements)
+		auth.DELETE("/settlements/:id", splitHandler.HandleDeleteSettlement)
+
+		// Account routes
+		auth.POST("/accounts", accountHandler.HandleCreateAccount)
+		auth.GET("/accounts", accountHandler.HandleGetAccounts)
+		auth.GET("/accounts/:id", accountHandler.HandleGetAccount)
+		auth.PUT("/accounts/:id", accountHandler.HandleUpdateAccount)
+		auth.DELETE("/accounts/:id", accountHandler.HandleDeleteAccount)
+		auth.GET("/accounts/:id/transactions", accountHandler.HandleGetTransactions)
+		auth.POST("/transfers", accountHandler.HandleCreateTransfer)
+		auth.DELETE("/transfers/:id", accountHandler.HandleDeleteTransfer)
+
+		// Reconciliation routes
+		auth.POST("/accounts/:id/reconciliations", reconciliationHandler.HandleCreateReconciliation)
+		auth.GET("/accounts/:id/reconciliations", reconciliationHandler.HandleGetReconciliations)
+		auth.GET("/reconciliations/:id", reconciliationHandler.HandleGetReconciliation)
+		auth.PUT("/reconciliations/:id/transactions", reconciliationHandler.HandleUpdateCleared)
+		auth.POST("/reconciliations/:id/complete", reconciliationHandler.HandleCompleteReconciliation)
+		auth.DELETE("/reconciliations/:id", reconciliationHandler.HandleDeleteReconciliation)
+
+		// Income routes
+		auth.POST("/incomes", incomeHandler.HandleCreateIncome)
+		auth.GET("/incomes", incomeHandler.HandleGetIncomes)
+		auth.GET("/incomes/:id", incomeHandler.HandleGetIncome)
+		auth.PUT("/incomes/:id", incomeHandler.HandleUpdateIncome)
+		auth.DELETE("/incomes/:id", incomeHandler.HandleDeleteIncome)
+
+		// Savings goal routes
+		auth.POST("/goals", goalHandler.HandleCreateGoal)
+		auth.GET("/goals", goalHandler.HandleGetGoals)
+		auth.GET("/goals/:id", goalHandler.HandleGetGoal)
+		auth.PUT("/goals/:id", goalHandler.HandleUpdateGoal)
+		auth.DELETE("/goals/:id", goalHandler.HandleDeleteGoal)
+		auth.POST("/goals/:id/contributions", goalHandler.HandleCreateContribution)
+		auth.DELETE("/goals/:id/contributions/:contribution_id", goalHandler.HandleDeleteContribution)
+
+		// Debt routes
+		auth.POST("/debts", debtHandler.HandleCreateDebt)
+		auth.GET("/debts", debtHandler.HandleGetDebts)
+		auth.GET("/debts/:id", debtHandler.HandleGetDebt)
+		auth.PUT("/debts/:id", debtHandler.HandleUpdateDebt)
+		auth.DELETE("/debts/:id", debtHandler.HandleDeleteDebt)
+		auth.POST("/debts/:id/repayments", debtHandler.HandleCreateRepayment)
+		auth.DELETE("/debts/:id/repayments/:repayment_id", debtHandler.HandleDeleteRepayment)
+
+		// Net worth routes
+		auth.GET("/net_worth", netWorthHandler.HandleGetNetWorth)
+		auth.GET("/net_worth/history", netWorthHandler.HandleGetHistory)
+		auth.POST("/net_worth/snapshots", netWorthHandler.HandleCreateSnapshot)
+		auth.GET("/net_worth/settings", netWorthHandler.HandleGetSettings)
+		auth.PUT("/net_worth/settings", netWorthHandler.HandleUpdateSettings)
+
+		// Bill routes
+		auth.POST("/bills", billHandler.HandleCreateBill)
+		auth.GET("/bills", billHandler.HandleGetBills)
+		auth.GET("/bills/:id", billHandler.HandleGetBill)
+		auth.PUT("/bills/:id", billHandler.HandleUpdateBill)
+		auth.DELETE("/bills/:id", billHandler.HandleDeleteBill)
+		auth.POST("/bills/:id/pay", billHandler.HandlePayBill)
+
+		// Notification routes
+		auth.GET("/notifications", notificationHandler.HandleGetNotifications)
+		auth.POST("/notifications/read_all", notificationHandler.HandleMarkAllRead)
+		auth.POST("/notifications/:id/read", notificationHandler.HandleMarkRead)
+
+		// Recurring expense routes
+		auth.POST("/recurring", recurringHandler.HandleCreateTemplate)
+		auth.GET("/recurring", recurringHandler.HandleGetTemplates)
+		auth.GET("/recurring/subscriptions", recurringHandler.HandleGetSubscriptions)
+		auth.POST("/recurring/subscriptions/convert", recurringHandler.HandleConvertSubscription)
+		auth.GET("/recurring/:id", recurringHandler.HandleGetTemplate)
+		auth.PUT("/recurring/:id", recurringHandler.HandleUpdateTemplate)
+		auth.DELETE("/recurring/:id", recurringHandler.HandleDeleteTemplate)
+
+		// Forecast routes
+		auth.GET("/forecast", forecastHandler.HandleGetForecast)
+
+		// Offline sync routes
+		auth.GET("/sync", syncHandler.HandlePull)
+		auth.POST("/sync", syncHandler.HandlePush)
+
+		// Webhook routes
+		auth.POST("/webhooks", webhookHandler.HandleCreateWebhook)
+		auth.GET("/webhooks", webhookHandler.HandleGetWebhooks)
+		auth.GET("/webhooks/:id", webhookHandler.HandleGetWebhook)
+		auth.PUT("/webhooks/:id", webhookHandler.HandleUpdateWebhook)
+		auth.DELETE("/webhooks/:id", webhookHandler.HandleDeleteWebhook)
+		auth.GET("/webhooks/:id/deliveries", webhookHandler.HandleGetDeliveries)
+		auth.POST("/webhooks/:id/test", webhookHandler.HandleTestWebhook)
+
+		// Trash routes
+		auth.GET("/trash", trashHandler.HandleGetTrash)
+		auth.DELETE("/trash", trashHandler.HandleEmptyTrash)
 
 	}
 
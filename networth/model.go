@@ -0,0 +1,58 @@
+package networth
+
+const (
+	ItemAccount = "account"
+	ItemGoal    = "goal"
+	ItemDebt    = "debt"
+)
+
+// Settings hold the currency the net worth of a user is expressed in and the rates to
+// convert other currencies to it
+type Settings struct {
+	UserID       string `bson:"user_id" json:"user_id"`
+	BaseCurrency string `bson:"base_currency" json:"base_currency"`
+	// Rates are the value of one unit of each currency in the base currency
+	Rates     map[string]float64 `bson:"rates" json:"rates"`
+	UpdatedAt string             `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+// UpdateSettingsRequest replaces the rates when they are given
+type UpdateSettingsRequest struct {
+	BaseCurrency string             `json:"base_currency"`
+	Rates        map[string]float64 `json:"rates"`
+}
+
+// Item is one account, savings goal or debt counted in the net worth. Amount is in the
+// item's currency and negative for liabilities.
+type Item struct {
+	Type         string  `json:"type"` // account, goal or debt
+	ID           string  `json:"id"`
+	Name         string  `json:"name"`
+	Amount       float64 `json:"amount"`
+	CurrencyCode string  `json:"currency_code"`
+	BaseAmount   float64 `json:"base_amount"` // Amount converted to the base currency
+}
+
+type NetWorth struct {
+	BaseCurrency string  `json:"base_currency"`
+	Date         string  `json:"date"`
+	Assets       float64 `json:"assets"`
+	Liabilities  float64 `json:"liabilities"`
+	NetWorth     float64 `json:"net_worth"`
+	Items        []Item  `json:"items"`
+	// MissingRates are currencies without an exchange rate, whose items are left out of the totals
+	MissingRates []string `json:"missing_rates,omitempty"`
+}
+
+// Snapshot is the net worth of a user in a month, refreshed until the month is over
+type Snapshot struct {
+	ID           string  `bson:"_id,omitempty" json:"id"`
+	UserID       string  `bson:"user_id" json:"user_id"`
+	Month        string  `bson:"month" json:"month"` // YYYY-MM
+	Date         string  `bson:"date" json:"date"`   // day the values were taken
+	BaseCurrency string  `bson:"base_currency" json:"base_currency"`
+	Assets       float64 `bson:"assets" json:"assets"`
+	Liabilities  float64 `bson:"liabilities" json:"liabilities"`
+	NetWorth     float64 `bson:"net_worth" json:"net_worth"`
+	UpdatedAt    string  `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,393 @@
+package networth
+
+import (
+	"context"
+	"log"
+	"my-finance-backend/account"
+	"my-finance-backend/authentication"
+	"my-finance-backend/config"
+	"my-finance-backend/debt"
+	"my-finance-backend/goal"
+	"my-finance-backend/utils"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// snapshotInterval is how often the snapshots of the current month are refreshed
+const snapshotInterval = 6 * time.Hour
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+	}
+}
+
+// EnsureIndexes creates the index keeping one snapshot per user and month
+func (h *Handler) EnsureIndexes(ctx context.Context) error {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionNetWorthSnapshotsName)
+	_, err := collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "month", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// loadSettings returns the currency settings of the user, defaulting to the configured base currency
+func (h *Handler) loadSettings(ctx context.Context, userID string) (Settings, error) {
+	settings := Settings{UserID: userID}
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionNetWorthSettingsName)
+	err := collection.FindOne(ctx, bson.M{"user_id": userID}).Decode(&settings)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return settings, err
+	}
+	if settings.BaseCurrency == "" {
+		settings.BaseCurrency = h.config.DefaultBaseCurrency
+	}
+	if settings.Rates == nil {
+		settings.Rates = make(map[string]float64)
+	}
+	return settings, nil
+}
+
+// BaseCurrency returns the currency the user's figures are expressed in
+func (h *Handler) BaseCurrency(ctx context.Context, userID string) (string, error) {
+	settings, err := h.loadSettings(ctx, userID)
+	return settings.BaseCurrency, err
+}
+
+// compute sums the account balances, the savings of goals not kept in an account and the
+// outstanding debts of the user in the base currency
+func (h *Handler) compute(ctx context.Context, userID string) (NetWorth, error) {
+	settings, err := h.loadSettings(ctx, userID)
+	if err != nil {
+		return NetWorth{}, err
+	}
+	result := NetWorth{
+		BaseCurrency: settings.BaseCurrency,
+		Date:         time.Now().Format("2006-01-02"),
+		Items:        make([]Item, 0),
+	}
+
+	cursor, err := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionAccountsName).Find(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return result, err
+	}
+	var accounts []account.Account
+	if err = cursor.All(ctx, &accounts); err != nil {
+		return result, err
+	}
+	if err := account.ComputeBalances(ctx, h.mongoClient, h.config, userID, accounts, ""); err != nil {
+		return result, err
+	}
+	for _, acc := range accounts {
+		result.Items = append(result.Items, Item{Type: ItemAccount, ID: acc.ID, Name: acc.Name, Amount: acc.Balance, CurrencyCode: acc.CurrencyCode})
+	}
+
+	goals, err := goal.Load(ctx, h.mongoClient, h.config, userID)
+	if err != nil {
+		return result, err
+	}
+	for _, g := range goals {
+		// Savings in a linked account are already part of its balance
+		if g.AccountID != "" {
+			continue
+		}
+		result.Items = append(result.Items, Item{Type: ItemGoal, ID: g.ID, Name: g.Name, Amount: g.SavedAmount, CurrencyCode: g.CurrencyCode})
+	}
+
+	debts, err := debt.Load(ctx, h.mongoClient, h.config, userID)
+	if err != nil {
+		return result, err
+	}
+	for _, d := range debts {
+		if d.Status == debt.StatusSettled {
+			continue
+		}
+		amount := d.Outstanding
+		if d.Direction == debt.DirectionBorrowed {
+			amount = -amount
+		}
+		result.Items = append(result.Items, Item{Type: ItemDebt, ID: d.ID, Name: d.Counterparty, Amount: amount, CurrencyCode: d.CurrencyCode})
+	}
+
+	missing := make(map[string]bool)
+	for i := range result.Items {
+		item := &result.Items[i]
+		rate := 1.0
+		if item.CurrencyCode != settings.BaseCurrency {
+			var ok bool
+			if rate, ok = settings.Rates[item.CurrencyCode]; !ok || rate <= 0 {
+				missing[item.CurrencyCode] = true
+				continue
+			}
+		}
+		item.BaseAmount = utils.RoundAmount(item.Amount * rate)
+		if item.BaseAmount >= 0 {
+			result.Assets += item.BaseAmount
+		} else {
+			result.Liabilities -= item.BaseAmount
+		}
+	}
+	for currencyCode := range missing {
+		result.MissingRates = append(result.MissingRates, currencyCode)
+	}
+	sort.Strings(result.MissingRates)
+
+	result.Assets = utils.RoundAmount(result.Assets)
+	result.Liabilities = utils.RoundAmount(result.Liabilities)
+	result.NetWorth = utils.RoundAmount(result.Assets - result.Liabilities)
+	return result, nil
+}
+
+// takeSnapshot stores the current net worth of the user as the snapshot of this month
+func (h *Handler) takeSnapshot(ctx context.Context, userID string, netWorth NetWorth) (Snapshot, error) {
+	snapshot := Snapshot{
+		UserID:       userID,
+		Month:        netWorth.Date[:7],
+		Date:         netWorth.Date,
+		BaseCurrency: netWorth.BaseCurrency,
+		Assets:       netWorth.Assets,
+		Liabilities:  netWorth.Liabilities,
+		NetWorth:     netWorth.NetWorth,
+		UpdatedAt:    utils.Timestamp(),
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionNetWorthSnapshotsName)
+	err := collection.FindOneAndUpdate(ctx,
+		bson.M{"user_id": userID, "month": snapshot.Month},
+		bson.M{"$set": bson.M{
+			"date":          snapshot.Date,
+			"base_currency": snapshot.BaseCurrency,
+			"assets":        snapshot.Assets,
+			"liabilities":   snapshot.Liabilities,
+			"net_worth":     snapshot.NetWorth,
+			"updated_at":    snapshot.UpdatedAt,
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)).Decode(&snapshot)
+	return snapshot, err
+}
+
+// SnapshotAll refreshes the snapshot of the current month for every user with accounts,
+// goals or debts. It returns the number of snapshots written.
+func (h *Handler) SnapshotAll(ctx context.Context) (int, error) {
+	cursor, err := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionUserName).Find(ctx, bson.M{},
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return 0, err
+	}
+	var users []authentication.User
+	if err = cursor.All(ctx, &users); err != nil {
+		return 0, err
+	}
+
+	written := 0
+	for _, user := range users {
+		netWorth, err := h.compute(ctx, user.ID)
+		if err != nil {
+			return written, err
+		}
+		if len(netWorth.Items) == 0 {
+			continue
+		}
+		if _, err := h.takeSnapshot(ctx, user.ID, netWorth); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// StartSnapshotter runs SnapshotAll periodically until ctx is cancelled
+func (h *Handler) StartSnapshotter(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(snapshotInterval)
+		defer ticker.Stop()
+
+		for {
+			snapshotCtx, cancel := context.WithTimeout(ctx, 10*time.Minute)
+			if _, err := h.SnapshotAll(snapshotCtx); err != nil {
+				log.Printf("Could not take net worth snapshots: %v\n", err)
+			}
+			cancel()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// HandleGetNetWorth returns the current net worth of the user with its items
+func (h *Handler) HandleGetNetWorth(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	netWorth, err := h.compute(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute net worth"})
+		return
+	}
+
+	c.JSON(http.StatusOK, netWorth)
+}
+
+// HandleCreateSnapshot refreshes the snapshot of the current month right away
+func (h *Handler) HandleCreateSnapshot(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	netWorth, err := h.compute(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not compute net worth"})
+		return
+	}
+	snapshot, err := h.takeSnapshot(ctx, userID, netWorth)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not save snapshot"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshot)
+}
+
+// HandleGetHistory lists the monthly snapshots of the user, oldest first. Optional from
+// and to (YYYY-MM, inclusive) limit the months.
+func (h *Handler) HandleGetHistory(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	filter := bson.M{"user_id": userID}
+	months := bson.M{}
+	if from := c.Query("from"); from != "" {
+		if _, err := time.Parse("2006-01", from); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from parameter, expected YYYY-MM"})
+			return
+		}
+		months["$gte"] = from
+	}
+	if to := c.Query("to"); to != "" {
+		if _, err := time.Parse("2006-01", to); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to parameter, expected YYYY-MM"})
+			return
+		}
+		months["$lte"] = to
+	}
+	if len(months) > 0 {
+		filter["month"] = months
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionNetWorthSnapshotsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "month", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch snapshots"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var snapshots []Snapshot = make([]Snapshot, 0)
+	if err = cursor.All(ctx, &snapshots); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode snapshots"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshots)
+}
+
+// Get the currency settings used for net worth
+func (h *Handler) HandleGetSettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	settings, err := h.loadSettings(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// HandleUpdateSettings sets the base currency and the exchange rates to it
+func (h *Handler) HandleUpdateSettings(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req UpdateSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	for currencyCode, rate := range req.Rates {
+		if rate <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Rate must be positive for " + currencyCode})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	settings, err := h.loadSettings(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch settings"})
+		return
+	}
+	if baseCurrency := strings.TrimSpace(req.BaseCurrency); baseCurrency != "" {
+		settings.BaseCurrency = baseCurrency
+	}
+	if req.Rates != nil {
+		settings.Rates = req.Rates
+	}
+	settings.UpdatedAt = utils.Timestamp()
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionNetWorthSettingsName)
+	_, err = collection.ReplaceOne(ctx, bson.M{"user_id": userID}, settings, options.Replace().SetUpsert(true))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
@@ -0,0 +1,21 @@
+package notification
+
+// Notification is an entry of the user's notification feed
+type Notification struct {
+	ID         string `bson:"_id,omitempty" json:"id"`
+	UserID     string `bson:"user_id" json:"user_id"`
+	Type       string `bson:"type" json:"type"` // e.g. bill.due
+	Title      string `bson:"title" json:"title"`
+	Message    string `bson:"message" json:"message"`
+	EntityType string `bson:"entity_type,omitempty" json:"entity_type,omitempty"`
+	EntityID   string `bson:"entity_id,omitempty" json:"entity_id,omitempty"`
+	// Key identifies what the notification is about, so that it is only created once
+	Key       string `bson:"key" json:"-"`
+	Read      bool   `bson:"read" json:"read"`
+	CreatedAt string `bson:"created_at" json:"created_at"`
+}
+
+type GetNotificationsResponse struct {
+	Notifications []Notification `json:"notifications"`
+	UnreadCount   int64          `json:"unread_count"`
+}
@@ -0,0 +1,185 @@
+package notification
+
+import (
+	"context"
+	"my-finance-backend/config"
+	"my-finance-backend/event"
+	"my-finance-backend/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 200
+)
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+	}
+}
+
+// EnsureIndexes creates the indexes for the feed and for deduplication by key
+func (h *Handler) EnsureIndexes(ctx context.Context) error {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionNotificationsName)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "created_at", Value: -1}}},
+	})
+	return err
+}
+
+// Notify adds a notification to the user's feed unless one with the same key exists, and
+// pushes it to the user's connected clients. It reports whether the notification was added.
+func Notify(ctx context.Context, mongoClient *mongo.Client, config *config.Config, bus *event.Bus, notification *Notification) (bool, error) {
+	notification.CreatedAt = utils.Timestamp()
+	notification.Read = false
+
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionNotificationsName)
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"user_id": notification.UserID, "key": notification.Key},
+		bson.M{"$setOnInsert": notification},
+		options.Update().SetUpsert(true))
+	if err != nil {
+		return false, err
+	}
+	if result.UpsertedID == nil {
+		return false, nil
+	}
+	notification.ID = result.UpsertedID.(primitive.ObjectID).Hex()
+
+	bus.Publish(event.Event{
+		Type:       event.TypeNotificationCreated,
+		UserID:     notification.UserID,
+		EntityType: event.EntityNotification,
+		EntityID:   notification.ID,
+		Data:       notification,
+	})
+	return true, nil
+}
+
+// MarkEntityRead marks the user's notifications about an entity as read, e.g. once a bill is paid
+func MarkEntityRead(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string, entityType string, entityID string) error {
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionNotificationsName)
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"user_id": userID, "entity_type": entityType, "entity_id": entityID, "read": false},
+		bson.M{"$set": bson.M{"read": true}})
+	return err
+}
+
+// HandleGetNotifications returns the user's notification feed, newest first, with the
+// number of unread notifications. unread=true limits the feed to unread ones.
+func (h *Handler) HandleGetNotifications(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	limit := defaultLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxLimit)})
+			return
+		}
+		limit = parsed
+	}
+
+	filter := bson.M{"user_id": userID}
+	if c.Query("unread") == "true" {
+		filter["read"] = false
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionNotificationsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}}).
+		SetLimit(int64(limit)))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch notifications"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	response := GetNotificationsResponse{Notifications: make([]Notification, 0)}
+	if err = cursor.All(ctx, &response.Notifications); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode notifications"})
+		return
+	}
+	response.UnreadCount, err = collection.CountDocuments(ctx, bson.M{"user_id": userID, "read": false})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not count notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Mark notification as read
+func (h *Handler) HandleMarkRead(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionNotificationsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.UpdateOne(ctx, bson.M{"_id": objectId, "user_id": userID}, bson.M{"$set": bson.M{"read": true}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update notification"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification marked as read"})
+}
+
+// Mark all notifications of the user as read
+func (h *Handler) HandleMarkAllRead(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionNotificationsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.UpdateMany(ctx, bson.M{"user_id": userID, "read": false}, bson.M{"$set": bson.M{"read": true}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update notifications"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": result.ModifiedCount})
+}
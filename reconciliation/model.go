@@ -0,0 +1,53 @@
+package reconciliation
+
+import "my-finance-backend/account"
+
+const (
+	StatusOpen      = "open"
+	StatusCompleted = "completed"
+)
+
+// Reconciliation matches the transactions of an account against a bank statement. While
+// open, transactions are ticked off as cleared; completing it locks the cleared
+// transactions so the reconciled balance cannot drift.
+type Reconciliation struct {
+	ID            string `bson:"_id,omitempty" json:"id"`
+	UserID        string `bson:"user_id" json:"user_id"`
+	AccountID     string `bson:"account_id" json:"account_id"`
+	StatementDate string `bson:"statement_date" json:"statement_date"`
+	// StartingBalance is the closing balance of the previous reconciliation of the
+	// account, or its opening balance for the first one
+	StartingBalance float64  `bson:"starting_balance" json:"starting_balance"`
+	ClosingBalance  float64  `bson:"closing_balance" json:"closing_balance"` // as printed on the statement
+	ClearedIDs      []string `bson:"cleared_ids" json:"cleared_ids"`
+	Status          string   `bson:"status" json:"status"`
+	CreatedAt       string   `bson:"created_at" json:"created_at"`
+	CompletedAt     string   `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+}
+
+type CreateReconciliationRequest struct {
+	StatementDate  string   `json:"statement_date" binding:"required"`
+	ClosingBalance *float64 `json:"closing_balance" binding:"required"`
+}
+
+// UpdateClearedRequest ticks transactions off as cleared or takes them back out
+type UpdateClearedRequest struct {
+	Cleared   []string `json:"cleared"`
+	Uncleared []string `json:"uncleared"`
+}
+
+// ReconciliationTransaction is a transaction of the account as seen by a reconciliation
+type ReconciliationTransaction struct {
+	account.Transaction
+	Cleared bool `json:"cleared"`
+}
+
+// ReconciliationSummary is a reconciliation with the transactions it covers. Difference is
+// the statement closing balance minus the cleared balance; the reconciliation can be
+// completed once it is zero.
+type ReconciliationSummary struct {
+	Reconciliation
+	ClearedBalance float64                     `json:"cleared_balance"`
+	Difference     float64                     `json:"difference"`
+	Transactions   []ReconciliationTransaction `json:"transactions"`
+}
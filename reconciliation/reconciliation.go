@@ -0,0 +1,494 @@
+package reconciliation
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"my-finance-backend/account"
+	"my-finance-backend/config"
+	"my-finance-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// balanceTolerance is how far the cleared balance may be from the statement and still match
+const balanceTolerance = 0.005
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+	}
+}
+
+func objectIDs(ids []string) []primitive.ObjectID {
+	objectIDs := make([]primitive.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if objectId, err := utils.StringToObjectId(id); err == nil {
+			objectIDs = append(objectIDs, objectId)
+		}
+	}
+	return objectIDs
+}
+
+// summarize lists the transactions a reconciliation covers and computes its balances.
+// An open reconciliation covers the unreconciled transactions up to the statement date,
+// a completed one the transactions it locked.
+func (h *Handler) summarize(ctx context.Context, userID string, reconciliation Reconciliation) (ReconciliationSummary, error) {
+	summary := ReconciliationSummary{
+		Reconciliation: reconciliation,
+		Transactions:   make([]ReconciliationTransaction, 0),
+	}
+
+	transactions, err := account.Transactions(ctx, h.mongoClient, h.config, userID, reconciliation.AccountID, bson.M{"$lte": reconciliation.StatementDate})
+	if err != nil {
+		return summary, err
+	}
+	cleared := make(map[string]bool, len(reconciliation.ClearedIDs))
+	for _, id := range reconciliation.ClearedIDs {
+		cleared[id] = true
+	}
+
+	balance := reconciliation.StartingBalance
+	for _, transaction := range transactions {
+		if reconciliation.Status == StatusOpen && transaction.Reconciled {
+			continue
+		}
+		if reconciliation.Status == StatusCompleted && !cleared[transaction.ID] {
+			continue
+		}
+		if cleared[transaction.ID] {
+			balance += transaction.Amount
+		}
+		summary.Transactions = append(summary.Transactions, ReconciliationTransaction{
+			Transaction: transaction,
+			Cleared:     cleared[transaction.ID],
+		})
+	}
+	summary.ClearedBalance = utils.RoundAmount(balance)
+	summary.Difference = utils.RoundAmount(reconciliation.ClosingBalance - summary.ClearedBalance)
+	return summary, nil
+}
+
+// findReconciliation loads the reconciliation of the id route parameter, writing the error response on failure
+func (h *Handler) findReconciliation(ctx context.Context, c *gin.Context, userID string) (Reconciliation, bool) {
+	var reconciliation Reconciliation
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reconciliation ID " + error.Error()})
+		return reconciliation, false
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionReconciliationsName)
+	err := collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&reconciliation)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Reconciliation not found"})
+		return reconciliation, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch reconciliation"})
+		return reconciliation, false
+	}
+	return reconciliation, true
+}
+
+// HandleCreateReconciliation starts reconciling an account against a statement. An account
+// has at most one open reconciliation, and statements are reconciled in date order.
+func (h *Handler) HandleCreateReconciliation(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	accountObjectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID " + error.Error()})
+		return
+	}
+
+	var req CreateReconciliationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if _, err := time.Parse("2006-01-02", req.StatementDate); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid statement_date, expected YYYY-MM-DD"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	var acc account.Account
+	err := database.Collection(h.config.CollectionAccountsName).FindOne(ctx, bson.M{"_id": accountObjectId, "user_id": userID}).Decode(&acc)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Account not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch account"})
+		return
+	}
+	if acc.Archived {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Account is archived"})
+		return
+	}
+
+	collection := database.Collection(h.config.CollectionReconciliationsName)
+	count, err := collection.CountDocuments(ctx, bson.M{"user_id": userID, "account_id": acc.ID, "status": StatusOpen})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch reconciliations"})
+		return
+	}
+	if count > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account already has an open reconciliation"})
+		return
+	}
+
+	reconciliation := Reconciliation{
+		UserID:          userID,
+		AccountID:       acc.ID,
+		StatementDate:   req.StatementDate,
+		StartingBalance: acc.OpeningBalance,
+		ClosingBalance:  *req.ClosingBalance,
+		ClearedIDs:      make([]string, 0),
+		Status:          StatusOpen,
+		CreatedAt:       utils.Timestamp(),
+	}
+
+	// Continue from the previous statement
+	var previous Reconciliation
+	err = collection.FindOne(ctx,
+		bson.M{"user_id": userID, "account_id": acc.ID, "status": StatusCompleted},
+		options.FindOne().SetSort(bson.D{{Key: "statement_date", Value: -1}})).Decode(&previous)
+	if err == nil {
+		if req.StatementDate <= previous.StatementDate {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "statement_date must be after the last reconciled statement " + previous.StatementDate})
+			return
+		}
+		reconciliation.StartingBalance = previous.ClosingBalance
+	} else if err != mongo.ErrNoDocuments {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch reconciliations"})
+		return
+	}
+
+	result, err := collection.InsertOne(ctx, reconciliation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create reconciliation"})
+		return
+	}
+	reconciliation.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	summary, err := h.summarize(ctx, userID, reconciliation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch transactions"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, summary)
+}
+
+// Get the reconciliations of an account, latest statement first
+func (h *Handler) HandleGetReconciliations(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	accountObjectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid account ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionReconciliationsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx,
+		bson.M{"user_id": userID, "account_id": accountObjectId.Hex()},
+		options.Find().SetSort(bson.D{{Key: "statement_date", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch reconciliations"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var reconciliations []Reconciliation = make([]Reconciliation, 0)
+	if err = cursor.All(ctx, &reconciliations); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode reconciliations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, reconciliations)
+}
+
+// Get a reconciliation with its transactions, cleared balance and difference
+func (h *Handler) HandleGetReconciliation(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	reconciliation, ok := h.findReconciliation(ctx, c, userID)
+	if !ok {
+		return
+	}
+	summary, err := h.summarize(ctx, userID, reconciliation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// HandleUpdateCleared ticks transactions of an open reconciliation off as cleared, or
+// unticks them
+func (h *Handler) HandleUpdateCleared(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req UpdateClearedRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	reconciliation, ok := h.findReconciliation(ctx, c, userID)
+	if !ok {
+		return
+	}
+	if reconciliation.Status != StatusOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reconciliation is already completed"})
+		return
+	}
+
+	summary, err := h.summarize(ctx, userID, reconciliation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch transactions"})
+		return
+	}
+	candidates := make(map[string]bool, len(summary.Transactions))
+	for _, transaction := range summary.Transactions {
+		candidates[transaction.ID] = true
+	}
+
+	cleared := make(map[string]bool)
+	for _, id := range reconciliation.ClearedIDs {
+		cleared[id] = true
+	}
+	for _, id := range req.Cleared {
+		if !candidates[id] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Transaction is not part of this reconciliation: " + id})
+			return
+		}
+		cleared[id] = true
+	}
+	for _, id := range req.Uncleared {
+		delete(cleared, id)
+	}
+
+	// Keep the ledger order, dropping transactions that no longer exist
+	reconciliation.ClearedIDs = make([]string, 0, len(cleared))
+	for _, transaction := range summary.Transactions {
+		if cleared[transaction.ID] {
+			reconciliation.ClearedIDs = append(reconciliation.ClearedIDs, transaction.ID)
+		}
+	}
+
+	objectId, _ := utils.StringToObjectId(reconciliation.ID)
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionReconciliationsName)
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objectId, "user_id": userID, "status": StatusOpen},
+		bson.M{"$set": bson.M{"cleared_ids": reconciliation.ClearedIDs}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update reconciliation"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reconciliation is already completed"})
+		return
+	}
+
+	summary, err = h.summarize(ctx, userID, reconciliation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// HandleCompleteReconciliation finishes a reconciliation whose cleared balance matches the
+// statement. The cleared transactions are locked against edits and deletion.
+func (h *Handler) HandleCompleteReconciliation(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	reconciliation, ok := h.findReconciliation(ctx, c, userID)
+	if !ok {
+		return
+	}
+	if reconciliation.Status != StatusOpen {
+		c.JSON(http.StatusConflict, gin.H{"error": "Reconciliation is already completed"})
+		return
+	}
+
+	summary, err := h.summarize(ctx, userID, reconciliation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch transactions"})
+		return
+	}
+	if math.Abs(summary.Difference) > balanceTolerance {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Cleared transactions differ from the statement by %.2f", summary.Difference)})
+		return
+	}
+
+	clearedIDs := make([]string, 0, len(summary.Transactions))
+	idsByType := make(map[string][]string)
+	for _, transaction := range summary.Transactions {
+		if transaction.Cleared {
+			clearedIDs = append(clearedIDs, transaction.ID)
+			idsByType[transaction.Type] = append(idsByType[transaction.Type], transaction.ID)
+		}
+	}
+	transferIDs := append(idsByType[account.TransactionTransferIn], idsByType[account.TransactionTransferOut]...)
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	if ids := idsByType[account.TransactionExpense]; len(ids) > 0 {
+		_, err = database.Collection(h.config.CollectionExpensesName).UpdateMany(ctx,
+			utils.NotDeleted(bson.M{"_id": bson.M{"$in": objectIDs(ids)}, "user_id": userID}),
+			utils.Touch(bson.M{"$set": bson.M{"reconciliation_id": reconciliation.ID}}))
+	}
+	if ids := idsByType[account.TransactionIncome]; err == nil && len(ids) > 0 {
+		_, err = database.Collection(h.config.CollectionIncomesName).UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": objectIDs(ids)}, "user_id": userID},
+			bson.M{"$set": bson.M{"reconciliation_id": reconciliation.ID, "updated_at": utils.Timestamp()}})
+	}
+	if err == nil && len(transferIDs) > 0 {
+		_, err = database.Collection(h.config.CollectionTransfersName).UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": objectIDs(transferIDs)}, "user_id": userID},
+			bson.M{"$addToSet": bson.M{"reconciled_account_ids": reconciliation.AccountID}})
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not lock reconciled transactions"})
+		return
+	}
+
+	reconciliation.ClearedIDs = clearedIDs
+	reconciliation.Status = StatusCompleted
+	reconciliation.CompletedAt = utils.Timestamp()
+	objectId, _ := utils.StringToObjectId(reconciliation.ID)
+	_, err = database.Collection(h.config.CollectionReconciliationsName).UpdateOne(ctx,
+		bson.M{"_id": objectId, "user_id": userID},
+		bson.M{"$set": bson.M{
+			"cleared_ids":  reconciliation.ClearedIDs,
+			"status":       reconciliation.Status,
+			"completed_at": reconciliation.CompletedAt,
+		}})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not complete reconciliation"})
+		return
+	}
+
+	summary, err = h.summarize(ctx, userID, reconciliation)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch transactions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, summary)
+}
+
+// HandleDeleteReconciliation discards an open reconciliation or undoes a completed one,
+// unlocking its transactions. Only the latest completed reconciliation of an account can
+// be undone, as later ones start from its closing balance.
+func (h *Handler) HandleDeleteReconciliation(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	reconciliation, ok := h.findReconciliation(ctx, c, userID)
+	if !ok {
+		return
+	}
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	collection := database.Collection(h.config.CollectionReconciliationsName)
+
+	if reconciliation.Status == StatusCompleted {
+		count, err := collection.CountDocuments(ctx, bson.M{
+			"user_id":        userID,
+			"account_id":     reconciliation.AccountID,
+			"statement_date": bson.M{"$gt": reconciliation.StatementDate},
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch reconciliations"})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusConflict, gin.H{"error": "Only the latest reconciliation of an account can be undone"})
+			return
+		}
+
+		_, err = database.Collection(h.config.CollectionExpensesName).UpdateMany(ctx,
+			bson.M{"user_id": userID, "reconciliation_id": reconciliation.ID},
+			utils.Touch(bson.M{"$unset": bson.M{"reconciliation_id": ""}}))
+		if err == nil {
+			_, err = database.Collection(h.config.CollectionIncomesName).UpdateMany(ctx,
+				bson.M{"user_id": userID, "reconciliation_id": reconciliation.ID},
+				bson.M{"$unset": bson.M{"reconciliation_id": ""}, "$set": bson.M{"updated_at": utils.Timestamp()}})
+		}
+		if err == nil {
+			_, err = database.Collection(h.config.CollectionTransfersName).UpdateMany(ctx,
+				bson.M{"_id": bson.M{"$in": objectIDs(reconciliation.ClearedIDs)}, "user_id": userID},
+				bson.M{"$pull": bson.M{"reconciled_account_ids": reconciliation.AccountID}})
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not unlock reconciled transactions"})
+			return
+		}
+	}
+
+	objectId, _ := utils.StringToObjectId(reconciliation.ID)
+	if _, err := collection.DeleteOne(ctx, bson.M{"_id": objectId, "user_id": userID}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete reconciliation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Reconciliation deleted successfully"})
+}
@@ -0,0 +1,336 @@
+package recurring
+
+import (
+	"context"
+	"math"
+	"my-finance-backend/config"
+	"my-finance-backend/utils"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// detectionMonths is how far back expenses are scanned for subscriptions
+	detectionMonths = 26
+	// amountTolerance is how far, relative to the median, a charge may be from the typical amount
+	amountTolerance = 0.2
+	// minRegularity is the share of intervals that must match the cadence
+	minRegularity = 0.75
+)
+
+// cadence describes the interval between charges of a template frequency
+type cadence struct {
+	frequency      string
+	days           float64
+	toleranceDays  float64
+	minOccurrences int
+	perYear        float64
+}
+
+// cadences are ordered from the shortest interval to the longest
+var cadences = []cadence{
+	{FrequencyWeekly, 7, 2, 4, 52},
+	{FrequencyBiweekly, 14, 3, 3, 26},
+	{FrequencyMonthly, 365.25 / 12, 4, 3, 12},
+	{FrequencyQuarterly, 365.25 / 4, 8, 3, 4},
+	{FrequencyYearly, 365.25, 12, 2, 1},
+}
+
+// charge is the part of an expense looked at by subscription detection
+type charge struct {
+	Name         string  `bson:"name"`
+	Amount       float64 `bson:"amount"`
+	CurrencyCode string  `bson:"currency_code"`
+	CategoryID   string  `bson:"category_id"`
+	AccountID    string  `bson:"account_id"`
+	Date         string  `bson:"date"`
+	date         time.Time
+}
+
+// SubscriptionKey normalizes an expense name so that charges such as "Netflix 03/2024" and
+// "NETFLIX" are grouped. Numbers are dropped as they usually identify the billing period.
+func SubscriptionKey(name string) string {
+	var words []string
+	for _, token := range utils.Tokenize(name) {
+		if strings.IndexFunc(token, func(r rune) bool { return r < '0' || r > '9' }) >= 0 {
+			words = append(words, token)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+// sourceKey identifies a subscription among the user's templates
+func sourceKey(key string, currencyCode string) string {
+	return currencyCode + ":" + key
+}
+
+func median(values []float64) float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	middle := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[middle-1] + sorted[middle]) / 2
+	}
+	return sorted[middle]
+}
+
+// analyze returns the subscription formed by the charges of one group, sorted by date,
+// if they recur at a regular interval
+func analyze(key string, charges []charge, today time.Time) (Subscription, bool) {
+	// Leave out charges far from the typical amount, such as a one-off purchase at the same shop
+	amounts := make([]float64, len(charges))
+	for i, charge := range charges {
+		amounts[i] = charge.Amount
+	}
+	typical := median(amounts)
+	kept := make([]charge, 0, len(charges))
+	for _, charge := range charges {
+		if math.Abs(charge.Amount-typical) > typical*amountTolerance {
+			continue
+		}
+		// Charges of the same day count once
+		if len(kept) > 0 && kept[len(kept)-1].Date == charge.Date {
+			continue
+		}
+		kept = append(kept, charge)
+	}
+	if len(kept) < 2 {
+		return Subscription{}, false
+	}
+
+	intervals := make([]float64, len(kept)-1)
+	for i := 1; i < len(kept); i++ {
+		intervals[i-1] = kept[i].date.Sub(kept[i-1].date).Hours() / 24
+	}
+	interval := median(intervals)
+
+	for _, cadence := range cadences {
+		if math.Abs(interval-cadence.days) > cadence.toleranceDays {
+			continue
+		}
+		if len(kept) < cadence.minOccurrences {
+			return Subscription{}, false
+		}
+		regular := 0
+		for _, days := range intervals {
+			if math.Abs(days-cadence.days) <= cadence.toleranceDays {
+				regular++
+			}
+		}
+		regularity := float64(regular) / float64(len(intervals))
+		if regularity < minRegularity {
+			return Subscription{}, false
+		}
+
+		total := 0.0
+		for _, charge := range kept {
+			total += charge.Amount
+		}
+		average := total / float64(len(kept))
+		first := kept[0]
+		last := kept[len(kept)-1]
+		next := NextDate(last.date, cadence.frequency, last.date.Day())
+
+		return Subscription{
+			Key:              key,
+			Name:             last.Name,
+			CurrencyCode:     last.CurrencyCode,
+			Cadence:          cadence.frequency,
+			IntervalDays:     math.Round(interval*10) / 10,
+			Occurrences:      len(kept),
+			Regularity:       math.Round(regularity*100) / 100,
+			AverageAmount:    utils.RoundAmount(average),
+			LastAmount:       last.Amount,
+			AnnualizedCost:   utils.RoundAmount(average * cadence.perYear),
+			FirstChargeDate:  first.Date,
+			LastChargeDate:   last.Date,
+			NextExpectedDate: next.Format("2006-01-02"),
+			Active:           !today.After(next.AddDate(0, 0, int(cadence.toleranceDays))),
+			CategoryID:       last.CategoryID,
+			AccountID:        last.AccountID,
+		}, true
+	}
+	return Subscription{}, false
+}
+
+// scan looks for recurring series among the user's documents of collection dated in the
+// detection window, most expensive first. Series that look cancelled come after the active ones.
+func scan(ctx context.Context, collection *mongo.Collection, filter bson.M, today time.Time) ([]Subscription, error) {
+	filter["date"] = bson.M{"$gte": today.AddDate(0, -detectionMonths, 0).Format("2006-01-02")}
+	cursor, err := collection.Find(ctx, filter, options.Find().
+		SetSort(bson.D{{Key: "date", Value: 1}}).
+		SetProjection(bson.M{"name": 1, "amount": 1, "currency_code": 1, "category_id": 1, "account_id": 1, "date": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var charges []charge
+	if err = cursor.All(ctx, &charges); err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]charge)
+	for _, charge := range charges {
+		key := SubscriptionKey(charge.Name)
+		if key == "" || charge.Amount <= 0 {
+			continue
+		}
+		if charge.date, err = time.Parse("2006-01-02", charge.Date); err != nil {
+			continue
+		}
+		group := sourceKey(key, charge.CurrencyCode)
+		groups[group] = append(groups[group], charge)
+	}
+
+	var subscriptions []Subscription = make([]Subscription, 0)
+	for group, charges := range groups {
+		key := strings.SplitN(group, ":", 2)[1]
+		if subscription, ok := analyze(key, charges, today); ok {
+			subscriptions = append(subscriptions, subscription)
+		}
+	}
+	sort.Slice(subscriptions, func(i, j int) bool {
+		if subscriptions[i].Active != subscriptions[j].Active {
+			return subscriptions[i].Active
+		}
+		if subscriptions[i].AnnualizedCost != subscriptions[j].AnnualizedCost {
+			return subscriptions[i].AnnualizedCost > subscriptions[j].AnnualizedCost
+		}
+		return subscriptions[i].Key < subscriptions[j].Key
+	})
+	return subscriptions, nil
+}
+
+// detect scans the user's recent expenses for subscriptions
+func (h *Handler) detect(ctx context.Context, userID string) ([]Subscription, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+	subscriptions, err := scan(ctx, collection, utils.NotDeleted(bson.M{"user_id": userID}), today)
+	if err != nil {
+		return nil, err
+	}
+
+	// Templates already converted from a subscription
+	templateIDs := make(map[string]string)
+	templateCollection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRecurringTemplatesName)
+	cursor, err := templateCollection.Find(ctx, bson.M{"user_id": userID, "source_key": bson.M{"$exists": true}})
+	if err != nil {
+		return nil, err
+	}
+	var templates []Template
+	if err = cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+	for _, template := range templates {
+		templateIDs[template.SourceKey] = template.ID
+	}
+	for i := range subscriptions {
+		subscriptions[i].TemplateID = templateIDs[sourceKey(subscriptions[i].Key, subscriptions[i].CurrencyCode)]
+	}
+	return subscriptions, nil
+}
+
+// DetectIncomes scans the user's recent incomes for regular ones, such as a salary, the
+// same way expenses are scanned for subscriptions
+func DetectIncomes(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string) ([]Subscription, error) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionIncomesName)
+	return scan(ctx, collection, bson.M{"user_id": userID}, today)
+}
+
+// HandleGetSubscriptions reports the likely subscriptions found in the user's expense history
+func (h *Handler) HandleGetSubscriptions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	subscriptions, err := h.detect(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not detect subscriptions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, subscriptions)
+}
+
+// HandleConvertSubscription creates a recurring template from a detected subscription.
+// The template charges the last amount, starting with the next expected charge.
+func (h *Handler) HandleConvertSubscription(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req ConvertSubscriptionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	subscriptions, err := h.detect(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not detect subscriptions"})
+		return
+	}
+	var subscription *Subscription
+	for i := range subscriptions {
+		if subscriptions[i].Key == req.Key && subscriptions[i].CurrencyCode == req.CurrencyCode {
+			subscription = &subscriptions[i]
+			break
+		}
+	}
+	if subscription == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Subscription not found"})
+		return
+	}
+	if subscription.TemplateID != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Subscription was already converted", "template_id": subscription.TemplateID})
+		return
+	}
+
+	last, _ := time.Parse("2006-01-02", subscription.LastChargeDate)
+	next, _ := time.Parse("2006-01-02", subscription.NextExpectedDate)
+	// A charge missed while the subscription was not tracked is not created retroactively
+	today := time.Now().UTC().Format("2006-01-02")
+	for next.Format("2006-01-02") < today {
+		next = NextDate(next, subscription.Cadence, last.Day())
+	}
+
+	template := Template{
+		UserID:       userID,
+		Name:         subscription.Name,
+		Amount:       subscription.LastAmount,
+		CurrencyCode: subscription.CurrencyCode,
+		CategoryID:   subscription.CategoryID,
+		AccountID:    subscription.AccountID,
+		Frequency:    subscription.Cadence,
+		NextDate:     next.Format("2006-01-02"),
+		DayOfMonth:   last.Day(),
+		Active:       true,
+		SourceKey:    sourceKey(subscription.Key, subscription.CurrencyCode),
+	}
+	if template.CategoryID != "" && h.checkCategory(ctx, userID, template.CategoryID) != nil {
+		template.CategoryID = ""
+	}
+	if err := h.insert(ctx, &template); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create template"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, template)
+}
@@ -0,0 +1,89 @@
+package recurring
+
+const (
+	FrequencyWeekly    = "weekly"
+	FrequencyBiweekly  = "biweekly"
+	FrequencyMonthly   = "monthly"
+	FrequencyQuarterly = "quarterly"
+	FrequencyYearly    = "yearly"
+)
+
+// Template is a recurring expense, such as a subscription or rent. An expense is created
+// from it on every occurrence.
+type Template struct {
+	ID           string  `bson:"_id,omitempty" json:"id"`
+	UserID       string  `bson:"user_id" json:"user_id"`
+	Name         string  `bson:"name" json:"name"`
+	Amount       float64 `bson:"amount" json:"amount"`
+	CurrencyCode string  `bson:"currency_code" json:"currency_code"`
+	CategoryID   string  `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	AccountID    string  `bson:"account_id,omitempty" json:"account_id,omitempty"`
+	Description  string  `bson:"description" json:"description"`
+	Frequency    string  `bson:"frequency" json:"frequency"`
+	NextDate     string  `bson:"next_date" json:"next_date"` // date of the next expense to create
+	// DayOfMonth is the day monthly occurrences fall on, kept when a shorter month moves one earlier
+	DayOfMonth int    `bson:"day_of_month" json:"-"`
+	Active     bool   `bson:"active" json:"active"`
+	EndDate    string `bson:"end_date,omitempty" json:"end_date,omitempty"` // no expenses are created after it
+	// SourceKey is set on templates converted from a detected subscription, see Subscription.Key
+	SourceKey       string `bson:"source_key,omitempty" json:"source_key,omitempty"`
+	LastCreatedDate string `bson:"last_created_date,omitempty" json:"last_created_date,omitempty"`
+	LastExpenseID   string `bson:"last_expense_id,omitempty" json:"last_expense_id,omitempty"`
+	CreatedAt       string `bson:"created_at" json:"created_at"`
+	UpdatedAt       string `bson:"updated_at,omitempty" json:"updated_at,omitempty"`
+}
+
+type CreateTemplateRequest struct {
+	Name         string  `json:"name" binding:"required"`
+	Amount       float64 `json:"amount" binding:"required"`
+	CurrencyCode string  `json:"currency_code" binding:"required"`
+	CategoryID   string  `json:"category_id"`
+	AccountID    string  `json:"account_id"`
+	Description  string  `json:"description"`
+	Frequency    string  `json:"frequency"` // defaults to monthly
+	NextDate     string  `json:"next_date" binding:"required"`
+	EndDate      string  `json:"end_date"`
+}
+
+type UpdateTemplateRequest struct {
+	Name        string  `json:"name"`
+	Amount      float64 `json:"amount"`
+	CategoryID  *string `json:"category_id"` // an empty string removes the category
+	AccountID   *string `json:"account_id"`  // an empty string unlinks the account
+	Description *string `json:"description"`
+	Frequency   string  `json:"frequency"`
+	NextDate    string  `json:"next_date"`
+	EndDate     *string `json:"end_date"` // an empty string removes the end date
+	Active      *bool   `json:"active"`
+}
+
+// Subscription is a series of expenses that look like a recurring charge: the same
+// normalized name and currency, a similar amount and a regular interval.
+type Subscription struct {
+	// Key is the normalized name the charges were grouped by; with the currency it
+	// identifies the subscription for ConvertSubscriptionRequest
+	Key          string  `json:"key"`
+	Name         string  `json:"name"` // name of the last charge
+	CurrencyCode string  `json:"currency_code"`
+	Cadence      string  `json:"cadence"`       // one of the template frequencies
+	IntervalDays float64 `json:"interval_days"` // median number of days between charges
+	Occurrences  int     `json:"occurrences"`
+	// Regularity is the share of intervals that match the cadence, from 0 to 1
+	Regularity       float64 `json:"regularity"`
+	AverageAmount    float64 `json:"average_amount"`
+	LastAmount       float64 `json:"last_amount"`
+	AnnualizedCost   float64 `json:"annualized_cost"`
+	FirstChargeDate  string  `json:"first_charge_date"`
+	LastChargeDate   string  `json:"last_charge_date"`
+	NextExpectedDate string  `json:"next_expected_date"`
+	// Active is false when the next expected charge is overdue, e.g. after a cancellation
+	Active     bool   `json:"active"`
+	CategoryID string `json:"category_id,omitempty"`
+	AccountID  string `json:"account_id,omitempty"`
+	TemplateID string `json:"template_id,omitempty"` // set once converted into a template
+}
+
+type ConvertSubscriptionRequest struct {
+	Key          string `json:"key" binding:"required"`
+	CurrencyCode string `json:"currency_code" binding:"required"`
+}
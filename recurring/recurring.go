@@ -0,0 +1,542 @@
+package recurring
+
+import (
+	"context"
+	"errors"
+	"log"
+	"my-finance-backend/account"
+	"my-finance-backend/config"
+	"my-finance-backend/expense"
+	"my-finance-backend/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// generateInterval is how often due templates are turned into expenses
+	generateInterval = time.Hour
+	// maxCatchUp caps how many missed occurrences of a template are created in one run
+	maxCatchUp = 12
+)
+
+var frequencies = map[string]bool{
+	FrequencyWeekly:    true,
+	FrequencyBiweekly:  true,
+	FrequencyMonthly:   true,
+	FrequencyQuarterly: true,
+	FrequencyYearly:    true,
+}
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+	expenses    *expense.Handler
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config, expenses *expense.Handler) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+		expenses:    expenses,
+	}
+}
+
+// NextDate returns the occurrence following date for a template frequency
+func NextDate(date time.Time, frequency string, dayOfMonth int) time.Time {
+	switch frequency {
+	case FrequencyWeekly:
+		return date.AddDate(0, 0, 7)
+	case FrequencyBiweekly:
+		return date.AddDate(0, 0, 14)
+	case FrequencyQuarterly:
+		return utils.AddMonths(date, 3, dayOfMonth)
+	case FrequencyYearly:
+		return utils.AddMonths(date, 12, dayOfMonth)
+	default:
+		return utils.AddMonths(date, 1, dayOfMonth)
+	}
+}
+
+// Load returns the user's active templates
+func Load(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string) ([]Template, error) {
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionRecurringTemplatesName)
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID, "active": true}, options.Find().SetSort(bson.D{{Key: "next_date", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	templates := make([]Template, 0)
+	if err = cursor.All(ctx, &templates); err != nil {
+		return nil, err
+	}
+	return templates, nil
+}
+
+// checkCategory verifies that a category exists and belongs to the user
+func (h *Handler) checkCategory(ctx context.Context, userID string, categoryID string) error {
+	objectId, err := utils.StringToObjectId(categoryID)
+	if err != nil {
+		return errors.New("Invalid category ID")
+	}
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
+	count, err := collection.CountDocuments(ctx, utils.NotDeleted(bson.M{"_id": objectId, "user_id": userID}))
+	if err != nil {
+		return errors.New("Could not fetch category")
+	}
+	if count == 0 {
+		return errors.New("Category not found")
+	}
+	return nil
+}
+
+// findTemplate loads the template of the id route parameter, writing the error response on failure
+func (h *Handler) findTemplate(ctx context.Context, c *gin.Context, userID string) (Template, bool) {
+	var template Template
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID " + error.Error()})
+		return template, false
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRecurringTemplatesName)
+	err := collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&template)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return template, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch template"})
+		return template, false
+	}
+	return template, true
+}
+
+// Generate creates the expenses of every active template whose next date has come,
+// catching up on missed occurrences. It returns the number of expenses created.
+func (h *Handler) Generate(ctx context.Context) (int, error) {
+	today := time.Now().UTC().Format("2006-01-02")
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRecurringTemplatesName)
+	cursor, err := collection.Find(ctx, bson.M{"active": true, "next_date": bson.M{"$lte": today}})
+	if err != nil {
+		return 0, err
+	}
+	var templates []Template
+	if err = cursor.All(ctx, &templates); err != nil {
+		return 0, err
+	}
+
+	created := 0
+	for _, template := range templates {
+		for i := 0; i < maxCatchUp && template.Active && template.NextDate <= today; i++ {
+			ok, err := h.createOccurrence(ctx, &template)
+			if err != nil {
+				return created, err
+			}
+			if !ok {
+				break
+			}
+			created++
+		}
+	}
+	return created, nil
+}
+
+// createOccurrence creates the expense of the template's next date and moves the template
+// on to the following one. It reports false when the occurrence was claimed elsewhere.
+func (h *Handler) createOccurrence(ctx context.Context, template *Template) (bool, error) {
+	date, err := time.Parse("2006-01-02", template.NextDate)
+	if err != nil {
+		return false, err
+	}
+
+	// Claim the occurrence before creating it so that it is created only once
+	update := bson.M{
+		"next_date":         NextDate(date, template.Frequency, template.DayOfMonth).Format("2006-01-02"),
+		"last_created_date": template.NextDate,
+		"updated_at":        utils.Timestamp(),
+	}
+	if template.EndDate != "" && update["next_date"].(string) > template.EndDate {
+		update["active"] = false
+	}
+	objectId, _ := utils.StringToObjectId(template.ID)
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRecurringTemplatesName)
+	result, err := collection.UpdateOne(ctx,
+		bson.M{"_id": objectId, "next_date": template.NextDate, "active": true},
+		bson.M{"$set": update})
+	if err != nil {
+		return false, err
+	}
+	if result.MatchedCount == 0 {
+		return false, nil
+	}
+
+	created := expense.Expense{
+		UserID:       template.UserID,
+		CategoryID:   template.CategoryID,
+		AccountID:    template.AccountID,
+		Amount:       template.Amount,
+		CurrencyCode: template.CurrencyCode,
+		Name:         template.Name,
+		Description:  template.Description,
+		Date:         template.NextDate,
+	}
+	// The category or account may have been deleted since the template was set up
+	if created.CategoryID != "" && h.checkCategory(ctx, created.UserID, created.CategoryID) != nil {
+		created.CategoryID = ""
+	}
+	if created.AccountID != "" && account.Check(ctx, h.mongoClient, h.config, created.UserID, created.AccountID, created.CurrencyCode) != nil {
+		created.AccountID = ""
+	}
+	if err := h.expenses.Insert(ctx, nil, &created); err != nil {
+		// Give the occurrence back, unless the template changed again since it was claimed
+		claimed := bson.M{"_id": objectId, "next_date": update["next_date"], "last_created_date": template.NextDate}
+		if active, ok := update["active"]; ok {
+			claimed["active"] = active
+		}
+		set := bson.M{"next_date": template.NextDate, "active": true, "updated_at": utils.Timestamp()}
+		restore := bson.M{"$set": set}
+		if template.LastCreatedDate == "" {
+			restore["$unset"] = bson.M{"last_created_date": ""}
+		} else {
+			set["last_created_date"] = template.LastCreatedDate
+		}
+		if _, err := collection.UpdateOne(ctx, claimed, restore); err != nil {
+			log.Printf("Could not restore the next date of template %s: %v\n", template.ID, err)
+		}
+		return false, err
+	}
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": objectId}, bson.M{"$set": bson.M{"last_expense_id": created.ID}}); err != nil {
+		log.Printf("Could not link template %s to expense %s: %v\n", template.ID, created.ID, err)
+	}
+
+	template.LastCreatedDate = template.NextDate
+	template.LastExpenseID = created.ID
+	template.NextDate = update["next_date"].(string)
+	if _, ok := update["active"]; ok {
+		template.Active = false
+	}
+	return true, nil
+}
+
+// StartGenerator runs Generate periodically until ctx is cancelled
+func (h *Handler) StartGenerator(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(generateInterval)
+		defer ticker.Stop()
+
+		for {
+			generateCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+			if _, err := h.Generate(generateCtx); err != nil {
+				log.Printf("Could not create recurring expenses: %v\n", err)
+			}
+			cancel()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Create recurring template
+func (h *Handler) HandleCreateTemplate(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Amount <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if req.Frequency == "" {
+		req.Frequency = FrequencyMonthly
+	}
+	if !frequencies[req.Frequency] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "frequency must be weekly, biweekly, monthly, quarterly or yearly"})
+		return
+	}
+	next, err := time.Parse("2006-01-02", req.NextDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid next_date, expected YYYY-MM-DD"})
+		return
+	}
+	if req.EndDate != "" {
+		if _, err := time.Parse("2006-01-02", req.EndDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+			return
+		}
+		if req.EndDate < req.NextDate {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must not be before next_date"})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if req.CategoryID != "" {
+		if err := h.checkCategory(ctx, userID, req.CategoryID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.AccountID != "" {
+		if err := account.Check(ctx, h.mongoClient, h.config, userID, req.AccountID, req.CurrencyCode); err != nil {
+			c.JSON(account.CheckStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	template := Template{
+		UserID:       userID,
+		Name:         strings.TrimSpace(req.Name),
+		Amount:       req.Amount,
+		CurrencyCode: req.CurrencyCode,
+		CategoryID:   req.CategoryID,
+		AccountID:    req.AccountID,
+		Description:  req.Description,
+		Frequency:    req.Frequency,
+		NextDate:     req.NextDate,
+		DayOfMonth:   next.Day(),
+		Active:       true,
+		EndDate:      req.EndDate,
+	}
+	if err := h.insert(ctx, &template); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create template"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, template)
+}
+
+// insert stores a new template and sets its ID
+func (h *Handler) insert(ctx context.Context, template *Template) error {
+	template.CreatedAt = utils.Timestamp()
+	template.UpdatedAt = template.CreatedAt
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRecurringTemplatesName)
+	result, err := collection.InsertOne(ctx, template)
+	if err != nil {
+		return err
+	}
+	template.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	return nil
+}
+
+// Get the user's recurring templates, next due first. Inactive templates are only included with active=false.
+func (h *Handler) HandleGetTemplates(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	filter := bson.M{"user_id": userID}
+	if c.Query("active") != "false" {
+		filter["active"] = true
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRecurringTemplatesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "next_date", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch templates"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var templates []Template = make([]Template, 0)
+	if err = cursor.All(ctx, &templates); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode templates"})
+		return
+	}
+
+	c.JSON(http.StatusOK, templates)
+}
+
+// Get single recurring template
+func (h *Handler) HandleGetTemplate(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	template, ok := h.findTemplate(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+// Update recurring template
+func (h *Handler) HandleUpdateTemplate(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req UpdateTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	template, ok := h.findTemplate(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	update := bson.M{}
+	unset := bson.M{}
+	if name := strings.TrimSpace(req.Name); name != "" {
+		template.Name = name
+		update["name"] = name
+	}
+	if req.Amount > 0 {
+		template.Amount = req.Amount
+		update["amount"] = req.Amount
+	}
+	if req.CategoryID != nil {
+		template.CategoryID = *req.CategoryID
+		if template.CategoryID == "" {
+			unset["category_id"] = ""
+		} else if err := h.checkCategory(ctx, userID, template.CategoryID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		} else {
+			update["category_id"] = template.CategoryID
+		}
+	}
+	if req.AccountID != nil {
+		template.AccountID = *req.AccountID
+		if template.AccountID == "" {
+			unset["account_id"] = ""
+		} else if err := account.Check(ctx, h.mongoClient, h.config, userID, template.AccountID, template.CurrencyCode); err != nil {
+			c.JSON(account.CheckStatus(err), gin.H{"error": err.Error()})
+			return
+		} else {
+			update["account_id"] = template.AccountID
+		}
+	}
+	if req.Description != nil {
+		template.Description = *req.Description
+		update["description"] = template.Description
+	}
+	if req.Frequency != "" {
+		if !frequencies[req.Frequency] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "frequency must be weekly, biweekly, monthly, quarterly or yearly"})
+			return
+		}
+		template.Frequency = req.Frequency
+		update["frequency"] = req.Frequency
+	}
+	if req.NextDate != "" {
+		next, err := time.Parse("2006-01-02", req.NextDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid next_date, expected YYYY-MM-DD"})
+			return
+		}
+		template.NextDate = req.NextDate
+		template.DayOfMonth = next.Day()
+		update["next_date"] = template.NextDate
+		update["day_of_month"] = template.DayOfMonth
+	}
+	if req.EndDate != nil {
+		template.EndDate = *req.EndDate
+		if template.EndDate == "" {
+			unset["end_date"] = ""
+		} else if _, err := time.Parse("2006-01-02", template.EndDate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date, expected YYYY-MM-DD"})
+			return
+		} else {
+			update["end_date"] = template.EndDate
+		}
+	}
+	if template.EndDate != "" && template.EndDate < template.NextDate {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must not be before next_date"})
+		return
+	}
+	if req.Active != nil {
+		template.Active = *req.Active
+		update["active"] = template.Active
+	}
+	template.UpdatedAt = utils.Timestamp()
+	update["updated_at"] = template.UpdatedAt
+
+	updateDocument := bson.M{"$set": update}
+	if len(unset) > 0 {
+		updateDocument["$unset"] = unset
+	}
+	objectId, _ := utils.StringToObjectId(template.ID)
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRecurringTemplatesName)
+	if _, err := collection.UpdateOne(ctx, bson.M{"_id": objectId, "user_id": userID}, updateDocument); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update template"})
+		return
+	}
+
+	c.JSON(http.StatusOK, template)
+}
+
+// Delete recurring template. Expenses already created from it are kept.
+func (h *Handler) HandleDeleteTemplate(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRecurringTemplatesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectId, "user_id": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete template"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Template not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Template deleted successfully"})
+}
@@ -0,0 +1,74 @@
+package rule
+
+import "regexp"
+
+const (
+	FieldName        = "name"
+	FieldDescription = "description"
+	FieldAny         = "any"
+
+	MatchContains = "contains"
+	MatchRegex    = "regex"
+)
+
+// Rule assigns a category and tags to expenses matching all of its conditions.
+// Rules are evaluated in ascending priority order and the first match wins.
+type Rule struct {
+	ID           string   `bson:"_id,omitempty" json:"id"`
+	UserID       string   `bson:"user_id" json:"user_id"`
+	Name         string   `bson:"name" json:"name"`
+	Priority     int      `bson:"priority" json:"priority"`
+	Enabled      bool     `bson:"enabled" json:"enabled"`
+	Field        string   `bson:"field,omitempty" json:"field,omitempty"`           // name, description or any
+	MatchType    string   `bson:"match_type,omitempty" json:"match_type,omitempty"` // contains or regex
+	Pattern      string   `bson:"pattern,omitempty" json:"pattern,omitempty"`
+	AmountMin    *float64 `bson:"amount_min,omitempty" json:"amount_min,omitempty"`
+	AmountMax    *float64 `bson:"amount_max,omitempty" json:"amount_max,omitempty"`
+	CurrencyCode string   `bson:"currency_code,omitempty" json:"currency_code,omitempty"`
+	CategoryID   string   `bson:"category_id,omitempty" json:"category_id,omitempty"`
+	TagIDs       []string `bson:"tag_ids,omitempty" json:"tag_ids,omitempty"`
+
+	compiled *regexp.Regexp
+}
+
+type CreateRuleRequest struct {
+	Name         string   `json:"name" binding:"required"`
+	Priority     int      `json:"priority"`
+	Enabled      *bool    `json:"enabled"`
+	Field        string   `json:"field"`
+	MatchType    string   `json:"match_type"`
+	Pattern      string   `json:"pattern"`
+	AmountMin    *float64 `json:"amount_min"`
+	AmountMax    *float64 `json:"amount_max"`
+	CurrencyCode string   `json:"currency_code"`
+	CategoryID   string   `json:"category_id"`
+	TagIDs       []string `json:"tag_ids"`
+}
+
+type UpdateRuleRequest struct {
+	Name         string   `json:"name"`
+	Priority     *int     `json:"priority"`
+	Enabled      *bool    `json:"enabled"`
+	Field        string   `json:"field"`
+	MatchType    string   `json:"match_type"`
+	Pattern      *string  `json:"pattern"`
+	AmountMin    *float64 `json:"amount_min"`
+	AmountMax    *float64 `json:"amount_max"`
+	CurrencyCode *string  `json:"currency_code"`
+	CategoryID   *string  `json:"category_id"`
+	TagIDs       []string `json:"tag_ids"`
+}
+
+// Target is the part of an expense that rules are matched against and that they modify
+type Target struct {
+	Name         string
+	Description  string
+	Amount       float64
+	CurrencyCode string
+	CategoryID   string
+	TagIDs       []string
+}
+
+type ReorderRulesRequest struct {
+	RuleIDs []string `json:"rule_ids" binding:"required"`
+}
@@ -0,0 +1,514 @@
+package rule
+
+import (
+	"context"
+	"errors"
+	"my-finance-backend/category"
+	"my-finance-backend/config"
+	"my-finance-backend/utils"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+	}
+}
+
+// LoadRules returns the enabled rules of a user in evaluation order, with their patterns compiled.
+// Rules pointing at a category that was deleted since keep their tags but no longer set a category.
+func LoadRules(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string) ([]Rule, error) {
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionRulesName)
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "priority", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID, "enabled": true}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var rules []Rule = make([]Rule, 0)
+	if err = cursor.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+
+	// Drop the categories that no longer exist
+	objectIds := make(bson.A, 0, len(rules))
+	for _, rule := range rules {
+		if objectId, err := utils.StringToObjectId(rule.CategoryID); rule.CategoryID != "" && err == nil {
+			objectIds = append(objectIds, objectId)
+		}
+	}
+	active := make(map[string]bool)
+	if len(objectIds) > 0 {
+		categoryCollection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionCategoriesName)
+		categoryCursor, err := categoryCollection.Find(ctx,
+			utils.NotDeleted(bson.M{"_id": bson.M{"$in": objectIds}, "user_id": userID}),
+			options.Find().SetProjection(bson.M{"_id": 1}))
+		if err != nil {
+			return nil, err
+		}
+		var categories []category.Category
+		if err = categoryCursor.All(ctx, &categories); err != nil {
+			return nil, err
+		}
+		for _, item := range categories {
+			active[item.ID] = true
+		}
+	}
+	for i := range rules {
+		if !active[rules[i].CategoryID] {
+			rules[i].CategoryID = ""
+		}
+	}
+	return rules, nil
+}
+
+// Apply runs rules against target and applies the first matching rule.
+// The category is only replaced when target has none, unless overwriteCategory is set.
+// Tags of the matching rule are merged into the target's tags.
+// It returns the applied rule, or nil if nothing matched.
+func Apply(rules []Rule, target *Target, overwriteCategory bool) *Rule {
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.Matches(target) {
+			continue
+		}
+
+		if rule.CategoryID != "" && (target.CategoryID == "" || overwriteCategory) {
+			target.CategoryID = rule.CategoryID
+		}
+		target.TagIDs = mergeTags(target.TagIDs, rule.TagIDs)
+		return rule
+	}
+	return nil
+}
+
+// Matches reports whether all conditions of the rule hold for target
+func (r *Rule) Matches(target *Target) bool {
+	if r.Pattern != "" {
+		var candidates []string
+		switch r.Field {
+		case FieldName:
+			candidates = []string{target.Name}
+		case FieldDescription:
+			candidates = []string{target.Description}
+		default:
+			candidates = []string{target.Name, target.Description}
+		}
+
+		matched := false
+		for _, candidate := range candidates {
+			if r.matchText(candidate) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+
+	if r.AmountMin != nil && target.Amount < *r.AmountMin {
+		return false
+	}
+	if r.AmountMax != nil && target.Amount > *r.AmountMax {
+		return false
+	}
+	if r.CurrencyCode != "" && !strings.EqualFold(r.CurrencyCode, target.CurrencyCode) {
+		return false
+	}
+	return true
+}
+
+func (r *Rule) matchText(text string) bool {
+	if r.MatchType == MatchRegex {
+		if r.compiled == nil && r.compile() != nil {
+			return false
+		}
+		return r.compiled.MatchString(text)
+	}
+	return strings.Contains(strings.ToLower(text), strings.ToLower(r.Pattern))
+}
+
+func (r *Rule) compile() error {
+	if r.MatchType != MatchRegex || r.Pattern == "" {
+		return nil
+	}
+	compiled, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return err
+	}
+	r.compiled = compiled
+	return nil
+}
+
+// validate checks the rule definition and returns a user facing error message
+func (r *Rule) validate() error {
+	if strings.TrimSpace(r.Name) == "" {
+		return errors.New("Rule name is required")
+	}
+	switch r.Field {
+	case "", FieldName, FieldDescription, FieldAny:
+	default:
+		return errors.New("Field must be one of name, description or any")
+	}
+	switch r.MatchType {
+	case "", MatchContains, MatchRegex:
+	default:
+		return errors.New("Match type must be contains or regex")
+	}
+	if err := r.compile(); err != nil {
+		return errors.New("Invalid regular expression: " + err.Error())
+	}
+	if r.AmountMin != nil && r.AmountMax != nil && *r.AmountMin > *r.AmountMax {
+		return errors.New("amount_min must not be greater than amount_max")
+	}
+	if r.Pattern == "" && r.AmountMin == nil && r.AmountMax == nil && r.CurrencyCode == "" {
+		return errors.New("Rule must have at least one condition")
+	}
+	if r.CategoryID == "" && len(r.TagIDs) == 0 {
+		return errors.New("Rule must assign a category or tags")
+	}
+	return nil
+}
+
+// checkCategory verifies that the category exists and belongs to the user
+func (h *Handler) checkCategory(ctx context.Context, userID string, categoryID string) (int, string) {
+	categoryObjectId, err := utils.StringToObjectId(categoryID)
+	if err != nil {
+		return http.StatusBadRequest, "Invalid category ID"
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
+	var existing category.Category
+	err = collection.FindOne(ctx, utils.NotDeleted(bson.M{"_id": categoryObjectId, "user_id": userID})).Decode(&existing)
+	if err == mongo.ErrNoDocuments {
+		return http.StatusNotFound, "Category not found"
+	} else if err != nil {
+		return http.StatusInternalServerError, "Could not fetch category"
+	}
+	return 0, ""
+}
+
+// Create rule
+func (h *Handler) HandleCreateRule(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	rule := Rule{
+		UserID:       userID,
+		Name:         strings.TrimSpace(req.Name),
+		Priority:     req.Priority,
+		Enabled:      req.Enabled == nil || *req.Enabled,
+		Field:        req.Field,
+		MatchType:    req.MatchType,
+		Pattern:      req.Pattern,
+		AmountMin:    req.AmountMin,
+		AmountMax:    req.AmountMax,
+		CurrencyCode: req.CurrencyCode,
+		CategoryID:   req.CategoryID,
+		TagIDs:       req.TagIDs,
+	}
+	if rule.Field == "" {
+		rule.Field = FieldAny
+	}
+	if rule.MatchType == "" {
+		rule.MatchType = MatchContains
+	}
+
+	if err := rule.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if rule.CategoryID != "" {
+		if status, message := h.checkCategory(ctx, userID, rule.CategoryID); status != 0 {
+			c.JSON(status, gin.H{"error": message})
+			return
+		}
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRulesName)
+	result, err := collection.InsertOne(ctx, rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create rule"})
+		return
+	}
+	rule.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+// Get all rules for a user, in evaluation order
+func (h *Handler) HandleGetRules(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRulesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	findOptions := options.Find().SetSort(bson.D{{Key: "priority", Value: 1}, {Key: "_id", Value: 1}})
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID}, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch rules"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var rules []Rule = make([]Rule, 0)
+	if err = cursor.All(ctx, &rules); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rules)
+}
+
+// Get single rule
+func (h *Handler) HandleGetRule(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRulesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var rule Rule
+	err := collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&rule)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// Update rule
+func (h *Handler) HandleUpdateRule(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID " + error.Error()})
+		return
+	}
+
+	var req UpdateRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRulesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var rule Rule
+	err := collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&rule)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch rule"})
+		return
+	}
+
+	// Apply the changes on top of the stored rule so the result can be validated as a whole
+	if req.Name != "" {
+		rule.Name = strings.TrimSpace(req.Name)
+	}
+	if req.Priority != nil {
+		rule.Priority = *req.Priority
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.Field != "" {
+		rule.Field = req.Field
+	}
+	if req.MatchType != "" {
+		rule.MatchType = req.MatchType
+	}
+	if req.Pattern != nil {
+		rule.Pattern = *req.Pattern
+	}
+	if req.AmountMin != nil {
+		rule.AmountMin = req.AmountMin
+	}
+	if req.AmountMax != nil {
+		rule.AmountMax = req.AmountMax
+	}
+	if req.CurrencyCode != nil {
+		rule.CurrencyCode = *req.CurrencyCode
+	}
+	if req.CategoryID != nil {
+		rule.CategoryID = *req.CategoryID
+	}
+	if req.TagIDs != nil {
+		rule.TagIDs = req.TagIDs
+	}
+
+	if err := rule.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.CategoryID != nil && rule.CategoryID != "" {
+		if status, message := h.checkCategory(ctx, userID, rule.CategoryID); status != 0 {
+			c.JSON(status, gin.H{"error": message})
+			return
+		}
+	}
+
+	rule.ID = ""
+	result, err := collection.ReplaceOne(ctx, bson.M{"_id": objectId, "user_id": userID}, rule)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update rule"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+	rule.ID = objectId.Hex()
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// Delete rule
+func (h *Handler) HandleDeleteRule(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRulesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectId, "user_id": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete rule"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Rule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rule deleted successfully"})
+}
+
+// HandleReorderRules assigns priorities following the order of the given rule IDs
+func (h *Handler) HandleReorderRules(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req ReorderRulesRequest
+	if err := c.ShouldBindJSON(&req); err != nil || len(req.RuleIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionRulesName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	models := make([]mongo.WriteModel, 0, len(req.RuleIDs))
+	for index, ruleID := range req.RuleIDs {
+		objectId, err := utils.StringToObjectId(ruleID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID " + ruleID})
+			return
+		}
+		models = append(models, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": objectId, "user_id": userID}).
+			SetUpdate(bson.M{"$set": bson.M{"priority": index}}))
+	}
+
+	if _, err := collection.BulkWrite(ctx, models); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not reorder rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Rules reordered successfully"})
+}
+
+func mergeTags(existing []string, added []string) []string {
+	for _, tagID := range added {
+		found := false
+		for _, current := range existing {
+			if current == tagID {
+				found = true
+				break
+			}
+		}
+		if !found {
+			existing = append(existing, tagID)
+		}
+	}
+	return existing
+}
@@ -0,0 +1,71 @@
+package split
+
+// Settlement is a payment between two users that reduces what one owes the other
+type Settlement struct {
+	ID           string  `bson:"_id,omitempty" json:"id"`
+	FromUserID   string  `bson:"from_user_id" json:"from_user_id"` // the user who paid
+	ToUserID     string  `bson:"to_user_id" json:"to_user_id"`
+	Amount       float64 `bson:"amount" json:"amount"`
+	CurrencyCode string  `bson:"currency_code" json:"currency_code"`
+	Date         string  `bson:"date" json:"date"`
+	Note         string  `bson:"note,omitempty" json:"note,omitempty"`
+	CreatedBy    string  `bson:"created_by" json:"created_by"`
+	CreatedAt    string  `bson:"created_at" json:"created_at"`
+}
+
+// CreateSettlementRequest records a payment received by the user from FromUserID.
+// ToUserID, if given, must be the user. Without an amount, the whole balance between
+// the two users in the currency is settled.
+type CreateSettlementRequest struct {
+	FromUserID   string  `json:"from_user_id" binding:"required"`
+	ToUserID     string  `json:"to_user_id"`
+	Amount       float64 `json:"amount"`
+	CurrencyCode string  `json:"currency_code" binding:"required"`
+	Date         string  `json:"date"`
+	Note         string  `json:"note"`
+}
+
+// Member is a user sharing split expenses with the current user
+type Member struct {
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	Email  string `json:"email"`
+}
+
+// Balance is the net amount between the current user and another user in one currency.
+// A positive amount is owed to the current user, a negative one is owed by them.
+type Balance struct {
+	Member
+	CurrencyCode string  `json:"currency_code"`
+	Amount       float64 `json:"amount"`
+}
+
+// Transfer is one payment of a settle-up plan
+type Transfer struct {
+	FromUserID   string  `json:"from_user_id"`
+	ToUserID     string  `json:"to_user_id"`
+	Amount       float64 `json:"amount"`
+	CurrencyCode string  `json:"currency_code"`
+}
+
+// PendingShare is a share of another user's split expense waiting for the current user to accept it
+type PendingShare struct {
+	ExpenseID    string  `json:"expense_id"`
+	Owner        Member  `json:"owner"` // the user who paid the expense
+	Name         string  `json:"name"`
+	Date         string  `json:"date"`
+	Amount       float64 `json:"amount"`
+	CurrencyCode string  `json:"currency_code"`
+	ShareAmount  float64 `json:"share_amount"`
+}
+
+type GetBalancesResponse struct {
+	Balances []Balance `json:"balances"`
+}
+
+// GetSettleUpResponse holds the fewest transfers found that bring the user and their
+// counterparties to zero, per currency
+type GetSettleUpResponse struct {
+	Members   []Member   `json:"members"`
+	Transfers []Transfer `json:"transfers"`
+}
@@ -0,0 +1,629 @@
+package split
+
+import (
+	"context"
+	"math"
+	"my-finance-backend/authentication"
+	"my-finance-backend/config"
+	"my-finance-backend/expense"
+	"my-finance-backend/utils"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+	}
+}
+
+// group is the user and their counterparties: the users sharing split expenses with them
+// that both sides agreed to, with those expenses and the settlements between them.
+// Expenses keep only the shares between the user and a counterparty, so that what other
+// members owe each other is not exposed.
+type group struct {
+	userIDs     []string
+	expenses    []expense.Expense
+	settlements []Settlement
+}
+
+// loadGroup collects the split expenses paid by userID or accepted by them, and their
+// direct counterparties. Pending shares are left out.
+func (h *Handler) loadGroup(ctx context.Context, userID string) (group, error) {
+	var result group
+	database := h.mongoClient.Database(h.config.DatabaseName)
+
+	filter := utils.NotDeleted(bson.M{
+		"split": bson.M{"$exists": true},
+		"$or": bson.A{
+			bson.M{"user_id": userID},
+			bson.M{"split.shares": bson.M{"$elemMatch": bson.M{"user_id": userID, "pending": bson.M{"$ne": true}}}},
+		},
+	})
+	cursor, err := database.Collection(h.config.CollectionExpensesName).Find(ctx, filter)
+	if err != nil {
+		return result, err
+	}
+	var expenses []expense.Expense
+	if err = cursor.All(ctx, &expenses); err != nil {
+		return result, err
+	}
+
+	members := map[string]bool{userID: true}
+	for _, item := range expenses {
+		if item.Split == nil {
+			continue
+		}
+		shares := make([]expense.Share, 0, len(item.Split.Shares))
+		for _, share := range item.Split.Shares {
+			if share.Pending || share.UserID == "" || (item.UserID != userID && share.UserID != userID) {
+				continue
+			}
+			shares = append(shares, share)
+			members[share.UserID] = true
+		}
+		members[item.UserID] = true
+		item.Split.Shares = shares
+		result.expenses = append(result.expenses, item)
+	}
+
+	for member := range members {
+		result.userIDs = append(result.userIDs, member)
+	}
+	sort.Strings(result.userIDs)
+
+	cursor, err = database.Collection(h.config.CollectionSettlementsName).Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"from_user_id": userID, "to_user_id": bson.M{"$in": result.userIDs}},
+		bson.M{"to_user_id": userID, "from_user_id": bson.M{"$in": result.userIDs}},
+	}})
+	if err != nil {
+		return result, err
+	}
+	if err = cursor.All(ctx, &result.settlements); err != nil {
+		return result, err
+	}
+	return result, nil
+}
+
+// isMember reports whether userID belongs to the group
+func (g group) isMember(userID string) bool {
+	index := sort.SearchStrings(g.userIDs, userID)
+	return index < len(g.userIDs) && g.userIDs[index] == userID
+}
+
+// debtKey identifies what Debtor owes Creditor in one currency
+type debtKey struct {
+	Debtor       string
+	Creditor     string
+	CurrencyCode string
+}
+
+// debts returns what each member owes each other member, netted in both directions:
+// only positive amounts are kept
+func (g group) debts() map[debtKey]float64 {
+	owed := make(map[debtKey]float64)
+	add := func(debtor string, creditor string, currencyCode string, amount float64) {
+		if debtor == creditor {
+			return
+		}
+		reverse := debtKey{Debtor: creditor, Creditor: debtor, CurrencyCode: currencyCode}
+		if owed[reverse] > 0 {
+			amount -= owed[reverse]
+			delete(owed, reverse)
+			if amount <= 0 {
+				if amount < 0 {
+					owed[reverse] = -amount
+				}
+				return
+			}
+		}
+		owed[debtKey{Debtor: debtor, Creditor: creditor, CurrencyCode: currencyCode}] += amount
+	}
+
+	for _, item := range g.expenses {
+		for _, share := range item.Split.Shares {
+			add(share.UserID, item.UserID, item.CurrencyCode, share.Amount)
+		}
+	}
+	// A settlement is a debt in the opposite direction
+	for _, settlement := range g.settlements {
+		add(settlement.ToUserID, settlement.FromUserID, settlement.CurrencyCode, settlement.Amount)
+	}
+	return owed
+}
+
+// balanceBetween returns what debtor owes creditor in the currency, negative if creditor owes debtor
+func balanceBetween(debts map[debtKey]float64, debtor string, creditor string, currencyCode string) float64 {
+	return debts[debtKey{Debtor: debtor, Creditor: creditor, CurrencyCode: currencyCode}] -
+		debts[debtKey{Debtor: creditor, Creditor: debtor, CurrencyCode: currencyCode}]
+}
+
+// settleUp returns a short list of transfers clearing all debts. Within each currency the
+// member owing the most pays the member owed the most, until everyone is at zero, which
+// takes at most one transfer less than the number of members involved.
+func settleUp(debts map[debtKey]float64) []Transfer {
+	net := make(map[string]map[string]float64)
+	for key, amount := range debts {
+		if net[key.CurrencyCode] == nil {
+			net[key.CurrencyCode] = make(map[string]float64)
+		}
+		net[key.CurrencyCode][key.Debtor] -= amount
+		net[key.CurrencyCode][key.Creditor] += amount
+	}
+
+	currencies := make([]string, 0, len(net))
+	for currencyCode := range net {
+		currencies = append(currencies, currencyCode)
+	}
+	sort.Strings(currencies)
+
+	transfers := make([]Transfer, 0)
+	for _, currencyCode := range currencies {
+		type position struct {
+			userID string
+			amount float64
+		}
+		var debtors, creditors []position
+		for userID, amount := range net[currencyCode] {
+			amount = utils.RoundAmount(amount)
+			if amount < 0 {
+				debtors = append(debtors, position{userID, -amount})
+			} else if amount > 0 {
+				creditors = append(creditors, position{userID, amount})
+			}
+		}
+		byAmount := func(positions []position) func(i, j int) bool {
+			return func(i, j int) bool {
+				if positions[i].amount != positions[j].amount {
+					return positions[i].amount > positions[j].amount
+				}
+				return positions[i].userID < positions[j].userID
+			}
+		}
+		sort.Slice(debtors, byAmount(debtors))
+		sort.Slice(creditors, byAmount(creditors))
+
+		for i, j := 0, 0; i < len(debtors) && j < len(creditors); {
+			amount := utils.RoundAmount(math.Min(debtors[i].amount, creditors[j].amount))
+			if amount > 0 {
+				transfers = append(transfers, Transfer{
+					FromUserID:   debtors[i].userID,
+					ToUserID:     creditors[j].userID,
+					Amount:       amount,
+					CurrencyCode: currencyCode,
+				})
+			}
+			debtors[i].amount = utils.RoundAmount(debtors[i].amount - amount)
+			creditors[j].amount = utils.RoundAmount(creditors[j].amount - amount)
+			if debtors[i].amount <= 0 {
+				i++
+			}
+			if creditors[j].amount <= 0 {
+				j++
+			}
+		}
+	}
+	return transfers
+}
+
+// loadMembers returns the name and email of the given users
+func (h *Handler) loadMembers(ctx context.Context, userIDs []string) (map[string]Member, error) {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionUserName)
+	cursor, err := collection.Find(ctx, bson.M{"_id": bson.M{"$in": userIDs}}, options.Find().SetProjection(bson.M{"password_hash": 0}))
+	if err != nil {
+		return nil, err
+	}
+	var users []authentication.User
+	if err = cursor.All(ctx, &users); err != nil {
+		return nil, err
+	}
+
+	members := make(map[string]Member, len(users))
+	for _, user := range users {
+		members[user.ID] = Member{UserID: user.ID, Name: user.Name, Email: user.Email}
+	}
+	return members, nil
+}
+
+// HandleGetBalances returns the net amount between the user and every member of their
+// group, per currency. Settled pairs are omitted.
+func (h *Handler) HandleGetBalances(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	group, err := h.loadGroup(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch split expenses"})
+		return
+	}
+	members, err := h.loadMembers(ctx, group.userIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch members"})
+		return
+	}
+
+	response := GetBalancesResponse{Balances: make([]Balance, 0)}
+	for key, amount := range group.debts() {
+		var other string
+		switch userID {
+		case key.Creditor:
+			other = key.Debtor
+		case key.Debtor:
+			other, amount = key.Creditor, -amount
+		default:
+			continue
+		}
+		if amount = utils.RoundAmount(amount); amount == 0 {
+			continue
+		}
+		member, ok := members[other]
+		if !ok {
+			member = Member{UserID: other}
+		}
+		response.Balances = append(response.Balances, Balance{Member: member, CurrencyCode: key.CurrencyCode, Amount: amount})
+	}
+	sort.Slice(response.Balances, func(i, j int) bool {
+		if response.Balances[i].UserID != response.Balances[j].UserID {
+			return response.Balances[i].UserID < response.Balances[j].UserID
+		}
+		return response.Balances[i].CurrencyCode < response.Balances[j].CurrencyCode
+	})
+
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleGetSettleUp returns a plan of transfers that clears every debt in the user's group
+func (h *Handler) HandleGetSettleUp(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	group, err := h.loadGroup(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch split expenses"})
+		return
+	}
+	members, err := h.loadMembers(ctx, group.userIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch members"})
+		return
+	}
+
+	response := GetSettleUpResponse{
+		Members:   make([]Member, 0, len(group.userIDs)),
+		Transfers: settleUp(group.debts()),
+	}
+	for _, memberID := range group.userIDs {
+		member, ok := members[memberID]
+		if !ok {
+			member = Member{UserID: memberID}
+		}
+		response.Members = append(response.Members, member)
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleCreateSettlement records a payment received by the user from one of their
+// counterparties. Only the recipient may record a payment, so that nobody can clear
+// their own debt.
+func (h *Handler) HandleCreateSettlement(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateSettlementRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+	if req.ToUserID == "" {
+		req.ToUserID = userID
+	}
+	if req.ToUserID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Settlements can only be recorded by the user receiving the payment"})
+		return
+	}
+	if req.FromUserID == req.ToUserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from_user_id must be another user"})
+		return
+	}
+	if req.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "amount must be positive"})
+		return
+	}
+	if req.Date == "" {
+		req.Date = time.Now().Format("2006-01-02")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	group, err := h.loadGroup(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch split expenses"})
+		return
+	}
+	if !group.isMember(req.FromUserID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Settle the whole balance when no amount is given
+	if req.Amount == 0 {
+		req.Amount = utils.RoundAmount(balanceBetween(group.debts(), req.FromUserID, req.ToUserID, req.CurrencyCode))
+		if req.Amount <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing is owed in " + req.CurrencyCode})
+			return
+		}
+	}
+
+	settlement := Settlement{
+		FromUserID:   req.FromUserID,
+		ToUserID:     req.ToUserID,
+		Amount:       utils.RoundAmount(req.Amount),
+		CurrencyCode: req.CurrencyCode,
+		Date:         req.Date,
+		Note:         req.Note,
+		CreatedBy:    userID,
+		CreatedAt:    utils.Timestamp(),
+	}
+	result, err := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionSettlementsName).InsertOne(ctx, settlement)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create settlement"})
+		return
+	}
+	settlement.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	c.JSON(http.StatusCreated, settlement)
+}
+
+// pendingShareFilter matches the pending shares of the user, given by user ID or by email
+func pendingShareFilter(user authentication.User) bson.M {
+	return bson.M{
+		"pending": true,
+		"$or":     bson.A{bson.M{"user_id": user.ID}, bson.M{"email": user.Email}},
+	}
+}
+
+// HandleGetPendingShares lists the split expenses other users shared with the user that
+// wait for them to accept their share
+func (h *Handler) HandleGetPendingShares(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	var user authentication.User
+	if err := database.Collection(h.config.CollectionUserName).FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch user"})
+		return
+	}
+
+	cursor, err := database.Collection(h.config.CollectionExpensesName).Find(ctx, utils.NotDeleted(bson.M{
+		"user_id":      bson.M{"$ne": userID},
+		"split.shares": bson.M{"$elemMatch": pendingShareFilter(user)},
+	}), options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch split expenses"})
+		return
+	}
+	var expenses []expense.Expense
+	if err = cursor.All(ctx, &expenses); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode split expenses"})
+		return
+	}
+
+	ownerIDs := make([]string, 0, len(expenses))
+	for _, item := range expenses {
+		ownerIDs = append(ownerIDs, item.UserID)
+	}
+	members, err := h.loadMembers(ctx, ownerIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch members"})
+		return
+	}
+
+	var pending []PendingShare = make([]PendingShare, 0, len(expenses))
+	for _, item := range expenses {
+		index := pendingShareIndex(item, user)
+		if index < 0 {
+			continue
+		}
+		owner, ok := members[item.UserID]
+		if !ok {
+			owner = Member{UserID: item.UserID}
+		}
+		pending = append(pending, PendingShare{
+			ExpenseID:    item.ID,
+			Owner:        owner,
+			Name:         item.Name,
+			Date:         item.Date,
+			Amount:       item.Amount,
+			CurrencyCode: item.CurrencyCode,
+			ShareAmount:  item.Split.Shares[index].Amount,
+		})
+	}
+
+	c.JSON(http.StatusOK, pending)
+}
+
+// pendingShareIndex returns the index of the user's pending share in the split of the
+// expense, or -1. A user who already has an accepted share has nothing left to accept.
+func pendingShareIndex(item expense.Expense, user authentication.User) int {
+	if item.Split == nil {
+		return -1
+	}
+	index := -1
+	for i, share := range item.Split.Shares {
+		mine := share.UserID == user.ID || (share.UserID == "" && share.Email == user.Email)
+		if !mine {
+			continue
+		}
+		if !share.Pending {
+			return -1
+		}
+		if index < 0 {
+			index = i
+		}
+	}
+	return index
+}
+
+// HandleAcceptShare accepts the user's pending share of a split expense: from then on the
+// user owes it to the owner of the expense
+func (h *Handler) HandleAcceptShare(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expense ID " + error.Error()})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	var user authentication.User
+	if err := database.Collection(h.config.CollectionUserName).FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch user"})
+		return
+	}
+
+	collection := database.Collection(h.config.CollectionExpensesName)
+	var item expense.Expense
+	err := collection.FindOne(ctx, utils.NotDeleted(bson.M{
+		"_id":          objectId,
+		"user_id":      bson.M{"$ne": userID},
+		"split.shares": bson.M{"$elemMatch": pendingShareFilter(user)},
+	})).Decode(&item)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pending share not found"})
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expense"})
+		return
+	}
+	index := pendingShareIndex(item, user)
+	if index < 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Pending share not found"})
+		return
+	}
+
+	// The split must not have changed since it was read
+	field := "split.shares." + strconv.Itoa(index)
+	filter := utils.MatchVersion(bson.M{"_id": objectId}, item.Version)
+	update := utils.Touch(bson.M{
+		"$set":   bson.M{field + ".user_id": userID},
+		"$unset": bson.M{field + ".email": "", field + ".pending": ""},
+	})
+	result, err := collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not accept share"})
+		return
+	}
+	if result.MatchedCount == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "The expense changed, please try again"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Share accepted successfully"})
+}
+
+// HandleGetSettlements lists the settlements paid or received by the user, most recent first
+func (h *Handler) HandleGetSettlements(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionSettlementsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{bson.M{"from_user_id": userID}, bson.M{"to_user_id": userID}}}
+	findOptions := options.Find().SetSort(bson.D{{Key: "date", Value: -1}, {Key: "_id", Value: -1}})
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch settlements"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var settlements []Settlement = make([]Settlement, 0)
+	if err = cursor.All(ctx, &settlements); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode settlements"})
+		return
+	}
+
+	c.JSON(http.StatusOK, settlements)
+}
+
+// Delete a settlement received by the user, whoever recorded it
+func (h *Handler) HandleDeleteSettlement(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid settlement ID " + error.Error()})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionSettlementsName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": objectId, "to_user_id": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete settlement"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Settlement not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Settlement deleted successfully"})
+}
@@ -0,0 +1,20 @@
+package suggestion
+
+// Suggestion is a candidate category for an expense together with the model's confidence (0-1)
+type Suggestion struct {
+	CategoryID   string  `json:"category_id"`
+	CategoryName string  `json:"category_name,omitempty"`
+	Confidence   float64 `json:"confidence"`
+}
+
+type SuggestCategoryResponse struct {
+	Suggestions  []Suggestion `json:"suggestions"`
+	TrainingSize int          `json:"training_size"`
+}
+
+// trainingExpense is the part of a stored expense the model learns from
+type trainingExpense struct {
+	Name        string `bson:"name"`
+	Description string `bson:"description"`
+	CategoryID  string `bson:"category_id"`
+}
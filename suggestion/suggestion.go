@@ -0,0 +1,313 @@
+package suggestion
+
+import (
+	"context"
+	"math"
+	"my-finance-backend/category"
+	"my-finance-backend/config"
+	"my-finance-backend/utils"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// maxTrainingExpenses caps how many of the most recent categorized expenses are learned from
+	maxTrainingExpenses = 5000
+	// modelTTL is how long a trained model is reused before it is rebuilt from the database
+	modelTTL = 10 * time.Minute
+	// descriptionWeight scales the contribution of description tokens relative to name tokens
+	descriptionWeight = 0.5
+)
+
+// Model is a multinomial naive Bayes classifier mapping expense text to categories,
+// trained per user from their categorized expenses.
+type Model struct {
+	categoryDocs   map[string]int
+	tokenCounts    map[string]map[string]float64
+	categoryTokens map[string]float64
+	vocabulary     map[string]struct{}
+	totalDocs      int
+	trainedAt      time.Time
+}
+
+// Train builds a model from the user's most recent categorized expenses. Only categories
+// that still exist are learned, so that deleted ones are never suggested.
+func Train(ctx context.Context, mongoClient *mongo.Client, config *config.Config, userID string) (*Model, error) {
+	categoryCollection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionCategoriesName)
+	categoryCursor, err := categoryCollection.Find(ctx, utils.NotDeleted(bson.M{"user_id": userID}),
+		options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return nil, err
+	}
+	var categories []category.Category
+	if err = categoryCursor.All(ctx, &categories); err != nil {
+		return nil, err
+	}
+	categoryIDs := make(bson.A, 0, len(categories))
+	for _, cat := range categories {
+		categoryIDs = append(categoryIDs, cat.ID)
+	}
+
+	collection := mongoClient.Database(config.DatabaseName).Collection(config.CollectionExpensesName)
+
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "date", Value: -1}}).
+		SetLimit(maxTrainingExpenses).
+		SetProjection(bson.M{"name": 1, "description": 1, "category_id": 1})
+	cursor, err := collection.Find(ctx, utils.NotDeleted(bson.M{
+		"user_id":     userID,
+		"category_id": bson.M{"$in": categoryIDs},
+	}), findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	model := &Model{
+		categoryDocs:   make(map[string]int),
+		tokenCounts:    make(map[string]map[string]float64),
+		categoryTokens: make(map[string]float64),
+		vocabulary:     make(map[string]struct{}),
+		trainedAt:      time.Now(),
+	}
+	for cursor.Next(ctx) {
+		var expense trainingExpense
+		if err := cursor.Decode(&expense); err != nil {
+			return nil, err
+		}
+		model.add(expense)
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func (m *Model) add(expense trainingExpense) {
+	m.totalDocs++
+	m.categoryDocs[expense.CategoryID]++
+	if m.tokenCounts[expense.CategoryID] == nil {
+		m.tokenCounts[expense.CategoryID] = make(map[string]float64)
+	}
+
+	for token, weight := range weightedTokens(expense.Name, expense.Description) {
+		m.tokenCounts[expense.CategoryID][token] += weight
+		m.categoryTokens[expense.CategoryID] += weight
+		m.vocabulary[token] = struct{}{}
+	}
+}
+
+// Size returns the number of expenses the model was trained on
+func (m *Model) Size() int {
+	return m.totalDocs
+}
+
+// Suggest returns up to limit categories for the given expense text, most likely first.
+// Confidences are posterior probabilities and sum to 1 over all known categories.
+func (m *Model) Suggest(name string, description string, limit int) []Suggestion {
+	suggestions := make([]Suggestion, 0)
+	tokens := weightedTokens(name, description)
+	if m.totalDocs == 0 || len(tokens) == 0 {
+		return suggestions
+	}
+
+	vocabularySize := float64(len(m.vocabulary))
+	logScores := make(map[string]float64, len(m.categoryDocs))
+	maxScore := math.Inf(-1)
+	for categoryID, docs := range m.categoryDocs {
+		score := math.Log(float64(docs) / float64(m.totalDocs))
+		denominator := m.categoryTokens[categoryID] + vocabularySize
+		for token, weight := range tokens {
+			// Laplace smoothing so unseen tokens do not zero out a category
+			score += weight * math.Log((m.tokenCounts[categoryID][token]+1)/denominator)
+		}
+		logScores[categoryID] = score
+		if score > maxScore {
+			maxScore = score
+		}
+	}
+
+	// Normalize the log scores into probabilities
+	var total float64
+	for categoryID, score := range logScores {
+		probability := math.Exp(score - maxScore)
+		logScores[categoryID] = probability
+		total += probability
+	}
+	for categoryID, probability := range logScores {
+		suggestions = append(suggestions, Suggestion{
+			CategoryID: categoryID,
+			Confidence: probability / total,
+		})
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].Confidence == suggestions[j].Confidence {
+			return suggestions[i].CategoryID < suggestions[j].CategoryID
+		}
+		return suggestions[i].Confidence > suggestions[j].Confidence
+	})
+	if limit > 0 && len(suggestions) > limit {
+		suggestions = suggestions[:limit]
+	}
+	return suggestions
+}
+
+// Best returns the most likely category if its confidence reaches minConfidence. Without
+// at least two categories or a token seen in training, the confidence would only reflect
+// how common each category is, so nothing is returned.
+func (m *Model) Best(name string, description string, minConfidence float64) (Suggestion, bool) {
+	if len(m.categoryDocs) < 2 || !m.knowsAnyToken(name, description) {
+		return Suggestion{}, false
+	}
+	suggestions := m.Suggest(name, description, 1)
+	if len(suggestions) == 0 || suggestions[0].Confidence < minConfidence {
+		return Suggestion{}, false
+	}
+	return suggestions[0], true
+}
+
+// knowsAnyToken reports whether a token of the expense text was seen in training
+func (m *Model) knowsAnyToken(name string, description string) bool {
+	for token := range weightedTokens(name, description) {
+		if _, ok := m.vocabulary[token]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+func weightedTokens(name string, description string) map[string]float64 {
+	tokens := make(map[string]float64)
+	for _, token := range utils.Tokenize(name) {
+		tokens[token] += 1
+	}
+	for _, token := range utils.Tokenize(description) {
+		tokens[token] += descriptionWeight
+	}
+	return tokens
+}
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+
+	mu     sync.Mutex
+	models map[string]*Model
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+		models:      make(map[string]*Model),
+	}
+}
+
+// model returns the cached model of a user, retraining it once it is older than modelTTL
+func (h *Handler) model(ctx context.Context, userID string) (*Model, error) {
+	h.mu.Lock()
+	cached, ok := h.models[userID]
+	h.mu.Unlock()
+	if ok && time.Since(cached.trainedAt) < modelTTL {
+		return cached, nil
+	}
+
+	model, err := Train(ctx, h.mongoClient, h.config, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	h.mu.Lock()
+	h.models[userID] = model
+	h.mu.Unlock()
+	return model, nil
+}
+
+// HandleSuggestCategory suggests categories for an expense name (and optional description)
+// based on the user's categorized history
+func (h *Handler) HandleSuggestCategory(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	name := c.Query("name")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "name parameter is required"})
+		return
+	}
+
+	limit := 3
+	if limitStr := c.Query("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit parameter"})
+			return
+		}
+		limit = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	model, err := h.model(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not train suggestion model"})
+		return
+	}
+
+	suggestions := model.Suggest(name, c.Query("description"), 0)
+
+	// Attach category names, leaving out categories deleted since the model was trained
+	objectIDs := make([]primitive.ObjectID, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		if objectID, err := utils.StringToObjectId(suggestion.CategoryID); err == nil {
+			objectIDs = append(objectIDs, objectID)
+		}
+	}
+	names := make(map[string]string)
+	if len(objectIDs) > 0 {
+		collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
+		cursor, err := collection.Find(ctx, utils.NotDeleted(bson.M{"_id": bson.M{"$in": objectIDs}, "user_id": userID}))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch categories"})
+			return
+		}
+		var categories []category.Category
+		if err = cursor.All(ctx, &categories); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode categories"})
+			return
+		}
+		for _, cat := range categories {
+			names[cat.ID] = cat.Name
+		}
+	}
+	active := make([]Suggestion, 0, limit)
+	for _, suggestion := range suggestions {
+		categoryName, ok := names[suggestion.CategoryID]
+		if !ok {
+			continue
+		}
+		suggestion.CategoryName = categoryName
+		if active = append(active, suggestion); len(active) == limit {
+			break
+		}
+	}
+	suggestions = active
+
+	c.JSON(http.StatusOK, SuggestCategoryResponse{
+		Suggestions:  suggestions,
+		TrainingSize: model.Size(),
+	})
+}
@@ -0,0 +1,105 @@
+package synchronization
+
+import (
+	"my-finance-backend/category"
+	"my-finance-backend/expense"
+	"my-finance-backend/tag"
+)
+
+const (
+	OperationUpsert = "upsert"
+	OperationDelete = "delete"
+
+	ResultApplied  = "applied"
+	ResultConflict = "conflict"
+	ResultError    = "error"
+)
+
+// Tombstone tells the client that a document was deleted
+type Tombstone struct {
+	ID        string `json:"id"`
+	DeletedAt string `json:"deleted_at"`
+	Version   int64  `json:"version"`
+}
+
+type ExpenseChanges struct {
+	Updated []expense.Expense `json:"updated"`
+	Deleted []Tombstone       `json:"deleted"`
+}
+
+type CategoryChanges struct {
+	Updated []category.Category `json:"updated"`
+	Deleted []Tombstone         `json:"deleted"`
+}
+
+type TagChanges struct {
+	Updated []tag.Tag `json:"updated"`
+}
+
+// PullResponse lists everything that changed since the given sync token. When
+// FullResync is set the client must drop its local copy and use this response instead.
+type PullResponse struct {
+	SyncToken  string          `json:"sync_token"`
+	FullResync bool            `json:"full_resync"`
+	Expenses   ExpenseChanges  `json:"expenses"`
+	Categories CategoryChanges `json:"categories"`
+	Tags       TagChanges      `json:"tags"`
+}
+
+type ExpenseData struct {
+	Amount       float64            `json:"amount"`
+	CategoryID   string             `json:"category_id"`
+	CurrencyCode string             `json:"currency_code"`
+	Name         string             `json:"name"`
+	Description  string             `json:"description"`
+	Date         string             `json:"date"`
+	TagIDs       []string           `json:"tag_ids"`
+	LineItems    []expense.LineItem `json:"line_items"`
+}
+
+type CategoryData struct {
+	Name     string `json:"name"`
+	Color    string `json:"color"`
+	IconName string `json:"icon_name"`
+}
+
+// ExpenseChange is an offline edit made by the client. Upserts without an ID create
+// a new expense; changes to an existing expense require BaseVersion, the server version
+// the client edited, and a mismatch is reported as a conflict instead of overwriting
+// the newer server copy.
+type ExpenseChange struct {
+	ClientID    string       `json:"client_id"`
+	ID          string       `json:"id"`
+	Operation   string       `json:"op"`
+	BaseVersion *int64       `json:"base_version"`
+	Data        *ExpenseData `json:"data"`
+}
+
+// CategoryChange is an offline edit of a category, with the same rules as ExpenseChange
+type CategoryChange struct {
+	ClientID    string        `json:"client_id"`
+	ID          string        `json:"id"`
+	Operation   string        `json:"op"`
+	BaseVersion *int64        `json:"base_version"`
+	Data        *CategoryData `json:"data"`
+}
+
+type PushRequest struct {
+	Expenses   []ExpenseChange  `json:"expenses"`
+	Categories []CategoryChange `json:"categories"`
+}
+
+// ChangeResult is the outcome of one pushed change. On conflict Server holds the current server copy.
+type ChangeResult struct {
+	ClientID string      `json:"client_id,omitempty"`
+	ID       string      `json:"id,omitempty"`
+	Status   string      `json:"status"`
+	Version  int64       `json:"version,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Server   interface{} `json:"server,omitempty"`
+}
+
+type PushResponse struct {
+	Expenses   []ChangeResult `json:"expenses"`
+	Categories []ChangeResult `json:"categories"`
+}
@@ -0,0 +1,501 @@
+package synchronization
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"my-finance-backend/account"
+	"my-finance-backend/audit"
+	"my-finance-backend/category"
+	"my-finance-backend/config"
+	"my-finance-backend/event"
+	"my-finance-backend/expense"
+	"my-finance-backend/tag"
+	"my-finance-backend/utils"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// syncOverlap is subtracted from the sync token when querying, so that writes committed
+// while a previous pull was running are not missed. Clients apply changes by ID and
+// version, so receiving a document twice is harmless.
+const syncOverlap = 5 * time.Second
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+	bus         *event.Bus
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config, bus *event.Bus) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+		bus:         bus,
+	}
+}
+
+// recordChange writes an audit entry for a pushed change and notifies the user's other clients
+func (h *Handler) recordChange(ctx context.Context, c *gin.Context, entityType string, entityID string, action string, userID string, version int64, before interface{}, after interface{}) {
+	audit.Record(ctx, h.mongoClient, h.config, c, entityType, entityID, action, before, after)
+
+	data := after
+	if data == nil {
+		data = before
+	}
+	h.bus.Publish(event.Event{
+		Type:       event.ChangeType(entityType, action),
+		UserID:     userID,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Version:    version,
+		Data:       data,
+	})
+}
+
+func encodeSyncToken(timestamp string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(timestamp))
+}
+
+func decodeSyncToken(token string) (time.Time, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(utils.TimestampLayout, string(data))
+}
+
+// HandlePull returns the expenses, categories and tags created, updated or deleted since
+// the since token of a previous pull. Without a token, or when the token is older than
+// the trash retention (tombstones may be gone), a full snapshot is returned.
+func (h *Handler) HandlePull(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	// The token is taken before querying so nothing written meanwhile is skipped next time
+	response := PullResponse{
+		SyncToken:  encodeSyncToken(utils.Timestamp()),
+		Expenses:   ExpenseChanges{Updated: make([]expense.Expense, 0), Deleted: make([]Tombstone, 0)},
+		Categories: CategoryChanges{Updated: make([]category.Category, 0), Deleted: make([]Tombstone, 0)},
+		Tags:       TagChanges{Updated: make([]tag.Tag, 0)},
+	}
+
+	since := ""
+	if token := c.Query("since"); token != "" {
+		sinceTime, err := decodeSyncToken(token)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid sync token"})
+			return
+		}
+		if time.Since(sinceTime) < time.Duration(h.config.TrashRetentionDays)*24*time.Hour {
+			since = sinceTime.Add(-syncOverlap).Format(utils.TimestampLayout)
+		}
+	}
+	response.FullResync = since == ""
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	filter := bson.M{"user_id": userID}
+	if since != "" {
+		filter[utils.UpdatedAtField] = bson.M{"$gt": since}
+	} else {
+		// A full snapshot does not need tombstones
+		filter = utils.NotDeleted(filter)
+	}
+
+	// Expenses
+	cursor, err := database.Collection(h.config.CollectionExpensesName).Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expenses"})
+		return
+	}
+	var expenses []expense.Expense
+	if err = cursor.All(ctx, &expenses); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode expenses"})
+		return
+	}
+	for _, item := range expenses {
+		if item.DeletedAt != "" {
+			response.Expenses.Deleted = append(response.Expenses.Deleted, Tombstone{ID: item.ID, DeletedAt: item.DeletedAt, Version: item.Version})
+		} else {
+			response.Expenses.Updated = append(response.Expenses.Updated, item)
+		}
+	}
+
+	// Categories
+	cursor, err = database.Collection(h.config.CollectionCategoriesName).Find(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch categories"})
+		return
+	}
+	var categories []category.Category
+	if err = cursor.All(ctx, &categories); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode categories"})
+		return
+	}
+	for _, item := range categories {
+		if item.DeletedAt != "" {
+			response.Categories.Deleted = append(response.Categories.Deleted, Tombstone{ID: item.ID, DeletedAt: item.DeletedAt, Version: item.Version})
+		} else {
+			response.Categories.Updated = append(response.Categories.Updated, item)
+		}
+	}
+
+	// Tags are shared between users and cannot be deleted
+	tagFilter := bson.M{}
+	if since != "" {
+		tagFilter[utils.UpdatedAtField] = bson.M{"$gt": since}
+	}
+	cursor, err = database.Collection(h.config.CollectionTagsName).Find(ctx, tagFilter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch tags"})
+		return
+	}
+	if err = cursor.All(ctx, &response.Tags.Updated); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// HandlePush applies a batch of offline changes. Categories are applied first, so new
+// expenses may reference a new category by its client_id. Each change is reported as
+// applied, conflict (with the server copy) or error; the batch is not atomic.
+func (h *Handler) HandlePush(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req PushRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	response := PushResponse{
+		Expenses:   make([]ChangeResult, 0, len(req.Expenses)),
+		Categories: make([]ChangeResult, 0, len(req.Categories)),
+	}
+
+	// Server IDs of categories created in this batch, by client ID
+	createdCategories := make(map[string]string)
+	for _, change := range req.Categories {
+		result := h.applyCategoryChange(ctx, c, userID, change)
+		if result.Status == ResultApplied && change.ID == "" && change.ClientID != "" {
+			createdCategories[change.ClientID] = result.ID
+		}
+		response.Categories = append(response.Categories, result)
+	}
+
+	for _, change := range req.Expenses {
+		if change.Data != nil {
+			if serverID, ok := createdCategories[change.Data.CategoryID]; ok {
+				change.Data.CategoryID = serverID
+			}
+			for i, item := range change.Data.LineItems {
+				if serverID, ok := createdCategories[item.CategoryID]; ok {
+					change.Data.LineItems[i].CategoryID = serverID
+				}
+			}
+		}
+		response.Expenses = append(response.Expenses, h.applyExpenseChange(ctx, c, userID, change))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+func errorResult(clientID string, id string, err error) ChangeResult {
+	return ChangeResult{ClientID: clientID, ID: id, Status: ResultError, Error: err.Error()}
+}
+
+// checkCategory verifies that a category exists and belongs to the user
+func (h *Handler) checkCategory(ctx context.Context, userID string, categoryID string) error {
+	objectId, err := utils.StringToObjectId(categoryID)
+	if err != nil {
+		return errors.New("Invalid category ID")
+	}
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
+	count, err := collection.CountDocuments(ctx, utils.NotDeleted(bson.M{"_id": objectId, "user_id": userID}))
+	if err != nil {
+		return errors.New("Could not fetch category")
+	}
+	if count == 0 {
+		return errors.New("Category not found")
+	}
+	return nil
+}
+
+func (h *Handler) applyExpenseChange(ctx context.Context, c *gin.Context, userID string, change ExpenseChange) ChangeResult {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionExpensesName)
+
+	if change.Operation == OperationUpsert {
+		if change.Data == nil || strings.TrimSpace(change.Data.Name) == "" || change.Data.CurrencyCode == "" {
+			return errorResult(change.ClientID, change.ID, errors.New("data with name and currency_code is required"))
+		}
+		if change.Data.CategoryID != "" {
+			if err := h.checkCategory(ctx, userID, change.Data.CategoryID); err != nil {
+				return errorResult(change.ClientID, change.ID, err)
+			}
+		}
+		if len(change.Data.LineItems) > 0 {
+			if err := expense.ValidateLineItems(ctx, h.mongoClient, h.config, userID, change.Data.LineItems, change.Data.Amount); err != nil {
+				return errorResult(change.ClientID, change.ID, err)
+			}
+		}
+		if change.Data.Date == "" {
+			change.Data.Date = time.Now().Format("2006-01-02")
+		}
+	} else if change.Operation != OperationDelete {
+		return errorResult(change.ClientID, change.ID, errors.New("op must be upsert or delete"))
+	}
+
+	// Create
+	if change.ID == "" {
+		if change.Operation == OperationDelete {
+			return errorResult(change.ClientID, "", errors.New("id is required to delete"))
+		}
+		created := expense.Expense{
+			UserID:       userID,
+			CategoryID:   change.Data.CategoryID,
+			Amount:       change.Data.Amount,
+			CurrencyCode: change.Data.CurrencyCode,
+			Name:         change.Data.Name,
+			Description:  change.Data.Description,
+			Date:         change.Data.Date,
+			TagIDs:       change.Data.TagIDs,
+			LineItems:    change.Data.LineItems,
+			SearchText:   expense.BuildSearchText(change.Data.Name, change.Data.Description),
+			Version:      1,
+			UpdatedAt:    utils.Timestamp(),
+		}
+		result, err := collection.InsertOne(ctx, created)
+		if err != nil {
+			return errorResult(change.ClientID, "", errors.New("Could not create expense"))
+		}
+		created.ID = result.InsertedID.(primitive.ObjectID).Hex()
+		h.recordChange(ctx, c, audit.EntityExpense, created.ID, audit.ActionCreate, userID, created.Version, nil, &created)
+		return ChangeResult{ClientID: change.ClientID, ID: created.ID, Status: ResultApplied, Version: created.Version}
+	}
+
+	objectId, err := utils.StringToObjectId(change.ID)
+	if err != nil {
+		return errorResult(change.ClientID, change.ID, errors.New("Invalid expense ID"))
+	}
+	if change.BaseVersion == nil {
+		return errorResult(change.ClientID, change.ID, errors.New("base_version is required to change an existing expense"))
+	}
+
+	var current expense.Expense
+	err = collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return errorResult(change.ClientID, change.ID, errors.New("Expense not found"))
+	} else if err != nil {
+		return errorResult(change.ClientID, change.ID, errors.New("Could not fetch expense"))
+	}
+	if *change.BaseVersion != current.Version {
+		return ChangeResult{ClientID: change.ClientID, ID: change.ID, Status: ResultConflict, Version: current.Version, Server: current}
+	}
+	if current.ReconciliationID != "" {
+		return errorResult(change.ClientID, change.ID, expense.ErrReconciled)
+	}
+
+	var update bson.M
+	action := audit.ActionUpdate
+	if change.Operation == OperationDelete {
+		if current.DeletedAt != "" {
+			return ChangeResult{ClientID: change.ClientID, ID: change.ID, Status: ResultApplied, Version: current.Version}
+		}
+		update = utils.SoftDeleteUpdate()
+		action = audit.ActionDelete
+	} else {
+		// Money recorded in an account must stay in its currency
+		if current.AccountID != "" && current.CurrencyCode != change.Data.CurrencyCode {
+			if err := account.Check(ctx, h.mongoClient, h.config, userID, current.AccountID, change.Data.CurrencyCode); err != nil {
+				return errorResult(change.ClientID, change.ID, err)
+			}
+		}
+		set := bson.M{
+			"category_id":   change.Data.CategoryID,
+			"amount":        change.Data.Amount,
+			"currency_code": change.Data.CurrencyCode,
+			"name":          change.Data.Name,
+			"description":   change.Data.Description,
+			"date":          change.Data.Date,
+			"tag_ids":       change.Data.TagIDs,
+			"search_text":   expense.BuildSearchText(change.Data.Name, change.Data.Description),
+		}
+		// Keep the split shares in line with the amount
+		if current.Split != nil && change.Data.Amount != current.Amount {
+			split, err := current.Split.WithAmount(change.Data.Amount, userID)
+			if err != nil {
+				return errorResult(change.ClientID, change.ID, err)
+			}
+			set["split"] = split
+		}
+		unset := bson.M{}
+		if len(change.Data.LineItems) > 0 {
+			set["line_items"] = change.Data.LineItems
+		} else {
+			unset["line_items"] = ""
+		}
+		if current.DeletedAt != "" {
+			// Editing an expense deleted on the server brings it back
+			unset[utils.DeletedAtField] = ""
+		}
+		update = bson.M{"$set": set}
+		if len(unset) > 0 {
+			update["$unset"] = unset
+		}
+	}
+
+	var after expense.Expense
+	err = collection.FindOneAndUpdate(ctx,
+		utils.MatchVersion(bson.M{"_id": objectId, "user_id": userID}, current.Version),
+		utils.Touch(update),
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+	if err == mongo.ErrNoDocuments {
+		// Changed between the read and the write
+		return ChangeResult{ClientID: change.ClientID, ID: change.ID, Status: ResultConflict, Error: "Expense was modified concurrently"}
+	} else if err != nil {
+		return errorResult(change.ClientID, change.ID, errors.New("Could not update expense"))
+	}
+
+	h.recordChange(ctx, c, audit.EntityExpense, change.ID, action, userID, after.Version, &current, &after)
+	return ChangeResult{ClientID: change.ClientID, ID: change.ID, Status: ResultApplied, Version: after.Version}
+}
+
+func (h *Handler) applyCategoryChange(ctx context.Context, c *gin.Context, userID string, change CategoryChange) ChangeResult {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionCategoriesName)
+
+	if change.Operation == OperationUpsert {
+		if change.Data == nil || strings.TrimSpace(change.Data.Name) == "" {
+			return errorResult(change.ClientID, change.ID, errors.New("data with name is required"))
+		}
+		change.Data.Name = strings.TrimSpace(change.Data.Name)
+	} else if change.Operation != OperationDelete {
+		return errorResult(change.ClientID, change.ID, errors.New("op must be upsert or delete"))
+	}
+
+	// nameTaken reports whether another active category of the user already has the name
+	nameTaken := func(name string, exceptID interface{}) bool {
+		filter := utils.NotDeleted(bson.M{"name": name, "user_id": userID})
+		if exceptID != nil {
+			filter["_id"] = bson.M{"$ne": exceptID}
+		}
+		count, err := collection.CountDocuments(ctx, filter)
+		return err != nil || count > 0
+	}
+
+	// Create
+	if change.ID == "" {
+		if change.Operation == OperationDelete {
+			return errorResult(change.ClientID, "", errors.New("id is required to delete"))
+		}
+		if change.Data.Name == category.DefaultCategoryName {
+			return errorResult(change.ClientID, "", errors.New("Cannot create category with reserved name 'Default'"))
+		}
+		if nameTaken(change.Data.Name, nil) {
+			return errorResult(change.ClientID, "", errors.New("Category with this name already exists"))
+		}
+		created := category.Category{
+			UserID:    userID,
+			Name:      change.Data.Name,
+			Color:     change.Data.Color,
+			IconName:  change.Data.IconName,
+			Version:   1,
+			UpdatedAt: utils.Timestamp(),
+		}
+		result, err := collection.InsertOne(ctx, created)
+		if err != nil {
+			return errorResult(change.ClientID, "", errors.New("Could not create category"))
+		}
+		created.ID = result.InsertedID.(primitive.ObjectID).Hex()
+		h.recordChange(ctx, c, audit.EntityCategory, created.ID, audit.ActionCreate, userID, created.Version, nil, &created)
+		return ChangeResult{ClientID: change.ClientID, ID: created.ID, Status: ResultApplied, Version: created.Version}
+	}
+
+	objectId, err := utils.StringToObjectId(change.ID)
+	if err != nil {
+		return errorResult(change.ClientID, change.ID, errors.New("Invalid category ID"))
+	}
+	if change.BaseVersion == nil {
+		return errorResult(change.ClientID, change.ID, errors.New("base_version is required to change an existing category"))
+	}
+
+	var current category.Category
+	err = collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&current)
+	if err == mongo.ErrNoDocuments {
+		return errorResult(change.ClientID, change.ID, errors.New("Category not found"))
+	} else if err != nil {
+		return errorResult(change.ClientID, change.ID, errors.New("Could not fetch category"))
+	}
+	if *change.BaseVersion != current.Version {
+		return ChangeResult{ClientID: change.ClientID, ID: change.ID, Status: ResultConflict, Version: current.Version, Server: current}
+	}
+
+	var update bson.M
+	action := audit.ActionUpdate
+	if change.Operation == OperationDelete {
+		if current.Name == category.DefaultCategoryName {
+			return errorResult(change.ClientID, change.ID, errors.New("Cannot delete default category"))
+		}
+		if current.DeletedAt != "" {
+			return ChangeResult{ClientID: change.ClientID, ID: change.ID, Status: ResultApplied, Version: current.Version}
+		}
+		update = utils.SoftDeleteUpdate()
+		action = audit.ActionDelete
+	} else {
+		if change.Data.Name != current.Name {
+			if current.Name == category.DefaultCategoryName {
+				return errorResult(change.ClientID, change.ID, errors.New("Cannot modify default category's name"))
+			}
+			if change.Data.Name == category.DefaultCategoryName {
+				return errorResult(change.ClientID, change.ID, errors.New("Cannot use reserved name 'Default'"))
+			}
+		}
+		// Restoring a deleted category must not duplicate the name of an active one either
+		if (change.Data.Name != current.Name || current.DeletedAt != "") && nameTaken(change.Data.Name, objectId) {
+			return errorResult(change.ClientID, change.ID, errors.New("Category with this name already exists"))
+		}
+		update = bson.M{"$set": bson.M{
+			"name":      change.Data.Name,
+			"color":     change.Data.Color,
+			"icon_name": change.Data.IconName,
+		}}
+		if current.DeletedAt != "" {
+			update["$unset"] = bson.M{utils.DeletedAtField: ""}
+		}
+	}
+
+	var after category.Category
+	err = collection.FindOneAndUpdate(ctx,
+		utils.MatchVersion(bson.M{"_id": objectId, "user_id": userID}, current.Version),
+		utils.Touch(update),
+		options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&after)
+	if err == mongo.ErrNoDocuments {
+		return ChangeResult{ClientID: change.ClientID, ID: change.ID, Status: ResultConflict, Error: "Category was modified concurrently"}
+	} else if err != nil {
+		return errorResult(change.ClientID, change.ID, errors.New("Could not update category"))
+	}
+
+	h.recordChange(ctx, c, audit.EntityCategory, change.ID, action, userID, after.Version, &current, &after)
+	return ChangeResult{ClientID: change.ClientID, ID: change.ID, Status: ResultApplied, Version: after.Version}
+}
@@ -1,8 +1,9 @@
 package tag
 
 type Tag struct {
-	ID   string `json:"id"`
-	Name string `json:"name"`
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	UpdatedAt string `json:"updated_at,omitempty" bson:"updated_at,omitempty"`
 }
 
 type CreateTagRequest struct {
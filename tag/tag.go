@@ -3,6 +3,7 @@ package tag
 import (
 	"context"
 	"my-finance-backend/config"
+	"my-finance-backend/utils"
 	"net/http"
 	"time"
 
@@ -46,8 +47,9 @@ func (h *Handler) HandleCreateTag(c *gin.Context) {
 	}
 
 	tag := Tag{
-		ID:   primitive.NewObjectID().Hex(),
-		Name: req.Name,
+		ID:        primitive.NewObjectID().Hex(),
+		Name:      req.Name,
+		UpdatedAt: utils.Timestamp(),
 	}
 
 	_, err = collection.InsertOne(ctx, tag)
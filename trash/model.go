@@ -0,0 +1,17 @@
+package trash
+
+import (
+	"my-finance-backend/category"
+	"my-finance-backend/expense"
+)
+
+type GetTrashResponse struct {
+	Expenses      []expense.Expense   `json:"expenses"`
+	Categories    []category.Category `json:"categories"`
+	RetentionDays int                 `json:"retention_days"`
+}
+
+type PurgeResponse struct {
+	ExpensesPurged   int64 `json:"expenses_purged"`
+	CategoriesPurged int64 `json:"categories_purged"`
+}
@@ -0,0 +1,177 @@
+package trash
+
+import (
+	"context"
+	"log"
+	"my-finance-backend/attachment"
+	"my-finance-backend/category"
+	"my-finance-backend/config"
+	"my-finance-backend/expense"
+	"my-finance-backend/utils"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// purgeInterval is how often the background purger looks for expired trash
+const purgeInterval = time.Hour
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+	attachments *attachment.Handler
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config, attachments *attachment.Handler) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+		attachments: attachments,
+	}
+}
+
+// HandleGetTrash lists the user's trashed expenses and categories, most recently deleted first
+func (h *Handler) HandleGetTrash(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	filter := utils.OnlyDeleted(bson.M{"user_id": userID})
+	findOptions := options.Find().SetSort(bson.D{{Key: utils.DeletedAtField, Value: -1}})
+
+	response := GetTrashResponse{
+		Expenses:      make([]expense.Expense, 0),
+		Categories:    make([]category.Category, 0),
+		RetentionDays: h.config.TrashRetentionDays,
+	}
+
+	cursor, err := database.Collection(h.config.CollectionExpensesName).Find(ctx, filter, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch expenses"})
+		return
+	}
+	if err = cursor.All(ctx, &response.Expenses); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode expenses"})
+		return
+	}
+
+	cursor, err = database.Collection(h.config.CollectionCategoriesName).Find(ctx, filter, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch categories"})
+		return
+	}
+	if err = cursor.All(ctx, &response.Categories); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode categories"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleEmptyTrash permanently deletes everything in the user's trash
+func (h *Handler) HandleEmptyTrash(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	response, err := h.purge(ctx, utils.OnlyDeleted(bson.M{"user_id": userID}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not empty trash"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// PurgeExpired permanently deletes trashed documents older than the retention period
+func (h *Handler) PurgeExpired(ctx context.Context) (PurgeResponse, error) {
+	cutoff := time.Now().UTC().AddDate(0, 0, -h.config.TrashRetentionDays).Format(time.RFC3339)
+	return h.purge(ctx, bson.M{utils.DeletedAtField: bson.M{"$lte": cutoff}})
+}
+
+// StartPurger runs PurgeExpired periodically until ctx is cancelled
+func (h *Handler) StartPurger(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+
+		for {
+			purgeCtx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+			result, err := h.PurgeExpired(purgeCtx)
+			cancel()
+			if err != nil {
+				log.Printf("Could not purge trash: %v\n", err)
+			} else if result.ExpensesPurged > 0 || result.CategoriesPurged > 0 {
+				log.Printf("Purged %d expenses and %d categories from trash\n", result.ExpensesPurged, result.CategoriesPurged)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (h *Handler) purge(ctx context.Context, filter bson.M) (PurgeResponse, error) {
+	var response PurgeResponse
+	database := h.mongoClient.Database(h.config.DatabaseName)
+
+	// Attachments go with their expense
+	cursor, err := database.Collection(h.config.CollectionExpensesName).Find(ctx, filter, options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return response, err
+	}
+	var expenses []expense.Expense
+	if err = cursor.All(ctx, &expenses); err != nil {
+		return response, err
+	}
+	expenseIDs := make([]string, 0, len(expenses))
+	for _, expense := range expenses {
+		expenseIDs = append(expenseIDs, expense.ID)
+	}
+	if err := h.attachments.DeleteForExpenses(ctx, expenseIDs); err != nil {
+		return response, err
+	}
+
+	result, err := database.Collection(h.config.CollectionExpensesName).DeleteMany(ctx, bson.M{"_id": bson.M{"$in": expenseObjectIDs(expenseIDs)}})
+	if err != nil {
+		return response, err
+	}
+	response.ExpensesPurged = result.DeletedCount
+
+	result, err = database.Collection(h.config.CollectionCategoriesName).DeleteMany(ctx, filter)
+	if err != nil {
+		return response, err
+	}
+	response.CategoriesPurged = result.DeletedCount
+
+	return response, nil
+}
+
+func expenseObjectIDs(expenseIDs []string) []primitive.ObjectID {
+	objectIds := make([]primitive.ObjectID, 0, len(expenseIDs))
+	for _, id := range expenseIDs {
+		if objectId, err := utils.StringToObjectId(id); err == nil {
+			objectIds = append(objectIds, objectId)
+		}
+	}
+	return objectIds
+}
@@ -0,0 +1,12 @@
+package utils
+
+import "time"
+
+// AddMonths moves date by whole months onto day, or onto the last day of shorter months
+func AddMonths(date time.Time, months int, day int) time.Time {
+	first := time.Date(date.Year(), date.Month()+time.Month(months), 1, 0, 0, 0, 0, time.UTC)
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, 0, 0, 0, 0, time.UTC)
+}
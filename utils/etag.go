@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var ErrInvalidIfMatch = errors.New("Invalid If-Match header")
+
+// VersionETag formats a document revision as an entity tag
+func VersionETag(id string, version int64) string {
+	return fmt.Sprintf("\"%s-%d\"", id, version)
+}
+
+// IfMatchVersion reads the If-Match header of a request targeting the document id.
+// present is false when the header is missing or "*", in which case any version matches.
+func IfMatchVersion(c *gin.Context, id string) (version int64, present bool, err error) {
+	header := strings.TrimSpace(c.GetHeader("If-Match"))
+	if header == "" || header == "*" {
+		return 0, false, nil
+	}
+
+	tag := strings.Trim(strings.TrimPrefix(header, "W/"), "\"")
+	prefix := id + "-"
+	if !strings.HasPrefix(tag, prefix) {
+		return 0, true, ErrInvalidIfMatch
+	}
+	version, err = strconv.ParseInt(strings.TrimPrefix(tag, prefix), 10, 64)
+	if err != nil {
+		return 0, true, ErrInvalidIfMatch
+	}
+	return version, true, nil
+}
+
+// JSONWithETag writes payload as JSON with an ETag computed from its content. When the
+// request carries a matching If-None-Match header, 304 Not Modified is sent instead.
+func JSONWithETag(c *gin.Context, status int, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not encode response"})
+		return
+	}
+	sum := sha256.Sum256(data)
+	etag := "\"" + hex.EncodeToString(sum[:16]) + "\""
+	writeWithETag(c, status, etag, data)
+}
+
+// JSONWithVersionETag writes payload as JSON with the entity tag of a versioned document,
+// answering 304 Not Modified when If-None-Match matches
+func JSONWithVersionETag(c *gin.Context, status int, id string, version int64, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not encode response"})
+		return
+	}
+	writeWithETag(c, status, VersionETag(id, version), data)
+}
+
+func writeWithETag(c *gin.Context, status int, etag string, data []byte) {
+	c.Header("ETag", etag)
+	if status == http.StatusOK {
+		for _, candidate := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				c.Status(http.StatusNotModified)
+				return
+			}
+		}
+	}
+	c.Data(status, "application/json; charset=utf-8", data)
+}
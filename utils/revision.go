@@ -0,0 +1,47 @@
+package utils
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	// VersionField holds the revision of a document, incremented on every write
+	VersionField = "version"
+	// UpdatedAtField holds the time of the last write to a document, see Timestamp
+	UpdatedAtField = "updated_at"
+
+	// TimestampLayout has a fixed width so that timestamps stored as strings sort chronologically
+	TimestampLayout = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// Timestamp returns the current UTC time formatted with TimestampLayout
+func Timestamp() string {
+	return time.Now().UTC().Format(TimestampLayout)
+}
+
+// MatchVersion adds the condition on the document revision to filter and returns it.
+// Documents written before versioning existed have no version field and count as version 0.
+func MatchVersion(filter bson.M, version int64) bson.M {
+	if version == 0 {
+		filter[VersionField] = bson.M{"$in": bson.A{0, nil}}
+	} else {
+		filter[VersionField] = version
+	}
+	return filter
+}
+
+// Touch marks an update document as a write: it increments the revision and
+// refreshes updated_at. It returns the update document.
+func Touch(update bson.M) bson.M {
+	update["$inc"] = bson.M{VersionField: 1}
+
+	set, ok := update["$set"].(bson.M)
+	if !ok {
+		set = bson.M{}
+		update["$set"] = set
+	}
+	set[UpdatedAtField] = Timestamp()
+	return update
+}
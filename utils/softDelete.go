@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DeletedAtField is set (RFC 3339, UTC) on soft deleted documents. They stay in the
+// trash until restored or purged once the retention period is over.
+const DeletedAtField = "deleted_at"
+
+// NotDeleted adds the condition excluding soft deleted documents to filter and returns it
+func NotDeleted(filter bson.M) bson.M {
+	filter[DeletedAtField] = bson.M{"$exists": false}
+	return filter
+}
+
+// OnlyDeleted adds the condition selecting soft deleted documents to filter and returns it
+func OnlyDeleted(filter bson.M) bson.M {
+	filter[DeletedAtField] = bson.M{"$exists": true}
+	return filter
+}
+
+// SoftDeleteUpdate returns the update document moving a document to the trash
+func SoftDeleteUpdate() bson.M {
+	return bson.M{"$set": bson.M{DeletedAtField: time.Now().UTC().Format(time.RFC3339)}}
+}
+
+// RestoreUpdate returns the update document taking a document out of the trash
+func RestoreUpdate() bson.M {
+	return bson.M{"$unset": bson.M{DeletedAtField: ""}}
+}
@@ -0,0 +1,59 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// RemoveDiacritics strips accents from text, so "Phở bò" becomes "Pho bo".
+// The Vietnamese letter đ has no decomposition and is mapped to d explicitly.
+func RemoveDiacritics(text string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	result, _, err := transform.String(t, text)
+	if err != nil {
+		result = text
+	}
+	return strings.NewReplacer("đ", "d", "Đ", "D").Replace(result)
+}
+
+// Tokenize lowercases text, removes diacritics and splits it into alphanumeric words
+func Tokenize(text string) []string {
+	text = strings.ToLower(RemoveDiacritics(text))
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// Similarity returns how alike two texts are, from 0 (nothing in common) to 1 (equal
+// after normalization). It is the normalized Levenshtein distance of the tokenized texts.
+func Similarity(a string, b string) float64 {
+	left := []rune(strings.Join(Tokenize(a), " "))
+	right := []rune(strings.Join(Tokenize(b), " "))
+	if len(left) == 0 && len(right) == 0 {
+		return 1
+	}
+
+	previous := make([]int, len(right)+1)
+	current := make([]int, len(right)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(left); i++ {
+		current[0] = i
+		for j := 1; j <= len(right); j++ {
+			cost := 1
+			if left[i-1] == right[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+		}
+		previous, current = current, previous
+	}
+
+	longest := max(len(left), len(right))
+	return 1 - float64(previous[len(right)])/float64(longest)
+}
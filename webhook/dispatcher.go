@@ -0,0 +1,324 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"my-finance-backend/event"
+	"my-finance-backend/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	// Headers sent with every delivery. The signature is the hex HMAC-SHA256 of
+	// "<timestamp>.<body>" keyed with the webhook secret, prefixed with "sha256=".
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+
+	// dispatchInterval is how often the dispatcher polls for due retries
+	dispatchInterval = 10 * time.Second
+	// claimTimeout is how long a claimed delivery is hidden from other dispatchers,
+	// so a delivery interrupted by a crash is retried afterwards
+	claimTimeout = time.Minute
+	// deliveryTimeout bounds a single POST to a webhook URL
+	deliveryTimeout = 10 * time.Second
+	// retryBaseDelay is the delay before the first retry, doubled after each failure up to retryMaxDelay
+	retryBaseDelay = 30 * time.Second
+	retryMaxDelay  = 6 * time.Hour
+	// maxResponseBody is how much of the receiver's response is kept in the delivery log
+	maxResponseBody = 512
+	// eventBuffer is how many published events may wait for their deliveries to be queued
+	eventBuffer = 4096
+)
+
+// Sign returns the signature header value for a payload sent at timestamp (unix seconds)
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// retryDelay returns the backoff before the next attempt after the given number of failed attempts
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts && delay < retryMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > retryMaxDelay {
+		delay = retryMaxDelay
+	}
+	return delay
+}
+
+func subscribed(webhook Webhook, eventType string) bool {
+	for _, value := range webhook.Events {
+		if value == EventAll || value == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// HandleEvent hands the event over to the dispatcher, which queues its deliveries off the
+// publishing request. It is registered as a listener of the event bus, so it never blocks:
+// when the buffer is full the event is dropped.
+func (h *Handler) HandleEvent(e event.Event) {
+	select {
+	case h.events <- e:
+	default:
+		log.Printf("Could not queue webhook deliveries for event %s %s: buffer is full\n", e.Type, e.EntityID)
+	}
+}
+
+// queueEvent queues a delivery of the event for every active webhook of its user
+// subscribed to the event type
+func (h *Handler) queueEvent(e event.Event) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	cursor, err := database.Collection(h.config.CollectionWebhooksName).Find(ctx, bson.M{"user_id": e.UserID, "active": true})
+	if err != nil {
+		log.Printf("Could not fetch webhooks for event %s: %v\n", e.Type, err)
+		return
+	}
+	var webhooks []Webhook
+	if err = cursor.All(ctx, &webhooks); err != nil {
+		log.Printf("Could not decode webhooks for event %s: %v\n", e.Type, err)
+		return
+	}
+
+	var payload []byte
+	queued := false
+	for _, webhook := range webhooks {
+		if !subscribed(webhook, e.Type) {
+			continue
+		}
+		if payload == nil {
+			payload, err = json.Marshal(Payload{
+				Type:       e.Type,
+				CreatedAt:  e.Timestamp,
+				EntityType: e.EntityType,
+				EntityID:   e.EntityID,
+				Version:    e.Version,
+				Data:       e.Data,
+			})
+			if err != nil {
+				log.Printf("Could not encode webhook payload for event %s: %v\n", e.Type, err)
+				return
+			}
+		}
+		if _, err := h.enqueue(ctx, webhook, e.Type, payload, true); err != nil {
+			log.Printf("Could not queue webhook delivery for event %s: %v\n", e.Type, err)
+			continue
+		}
+		queued = true
+	}
+
+	if queued {
+		h.wakeDispatcher()
+	}
+}
+
+// enqueue stores a pending delivery. Unless scheduled is set it has no due date, so the
+// dispatcher leaves it to the caller.
+func (h *Handler) enqueue(ctx context.Context, webhook Webhook, eventType string, payload []byte, scheduled bool) (Delivery, error) {
+	delivery := Delivery{
+		WebhookID: webhook.ID,
+		UserID:    webhook.UserID,
+		EventType: eventType,
+		Payload:   string(payload),
+		Status:    DeliveryPending,
+		CreatedAt: utils.Timestamp(),
+		History:   make([]DeliveryAttempt, 0),
+	}
+	if scheduled {
+		delivery.NextAttemptAt = delivery.CreatedAt
+	}
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionWebhookDeliveriesName)
+	result, err := collection.InsertOne(ctx, delivery)
+	if err != nil {
+		return delivery, err
+	}
+	delivery.ID = result.InsertedID.(primitive.ObjectID).Hex()
+	return delivery, nil
+}
+
+func (h *Handler) wakeDispatcher() {
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// StartDispatcher queues the deliveries of published events and delivers queued payloads
+// in the background until ctx is cancelled. New deliveries are sent right away; failed ones
+// are retried with exponential backoff.
+func (h *Handler) StartDispatcher(ctx context.Context) {
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-h.events:
+				h.queueEvent(e)
+			}
+		}
+	}()
+
+	go func() {
+		ticker := time.NewTicker(dispatchInterval)
+		defer ticker.Stop()
+
+		for {
+			h.dispatchDue(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-h.wake:
+			}
+		}
+	}()
+}
+
+// dispatchDue sends every pending delivery whose next attempt is due
+func (h *Handler) dispatchDue(ctx context.Context) {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionWebhookDeliveriesName)
+
+	for ctx.Err() == nil {
+		now := time.Now().UTC()
+		var delivery Delivery
+		err := collection.FindOneAndUpdate(ctx,
+			bson.M{"status": DeliveryPending, "next_attempt_at": bson.M{"$lte": now.Format(utils.TimestampLayout)}},
+			bson.M{"$set": bson.M{"next_attempt_at": now.Add(claimTimeout).Format(utils.TimestampLayout)}},
+			options.FindOneAndUpdate().
+				SetSort(bson.D{{Key: "next_attempt_at", Value: 1}}).
+				SetReturnDocument(options.After),
+		).Decode(&delivery)
+		if err == mongo.ErrNoDocuments {
+			return
+		} else if err != nil {
+			log.Printf("Could not claim webhook delivery: %v\n", err)
+			return
+		}
+
+		if _, err := h.attempt(ctx, delivery, true); err != nil {
+			log.Printf("Could not record webhook delivery %s: %v\n", delivery.ID, err)
+		}
+	}
+}
+
+// attempt posts a claimed delivery to its webhook and records the outcome. Failed
+// attempts are rescheduled when retry is set and attempts remain.
+func (h *Handler) attempt(ctx context.Context, delivery Delivery, retry bool) (Delivery, error) {
+	database := h.mongoClient.Database(h.config.DatabaseName)
+
+	var result DeliveryAttempt
+	var webhook Webhook
+	objectId, err := utils.StringToObjectId(delivery.WebhookID)
+	if err == nil {
+		err = database.Collection(h.config.CollectionWebhooksName).FindOne(ctx, bson.M{"_id": objectId}).Decode(&webhook)
+	}
+	switch {
+	case err == mongo.ErrNoDocuments:
+		result = DeliveryAttempt{At: utils.Timestamp(), Error: "Webhook not found"}
+		retry = false
+	case err != nil:
+		return delivery, err
+	case !webhook.Active:
+		result = DeliveryAttempt{At: utils.Timestamp(), Error: "Webhook is disabled"}
+		retry = false
+	default:
+		result = h.send(ctx, webhook, delivery)
+	}
+
+	delivery.Attempts++
+	delivery.History = append(delivery.History, result)
+	set := bson.M{"attempts": delivery.Attempts}
+	unset := bson.M{}
+	if result.Error == "" {
+		delivery.Status = DeliverySucceeded
+		delivery.CompletedAt = result.At
+		delivery.NextAttemptAt = ""
+		set["completed_at"] = result.At
+		unset["next_attempt_at"] = ""
+	} else if retry && delivery.Attempts < h.config.WebhookMaxAttempts {
+		delivery.NextAttemptAt = time.Now().UTC().Add(retryDelay(delivery.Attempts)).Format(utils.TimestampLayout)
+		set["next_attempt_at"] = delivery.NextAttemptAt
+	} else {
+		delivery.Status = DeliveryFailed
+		delivery.CompletedAt = result.At
+		delivery.NextAttemptAt = ""
+		set["completed_at"] = result.At
+		unset["next_attempt_at"] = ""
+	}
+	set["status"] = delivery.Status
+
+	update := bson.M{"$set": set, "$push": bson.M{"history": result}}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+	deliveryId, err := utils.StringToObjectId(delivery.ID)
+	if err != nil {
+		return delivery, err
+	}
+	_, err = database.Collection(h.config.CollectionWebhookDeliveriesName).UpdateOne(ctx, bson.M{"_id": deliveryId}, update)
+	return delivery, err
+}
+
+// send posts the signed payload and reports the attempt. Any 2xx response is a success.
+func (h *Handler) send(ctx context.Context, webhook Webhook, delivery Delivery) DeliveryAttempt {
+	started := time.Now()
+	result := DeliveryAttempt{At: utils.Timestamp()}
+
+	ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+	defer cancel()
+
+	body := []byte(delivery.Payload)
+	timestamp := strconv.FormatInt(started.Unix(), 10)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "my-finance-webhooks")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := h.httpClient.Do(req)
+	result.DurationMs = time.Since(started).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	defer resp.Body.Close()
+
+	response, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	result.StatusCode = resp.StatusCode
+	result.ResponseBody = string(response)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		result.Error = "Unexpected status " + resp.Status
+	}
+	return result
+}
@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"my-finance-backend/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receiver is a local webhook endpoint failing the first failures requests
+type receiver struct {
+	t        *testing.T
+	secret   string
+	failures int
+	requests int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.requests++
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		r.t.Fatalf("could not read body: %v", err)
+	}
+	expected := Sign(r.secret, req.Header.Get(HeaderTimestamp), body)
+	if signature := req.Header.Get(HeaderSignature); signature != expected {
+		r.t.Errorf("signature = %q, want %q", signature, expected)
+	}
+	if eventType := req.Header.Get(HeaderEvent); eventType != "expense.created" {
+		r.t.Errorf("event header = %q, want expense.created", eventType)
+	}
+	if r.requests <= r.failures {
+		http.Error(w, "try again", http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func testDelivery() Delivery {
+	return Delivery{ID: "delivery", EventType: "expense.created", Payload: `{"type":"expense.created"}`}
+}
+
+func TestSendSignsAndRetries(t *testing.T) {
+	handler := &receiver{t: t, secret: "secret", failures: 2}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	h := NewHandler(nil, &config.Config{WebhookAllowPrivateURLs: true})
+	webhook := Webhook{URL: server.URL, Secret: handler.secret, Active: true}
+
+	for attempt := 1; attempt <= handler.failures; attempt++ {
+		result := h.send(context.Background(), webhook, testDelivery())
+		if result.Error == "" || result.StatusCode != http.StatusServiceUnavailable {
+			t.Fatalf("attempt %d: got status %d and error %q, want a 503 failure", attempt, result.StatusCode, result.Error)
+		}
+	}
+	result := h.send(context.Background(), webhook, testDelivery())
+	if result.Error != "" || result.StatusCode != http.StatusNoContent {
+		t.Fatalf("last attempt: got status %d and error %q, want success", result.StatusCode, result.Error)
+	}
+	if handler.requests != handler.failures+1 {
+		t.Fatalf("receiver got %d requests, want %d", handler.requests, handler.failures+1)
+	}
+}
+
+func TestRetryDelayBacksOff(t *testing.T) {
+	expected := []time.Duration{retryBaseDelay, 2 * retryBaseDelay, 4 * retryBaseDelay, 8 * retryBaseDelay}
+	for i, delay := range expected {
+		if got := retryDelay(i + 1); got != delay {
+			t.Errorf("retryDelay(%d) = %v, want %v", i+1, got, delay)
+		}
+	}
+	if got := retryDelay(100); got != retryMaxDelay {
+		t.Errorf("retryDelay(100) = %v, want %v", got, retryMaxDelay)
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	handler := &receiver{t: t, secret: "secret"}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	h := NewHandler(nil, &config.Config{})
+	result := h.send(context.Background(), Webhook{URL: server.URL, Secret: handler.secret}, testDelivery())
+	if !strings.Contains(result.Error, errBlockedAddress.Error()) {
+		t.Fatalf("got error %q, want the blocked address error", result.Error)
+	}
+	if handler.requests != 0 {
+		t.Fatalf("receiver got %d requests, want none", handler.requests)
+	}
+}
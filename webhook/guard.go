@@ -0,0 +1,63 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// errBlockedAddress is returned for webhook URLs pointing to the server's own network
+var errBlockedAddress = errors.New("url must not point to a loopback, private or link-local address")
+
+// blockedIP reports whether ip is internal to the server: loopback, private, link-local
+// (which includes the 169.254.169.254 cloud metadata endpoint), unspecified or multicast
+func blockedIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// checkHost resolves the host of a webhook URL and rejects it if any of its addresses is internal
+func checkHost(ctx context.Context, host string) error {
+	addresses, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return errors.New("url host could not be resolved")
+	}
+	for _, address := range addresses {
+		if blockedIP(address.IP) {
+			return errBlockedAddress
+		}
+	}
+	return nil
+}
+
+// newHTTPClient returns the client used for deliveries. Unless private addresses are
+// allowed, the address of every connection is checked once resolved, so that neither a
+// DNS change after registration nor a redirect reaches an internal service.
+func newHTTPClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: deliveryTimeout, KeepAlive: 30 * time.Second}
+	if !allowPrivate {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || blockedIP(ip) {
+				return errBlockedAddress
+			}
+			return nil
+		}
+	}
+	return &http.Client{
+		Timeout: deliveryTimeout,
+		Transport: &http.Transport{
+			// No proxy, the connection must go to the checked address
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: deliveryTimeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package webhook
+
+const (
+	// EventAll subscribes a webhook to every event type
+	EventAll = "*"
+	// EventTest is the type of the payload sent by the test-fire endpoint
+	EventTest = "webhook.test"
+
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// SupportedEvents lists the event types a webhook can subscribe to. There is no
+// budget.exceeded event: the application has no budgets yet, so nothing publishes one.
+var SupportedEvents = []string{
+	"expense.created",
+	"expense.updated",
+	"expense.deleted",
+	"expense.restored",
+	"expense.reverted",
+	"category.created",
+	"category.updated",
+	"category.deleted",
+	"category.restored",
+	"import.completed",
+}
+
+// Webhook is a URL receiving signed JSON payloads for the subscribed events of a user
+type Webhook struct {
+	ID          string   `bson:"_id,omitempty" json:"id"`
+	UserID      string   `bson:"user_id" json:"user_id"`
+	URL         string   `bson:"url" json:"url"`
+	Description string   `bson:"description,omitempty" json:"description,omitempty"`
+	Events      []string `bson:"events" json:"events"`
+	Active      bool     `bson:"active" json:"active"`
+	Secret      string   `bson:"secret" json:"-"`
+	CreatedAt   string   `bson:"created_at" json:"created_at"`
+}
+
+// CreateWebhookResponse is the only response that includes the signing secret
+type CreateWebhookResponse struct {
+	Webhook
+	Secret string `json:"secret"`
+}
+
+type CreateWebhookRequest struct {
+	URL         string   `json:"url" binding:"required"`
+	Description string   `json:"description"`
+	Events      []string `json:"events" binding:"required"`
+	Active      *bool    `json:"active"`
+}
+
+type UpdateWebhookRequest struct {
+	URL         string   `json:"url"`
+	Description *string  `json:"description"`
+	Events      []string `json:"events"`
+	Active      *bool    `json:"active"`
+}
+
+// Payload is the JSON body posted to webhooks
+type Payload struct {
+	Type       string      `json:"type"`
+	CreatedAt  string      `json:"created_at"`
+	EntityType string      `json:"entity_type,omitempty"`
+	EntityID   string      `json:"entity_id,omitempty"`
+	Version    int64       `json:"version,omitempty"`
+	Data       interface{} `json:"data,omitempty"`
+}
+
+// Delivery is one queued payload for a webhook and the log of its attempts.
+// The payload is stored serialized so that every retry sends the same bytes.
+type Delivery struct {
+	ID            string            `bson:"_id,omitempty" json:"id"`
+	WebhookID     string            `bson:"webhook_id" json:"webhook_id"`
+	UserID        string            `bson:"user_id" json:"user_id"`
+	EventType     string            `bson:"event_type" json:"event_type"`
+	Payload       string            `bson:"payload" json:"payload"`
+	Status        string            `bson:"status" json:"status"`
+	Attempts      int               `bson:"attempts" json:"attempts"`
+	NextAttemptAt string            `bson:"next_attempt_at,omitempty" json:"next_attempt_at,omitempty"`
+	CreatedAt     string            `bson:"created_at" json:"created_at"`
+	CompletedAt   string            `bson:"completed_at,omitempty" json:"completed_at,omitempty"`
+	History       []DeliveryAttempt `bson:"history" json:"history"`
+}
+
+// DeliveryAttempt records the outcome of one POST to the webhook URL
+type DeliveryAttempt struct {
+	At           string `bson:"at" json:"at"`
+	StatusCode   int    `bson:"status_code,omitempty" json:"status_code,omitempty"`
+	DurationMs   int64  `bson:"duration_ms" json:"duration_ms"`
+	Error        string `bson:"error,omitempty" json:"error,omitempty"`
+	ResponseBody string `bson:"response_body,omitempty" json:"response_body,omitempty"`
+}
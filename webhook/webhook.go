@@ -0,0 +1,419 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"my-finance-backend/config"
+	"my-finance-backend/event"
+	"my-finance-backend/utils"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// defaultDeliveryLimit and maxDeliveryLimit bound the delivery log page size
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+type Handler struct {
+	mongoClient *mongo.Client
+	config      *config.Config
+	httpClient  *http.Client
+	wake        chan struct{}
+	events      chan event.Event
+}
+
+func NewHandler(mongoClient *mongo.Client, config *config.Config) *Handler {
+	return &Handler{
+		mongoClient: mongoClient,
+		config:      config,
+		httpClient:  newHTTPClient(config.WebhookAllowPrivateURLs),
+		wake:        make(chan struct{}, 1),
+		events:      make(chan event.Event, eventBuffer),
+	}
+}
+
+// EnsureIndexes creates the indexes used by the dispatcher and the delivery log
+func (h *Handler) EnsureIndexes(ctx context.Context) error {
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionWebhookDeliveriesName)
+	_, err := collection.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "next_attempt_at", Value: 1}}},
+		{Keys: bson.D{{Key: "webhook_id", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	return err
+}
+
+func generateSecret() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(data), nil
+}
+
+// validateURL checks that a webhook URL is an absolute http or https URL which, unless
+// WebhookAllowPrivateURLs is set, does not resolve to an internal address
+func (h *Handler) validateURL(ctx context.Context, value string) error {
+	parsed, err := url.Parse(value)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Hostname() == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if h.config.WebhookAllowPrivateURLs {
+		return nil
+	}
+	return checkHost(ctx, parsed.Hostname())
+}
+
+func validateEvents(events []string) error {
+	if len(events) == 0 {
+		return errors.New("events must not be empty")
+	}
+	for _, value := range events {
+		if value == EventAll {
+			continue
+		}
+		supported := false
+		for _, eventType := range SupportedEvents {
+			if value == eventType {
+				supported = true
+				break
+			}
+		}
+		if !supported {
+			return errors.New("unsupported event " + value + ", expected one of " + strings.Join(SupportedEvents, ", ") + " or " + EventAll)
+		}
+	}
+	return nil
+}
+
+// HandleCreateWebhook registers a webhook. The signing secret is only returned here.
+func (h *Handler) HandleCreateWebhook(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	webhook := Webhook{
+		UserID:      userID,
+		URL:         strings.TrimSpace(req.URL),
+		Description: req.Description,
+		Events:      req.Events,
+		Active:      req.Active == nil || *req.Active,
+		CreatedAt:   utils.Timestamp(),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := h.validateURL(ctx, webhook.URL); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateEvents(webhook.Events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not generate webhook secret"})
+		return
+	}
+	webhook.Secret = secret
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionWebhooksName)
+	result, err := collection.InsertOne(ctx, webhook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not create webhook"})
+		return
+	}
+	webhook.ID = result.InsertedID.(primitive.ObjectID).Hex()
+
+	c.JSON(http.StatusCreated, CreateWebhookResponse{Webhook: webhook, Secret: secret})
+}
+
+// Get all webhooks for a user
+func (h *Handler) HandleGetWebhooks(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionWebhooksName)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	cursor, err := collection.Find(ctx, bson.M{"user_id": userID}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch webhooks"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var webhooks []Webhook = make([]Webhook, 0)
+	if err = cursor.All(ctx, &webhooks); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode webhooks"})
+		return
+	}
+
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// findWebhook loads a webhook of the user from the id route parameter, writing the error response on failure
+func (h *Handler) findWebhook(ctx context.Context, c *gin.Context, userID string) (Webhook, bool) {
+	var webhook Webhook
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID " + error.Error()})
+		return webhook, false
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionWebhooksName)
+	err := collection.FindOne(ctx, bson.M{"_id": objectId, "user_id": userID}).Decode(&webhook)
+	if err == mongo.ErrNoDocuments {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return webhook, false
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch webhook"})
+		return webhook, false
+	}
+	return webhook, true
+}
+
+// Get single webhook
+func (h *Handler) HandleGetWebhook(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	webhook, ok := h.findWebhook(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+// Update webhook
+func (h *Handler) HandleUpdateWebhook(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	var req UpdateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	webhook, ok := h.findWebhook(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	update := bson.M{}
+	if req.URL != "" {
+		webhook.URL = strings.TrimSpace(req.URL)
+		if err := h.validateURL(ctx, webhook.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		update["url"] = webhook.URL
+	}
+	if req.Description != nil {
+		webhook.Description = *req.Description
+		update["description"] = webhook.Description
+	}
+	if req.Events != nil {
+		if err := validateEvents(req.Events); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		webhook.Events = req.Events
+		update["events"] = webhook.Events
+	}
+	if req.Active != nil {
+		webhook.Active = *req.Active
+		update["active"] = webhook.Active
+	}
+
+	if len(update) > 0 {
+		objectId, _ := utils.StringToObjectId(webhook.ID)
+		collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionWebhooksName)
+		result, err := collection.UpdateOne(ctx, bson.M{"_id": objectId, "user_id": userID}, bson.M{"$set": update})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not update webhook"})
+			return
+		}
+		if result.MatchedCount == 0 {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, webhook)
+}
+
+// Delete webhook along with its delivery log
+func (h *Handler) HandleDeleteWebhook(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	objectId, error := utils.StringToObjectId(c.Param("id"))
+	if error != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID " + error.Error()})
+		return
+	}
+
+	database := h.mongoClient.Database(h.config.DatabaseName)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result, err := database.Collection(h.config.CollectionWebhooksName).DeleteOne(ctx, bson.M{"_id": objectId, "user_id": userID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete webhook"})
+		return
+	}
+	if result.DeletedCount == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+
+	if _, err := database.Collection(h.config.CollectionWebhookDeliveriesName).DeleteMany(ctx, bson.M{"webhook_id": objectId.Hex()}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not delete webhook deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// HandleGetDeliveries lists the deliveries of a webhook, newest first. Optional query
+// parameters: status (pending, succeeded or failed) and limit.
+func (h *Handler) HandleGetDeliveries(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	limit := defaultDeliveryLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxDeliveryLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and " + strconv.Itoa(maxDeliveryLimit)})
+			return
+		}
+		limit = parsed
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	webhook, ok := h.findWebhook(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	filter := bson.M{"webhook_id": webhook.ID}
+	if status := c.Query("status"); status != "" {
+		if status != DeliveryPending && status != DeliverySucceeded && status != DeliveryFailed {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, succeeded or failed"})
+			return
+		}
+		filter["status"] = status
+	}
+
+	collection := h.mongoClient.Database(h.config.DatabaseName).Collection(h.config.CollectionWebhookDeliveriesName)
+	findOptions := options.Find().SetSort(bson.D{{Key: "_id", Value: -1}}).SetLimit(int64(limit))
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not fetch deliveries"})
+		return
+	}
+	defer cursor.Close(ctx)
+
+	var deliveries []Delivery = make([]Delivery, 0)
+	if err = cursor.All(ctx, &deliveries); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not decode deliveries"})
+		return
+	}
+
+	c.JSON(http.StatusOK, deliveries)
+}
+
+// HandleTestWebhook sends a webhook.test payload right away and returns the delivery.
+// Test deliveries are attempted once and not retried.
+func (h *Handler) HandleTestWebhook(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID not found"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), deliveryTimeout+5*time.Second)
+	defer cancel()
+
+	webhook, ok := h.findWebhook(ctx, c, userID)
+	if !ok {
+		return
+	}
+
+	payload, err := json.Marshal(Payload{
+		Type:      EventTest,
+		CreatedAt: utils.Timestamp(),
+		Data:      gin.H{"webhook_id": webhook.ID},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not encode test payload"})
+		return
+	}
+
+	delivery, err := h.enqueue(ctx, webhook, EventTest, payload, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not queue test delivery"})
+		return
+	}
+
+	delivery, err = h.attempt(ctx, delivery, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not send test delivery"})
+		return
+	}
+
+	c.JSON(http.StatusOK, delivery)
+}